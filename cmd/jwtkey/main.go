@@ -0,0 +1,66 @@
+// jwtkey generates a new jwt signing key for the key directory, see internal/utils/jwt-keys.go
+// for the rotation steps.
+//
+//	go run ./cmd/jwtkey -dir ./keys -alg EdDSA
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", "keys", "directory the key is written to")
+	alg := flag.String("alg", "EdDSA", "signing algorithm of the key, EdDSA or RS256")
+	kid := flag.String("kid", time.Now().UTC().Format("20060102-150405"), "key id, the file is named <kid>.pem")
+	flag.Parse()
+
+	var key crypto.Signer
+	var err error
+	switch *alg {
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		log.Fatalf("unsupported algorithm %s, use EdDSA or RS256", *alg)
+	}
+	if err != nil {
+		log.Fatalf("failed to generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		log.Fatalf("failed to encode key: %v", err)
+	}
+
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		log.Fatalf("failed to create %s: %v", *dir, err)
+	}
+	file := filepath.Join(*dir, *kid+".pem")
+	// O_EXCL so an existing key is never overwritten
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Fatalf("failed to create %s: %v", file, err)
+	}
+	if err := pem.Encode(out, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		out.Close()
+		log.Fatalf("failed to write %s: %v", file, err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("failed to write %s: %v", file, err)
+	}
+
+	fmt.Printf("wrote %s\n", file)
+	fmt.Printf("restart to publish it, then set JWT_SIGNING_KEY_ID=%s to sign with it\n", *kid)
+}
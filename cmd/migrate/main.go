@@ -0,0 +1,81 @@
+// migrate applies and reverts the PostgreSQL migrations of internal/db/migrations.
+//
+//	go run ./cmd/migrate up [-steps n]
+//	go run ./cmd/migrate down [-steps n]
+//	go run ./cmd/migrate status
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"e-commerce.com/internal/config"
+	"e-commerce.com/internal/db"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up [-steps n] | down [-steps n] | status")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	steps := flags.Int("steps", 0, "number of migrations to apply or revert, up applies all by default and down reverts one")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		usage()
+	}
+
+	if err := config.Init(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	pool, err := db.GetPostgresPool()
+	if err != nil {
+		log.Fatalf("db conn err : %v", err)
+	}
+	defer pool.Close()
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		applied, err := db.MigrateUp(ctx, pool, *steps)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Printf("applied %d migrations\n", len(applied))
+	case "down":
+		if *steps == 0 {
+			*steps = 1
+		}
+		reverted, err := db.MigrateDown(ctx, pool, *steps)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Printf("reverted %d migrations\n", len(reverted))
+	case "status":
+		statuses, err := db.MigrationStatuses(ctx, pool)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Missing {
+				state += " (no migration file)"
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,70 @@
+// mongoindexes compares the MongoDB indexes with the registry in internal/db and fixes the drift.
+// Pending backfills of internal/db run first, they bring existing documents in line with the
+// indexes, e.g. duplicate reviews are removed before productId_userId_unique is built.
+//
+//	go run ./cmd/mongoindexes -dry-run
+//	go run ./cmd/mongoindexes -recreate-changed -drop-extra
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"e-commerce.com/internal/config"
+	"e-commerce.com/internal/db"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report the drift")
+	recreateChanged := flag.Bool("recreate-changed", false, "drop indexes that differ from the registry and create them as declared")
+	dropExtra := flag.Bool("drop-extra", false, "drop indexes that are not in the registry")
+	flag.Parse()
+
+	if err := config.Init(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	client, err := db.GetMongoClient()
+	if err != nil {
+		log.Fatalf("db conn err : %v", err)
+	}
+	defer db.Cleanup()
+
+	ctx := context.Background()
+	database := db.MongoDatabase(client)
+
+	backfills, err := db.RunMongoBackfills(ctx, database, *dryRun)
+	for _, name := range backfills {
+		if *dryRun {
+			fmt.Printf("backfill %s is pending\n", name)
+		} else {
+			fmt.Printf("applied backfill %s\n", name)
+		}
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	report, err := db.EnsureMongoIndexes(ctx, database, db.IndexSyncOptions{
+		DryRun:          *dryRun,
+		RecreateChanged: *recreateChanged,
+		DropExtra:       *dropExtra,
+	})
+
+	if len(report.Drift) == 0 {
+		fmt.Println("no drift, every index matches the registry")
+	}
+	for _, drift := range report.Drift {
+		fmt.Println(drift)
+	}
+	for _, name := range report.Dropped {
+		fmt.Printf("dropped %s\n", name)
+	}
+	for _, name := range report.Created {
+		fmt.Printf("created %s\n", name)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
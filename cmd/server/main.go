@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
@@ -10,7 +11,9 @@ import (
 	"e-commerce.com/internal/app"
 	"e-commerce.com/internal/config"
 	"e-commerce.com/internal/db"
+	"e-commerce.com/internal/jobs"
 	"e-commerce.com/internal/routes"
+	"e-commerce.com/internal/utils"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -21,6 +24,9 @@ func main() {
 	if err := config.Init(); err != nil {
 		log.Printf("%v", err)
 	}
+	if err := utils.InitJWTKeys(config.AppConfig.JWTKeysDir, config.AppConfig.JWTSigningKeyID, config.AppConfig.JWTSecret, config.AppConfig.JWTAcceptHS256); err != nil {
+		log.Fatalf("failed to load jwt keys: %v", err)
+	}
 	if err := db.InitializeDatabase(); err != nil {
 		log.Printf("db conn err : %v", err)
 	}
@@ -32,6 +38,14 @@ func main() {
 	}
 	defer app.Close()
 
+	reconciliationWorker := jobs.NewReconciliationWorker(app.ReconciliationService, config.AppConfig.ReconcileInterval)
+	reconciliationWorker.Start(context.Background())
+	defer reconciliationWorker.Stop()
+
+	reservationWorker := jobs.NewReservationWorker(app.ReservationService, config.AppConfig.ReservationSweepInterval)
+	reservationWorker.Start(context.Background())
+	defer reservationWorker.Stop()
+
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 
@@ -42,7 +56,7 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization", "X-App-Token"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "X-App-Token", "Idempotency-Key"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -2,19 +2,28 @@
 package app
 
 import (
+	"fmt"
+
+	"e-commerce.com/internal/config"
 	"e-commerce.com/internal/db"
+	"e-commerce.com/internal/gateway"
 	"e-commerce.com/internal/handler"
 	"e-commerce.com/internal/repository"
 	"e-commerce.com/internal/service"
+	"github.com/redis/go-redis/v9"
 )
 
 type App struct {
+	// RedisClient is shared with the middleware, which checks tokens against the revocations in it
+	RedisClient *redis.Client
+
 	// Repositories
 	UserRepo repository.UserRepo
 	// Add other repos as needed
 
 	// Services
-	UserService service.UserService
+	UserService  service.UserService
+	TokenService service.TokenService
 	// Add other services as needed
 
 	// Handlers
@@ -36,33 +45,101 @@ type App struct {
 	CommentHandler *handler.CommentHandler
 	CommentService service.CommentService
 	CommentRepo    repository.CommentRepo
+
+	CartHandler *handler.CartHandler
+	CartService service.CartService
+	CartRepo    repository.CartRepo
+
+	RefundHandler *handler.RefundHandler
+	RefundService service.RefundService
+	RefundRepo    repository.RefundRepo
+
+	ReconciliationHandler *handler.ReconciliationHandler
+	ReconciliationService service.ReconciliationService
+	ReconciliationRepo    repository.ReconciliationRepo
+
+	ReservationService service.ReservationService
+	ReservationRepo    repository.ReservationRepo
+
+	AddressHandler *handler.AddressHandler
+	AddressService service.AddressService
+	AddressRepo    repository.AddressRepo
 }
 
 func New() (*App, error) {
+	mongoClient, err := db.GetMongoClient()
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB connection failed: %w", err)
+	}
+	pgPool, err := db.GetPostgresPool()
+	if err != nil {
+		return nil, fmt.Errorf("PostgreSQL connection failed: %w", err)
+	}
+	redisClient, err := db.GetRedisClient()
+	if err != nil {
+		return nil, fmt.Errorf("redis connection failed: %w", err)
+	}
+	mongoDB := db.MongoDatabase(mongoClient)
 
-	userRepo := repository.NewUserRepository()
-	productRepo := repository.NewProductRepository()
-	paymentRepo := repository.NewPaymentRepository()
-	orderRepo := repository.NewOrderRepository()
-	commentRepo := repository.NewCommentRepositry()
+	userRepo := repository.NewUserRepository(pgPool, redisClient)
+	productRepo := repository.NewProductRepository(mongoDB)
+	paymentRepo := repository.NewPaymentRepository(mongoDB, redisClient)
+	orderRepo := repository.NewOrderRepository(mongoDB)
+	commentRepo := repository.NewCommentRepository(mongoDB)
+	cartRepo := repository.NewCartRepository(redisClient)
+	refundRepo := repository.NewRefundRepository(mongoDB)
+	reconciliationRepo := repository.NewReconciliationRepository(mongoDB)
+	reservationRepo := repository.NewReservationRepository(mongoDB)
+	addressRepo := repository.NewAddressRepository(pgPool)
+	idempotencyRepo := repository.NewIdempotencyRepository(redisClient)
 
 	// Initialize services
-	userService := service.NewUserService(userRepo)
-	productService := service.NewProductService(productRepo)
-	paymentService := service.NewPaymentService(paymentRepo)
-	orderService := service.NewOrderService(orderRepo, productRepo)
-	commentService := service.NewCommnetService(commentRepo)
+	userService := service.NewUserService(userRepo, redisClient)
+	tokenService := service.NewTokenService(userRepo, redisClient, config.AppConfig.AccessTokenTTL, config.AppConfig.RefreshTokenTTL)
+	addressService := service.NewAddressService(addressRepo)
+	commentService := service.NewCommentService(commentRepo, orderRepo, productRepo, config.AppConfig.RequireVerifiedReviews)
+	productService := service.NewProductService(productRepo, commentService)
+	cartService := service.NewCartService(cartRepo, productRepo)
+	pricingService := service.NewPricingService(
+		config.AppConfig.TaxRatePercent,
+		config.AppConfig.DeliveryCharge,
+		config.AppConfig.FreeDeliveryThreshold,
+	)
+	reservationService := service.NewReservationService(
+		reservationRepo,
+		productRepo,
+		paymentRepo,
+		config.AppConfig.StockReservationTTL,
+		config.AppConfig.ReconcileBatchSize,
+	)
+	paymentService := service.NewPaymentService(paymentRepo, orderRepo, productRepo, idempotencyRepo, cartService, pricingService, reservationService, addressService, newPaymentGateways())
+	refundService := service.NewRefundService(refundRepo, orderRepo, paymentRepo, paymentService)
+	orderService := service.NewOrderService(orderRepo, productRepo, refundService, reservationService, service.NewLogOrderNotifier())
+	reconciliationService := service.NewReconciliationService(
+		paymentRepo,
+		reconciliationRepo,
+		paymentService,
+		config.AppConfig.ReconcileMinAge,
+		config.AppConfig.PendingPaymentTTL,
+		config.AppConfig.ReconcileBatchSize,
+	)
 
 	// Initialize handlers
-	userHandler := handler.NewUserHandler(userService)
+	userHandler := handler.NewUserHandler(userService, tokenService)
 	productHandler := handler.NewProductHandler(productService)
 	paymentHandler := handler.NewPaymentHandler(paymentService)
 	orderHandler := handler.NewOrderHandler(orderService)
 	comentHandler := handler.NewCommentHandler(commentService)
+	cartHandler := handler.NewCartHandler(cartService)
+	refundHandler := handler.NewRefundHandler(refundService)
+	reconciliationHandler := handler.NewReconciliationHandler(reconciliationService)
+	addressHandler := handler.NewAddressHandler(addressService)
 
 	return &App{
+		RedisClient:    redisClient,
 		UserRepo:       userRepo,
 		UserService:    userService,
+		TokenService:   tokenService,
 		UserHandler:    userHandler,
 		ProductHandler: productHandler,
 		ProductService: productService,
@@ -76,9 +153,55 @@ func New() (*App, error) {
 		CommentRepo:    commentRepo,
 		CommentHandler: comentHandler,
 		CommentService: commentService,
+		CartHandler:    cartHandler,
+		CartService:    cartService,
+		CartRepo:       cartRepo,
+		RefundHandler:  refundHandler,
+		RefundService:  refundService,
+		RefundRepo:     refundRepo,
+
+		ReconciliationHandler: reconciliationHandler,
+		ReconciliationService: reconciliationService,
+		ReconciliationRepo:    reconciliationRepo,
+
+		ReservationService: reservationService,
+		ReservationRepo:    reservationRepo,
+
+		AddressHandler: addressHandler,
+		AddressService: addressService,
+		AddressRepo:    addressRepo,
 	}, nil
 }
 
+// newPaymentGateways registers eSewa and every other provider that is configured
+func newPaymentGateways() *gateway.Registry {
+	cfg := config.AppConfig
+	registry := gateway.NewRegistry(cfg.PaymentProvider, gateway.NewEsewaGateway(gateway.EsewaConfig{
+		MerchantCode:   cfg.EsewaMerchantCode,
+		SecretKey:      cfg.EsewaSecretKey,
+		PaymentURL:     cfg.ESewaPaymentURL,
+		StatusCheckURL: cfg.EsewaPaymentStatusCheckURL,
+		SuccessURL:     cfg.ESewaSuccessURL,
+		FailureURL:     cfg.ESewaFailedURL,
+	}))
+
+	if cfg.KhaltiSecretKey != "" {
+		registry.Register(gateway.NewKhaltiGateway(gateway.KhaltiConfig{
+			SecretKey:       cfg.KhaltiSecretKey,
+			BaseURL:         cfg.KhaltiBaseURL,
+			MerchantBaseURL: cfg.KhaltiMerchantBaseURL,
+			ReturnURL:       cfg.KhaltiReturnURL,
+			WebsiteURL:      cfg.FrontEndUrl,
+		}))
+	}
+
+	if cfg.MockPaymentGateway {
+		registry.Register(gateway.NewMockGateway(cfg.ESewaSuccessURL))
+	}
+
+	return registry
+}
+
 func (a *App) Close() {
 	db.Cleanup()
 }
@@ -4,14 +4,21 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	JWTSecret                  string
-	CookieName                 string
-	MongoDBURL                 string
+	JWTSecret       string
+	JWTKeysDir      string
+	JWTSigningKeyID string
+	JWTAcceptHS256  bool
+	CookieName      string
+	MongoDBURL      string
+	// MongoDBName is the database every collection lives in
+	MongoDBName                string
 	PostgressURL               string
 	RedisUrl                   string
 	RedisUserName              string
@@ -25,6 +32,28 @@ type Config struct {
 	ESewaFailedURL             string
 	ESewaPaymentURL            string
 	EsewaPaymentStatusCheckURL string
+	TaxRatePercent             int
+	DeliveryCharge             int64
+	FreeDeliveryThreshold      int64
+	PaymentProvider            string
+	KhaltiSecretKey            string
+	KhaltiBaseURL              string
+	KhaltiMerchantBaseURL      string
+	KhaltiReturnURL            string
+	MockPaymentGateway         bool
+	ReconcileInterval          time.Duration
+	ReconcileMinAge            time.Duration
+	PendingPaymentTTL          time.Duration
+	ReconcileBatchSize         int
+	AccessTokenTTL             time.Duration
+	RefreshTokenTTL            time.Duration
+	StockReservationTTL        time.Duration
+	ReservationSweepInterval   time.Duration
+	MigrateOnStartup           bool
+	EnsureIndexesOnStartup     bool
+	ReconciliationLogTTL       time.Duration
+	// RequireVerifiedReviews rejects reviews of users that never had the product delivered
+	RequireVerifiedReviews bool
 }
 
 var AppConfig *Config
@@ -36,8 +65,12 @@ func Init() error {
 	}
 	AppConfig = &Config{
 		JWTSecret:                  os.Getenv("JWT_SECRET"),
+		JWTKeysDir:                 os.Getenv("JWT_KEYS_DIR"),
+		JWTSigningKeyID:            os.Getenv("JWT_SIGNING_KEY_ID"),
+		JWTAcceptHS256:             getEnvBool("JWT_ACCEPT_HS256", true),
 		CookieName:                 "user_token",
 		MongoDBURL:                 os.Getenv("MONGO_URL"),
+		MongoDBName:                getEnv("MONGO_DB_NAME", "ecommerce"),
 		PostgressURL:               os.Getenv("POSTGRESS_URL"),
 		RedisUrl:                   os.Getenv("REDIS_URL"),
 		RedisUserName:              os.Getenv("REDIS_USERNAME"),
@@ -49,8 +82,84 @@ func Init() error {
 		EsewaSecretKey:             os.Getenv("ESEWA_SECRET_KEY"),
 		ESewaPaymentURL:            os.Getenv("ESEWA_PAYMENT_URL"),
 		EsewaPaymentStatusCheckURL: os.Getenv("ESEWA_PAYMENT_STATUS_CHECK_URL"),
+		TaxRatePercent:             getEnvInt("TAX_RATE_PERCENT", 0),
+		DeliveryCharge:             int64(getEnvInt("DELIVERY_CHARGE", 0)),
+		FreeDeliveryThreshold:      int64(getEnvInt("FREE_DELIVERY_THRESHOLD", 0)),
+		PaymentProvider:            getEnv("PAYMENT_PROVIDER", "esewa"),
+		KhaltiSecretKey:            os.Getenv("KHALTI_SECRET_KEY"),
+		KhaltiBaseURL:              getEnv("KHALTI_BASE_URL", "https://dev.khalti.com/api/v2"),
+		KhaltiMerchantBaseURL:      getEnv("KHALTI_MERCHANT_BASE_URL", "https://khalti.com/api"),
+		MockPaymentGateway:         getEnvBool("MOCK_PAYMENT_GATEWAY", false),
+		ReconcileInterval:          getEnvDuration("RECONCILE_INTERVAL", 5*time.Minute),
+		ReconcileMinAge:            getEnvDuration("RECONCILE_MIN_AGE", 15*time.Minute),
+		PendingPaymentTTL:          getEnvDuration("PENDING_PAYMENT_TTL", 2*time.Hour),
+		ReconcileBatchSize:         getEnvInt("RECONCILE_BATCH_SIZE", 100),
+		AccessTokenTTL:             getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:            getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+		StockReservationTTL:        getEnvDuration("STOCK_RESERVATION_TTL", 2*time.Hour),
+		ReservationSweepInterval:   getEnvDuration("RESERVATION_SWEEP_INTERVAL", time.Minute),
+		MigrateOnStartup:           getEnvBool("MIGRATE_ON_STARTUP", true),
+		EnsureIndexesOnStartup:     getEnvBool("ENSURE_INDEXES_ON_STARTUP", true),
+		ReconciliationLogTTL:       getEnvDuration("RECONCILIATION_LOG_TTL", 90*24*time.Hour),
+		RequireVerifiedReviews:     getEnvBool("REQUIRE_VERIFIED_REVIEWS", false),
+	}
+	// A payment can settle until it is expired, its reservation has to be kept at least as long
+	if AppConfig.StockReservationTTL < AppConfig.PendingPaymentTTL {
+		log.Printf("Warning : STOCK_RESERVATION_TTL %s is shorter than PENDING_PAYMENT_TTL, using %s", AppConfig.StockReservationTTL, AppConfig.PendingPaymentTTL)
+		AppConfig.StockReservationTTL = AppConfig.PendingPaymentTTL
 	}
 	AppConfig.ESewaSuccessURL = fmt.Sprintf("%s/products/checkout/payment/success", AppConfig.FrontEndUrl)
 	AppConfig.ESewaFailedURL = fmt.Sprintf("%s/products/checkout/payment/failed", AppConfig.FrontEndUrl)
+	AppConfig.KhaltiReturnURL = AppConfig.ESewaSuccessURL
 	return nil
 }
+
+// getEnvInt reads an integer environment variable, falling back to def when unset or invalid
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning : invalid value for %s : %v", key, err)
+		return def
+	}
+	return parsed
+}
+
+// getEnv reads an environment variable, falling back to def when unset
+func getEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvBool reads a boolean environment variable, falling back to def when unset or invalid
+func getEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning : invalid value for %s : %v", key, err)
+		return def
+	}
+	return parsed
+}
+
+// getEnvDuration reads a duration like 15m or 2h, falling back to def when unset or invalid
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Warning : invalid value for %s : %v", key, value)
+		return def
+	}
+	return parsed
+}
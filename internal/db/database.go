@@ -26,7 +26,6 @@ var (
 	mongoClient     *mongo.Client
 	mongoOnce       sync.Once
 	mongoConnectErr error
-	mongoDBName     = "e-commerce" // Set your default MongoDB database name
 
 	// PostgreSQL (Neon)
 	pgPool       *pgxpool.Pool
@@ -56,7 +55,12 @@ func GetMongoCollection(collectionName string) (*mongo.Collection, error) {
 		return nil, err
 	}
 
-	return client.Database(mongoDBName).Collection(collectionName), nil
+	return MongoDatabase(client).Collection(collectionName), nil
+}
+
+// MongoDatabase returns the configured database of the client, MONGO_DB_NAME
+func MongoDatabase(client *mongo.Client) *mongo.Database {
+	return client.Database(config.AppConfig.MongoDBName)
 }
 
 // GetMongoClient returns the MongoDB client (singleton)
@@ -236,6 +240,7 @@ func InitializeDatabase() error {
 	var wg sync.WaitGroup
 	var errMongo, errPostgres, errRedis error
 	var postgressPool *pgxpool.Pool
+	var mongoConn *mongo.Client
 
 	log.Println("Initializing database connections...")
 	wg.Add(3)
@@ -243,7 +248,7 @@ func InitializeDatabase() error {
 	go func() {
 		defer wg.Done()
 		log.Println("📊 Connecting to MongoDB...")
-		_, errMongo = GetMongoClient()
+		mongoConn, errMongo = GetMongoClient()
 	}()
 
 	go func() {
@@ -269,8 +274,15 @@ func InitializeDatabase() error {
 		return fmt.Errorf("redis connection failed: %w", errRedis)
 	}
 
-	if err := CreatePostgresTables(ctx, postgressPool); err != nil {
-		return fmt.Errorf("error in creating table : %v", err)
+	if config.AppConfig.MigrateOnStartup {
+		if _, err := MigrateUp(ctx, postgressPool, 0); err != nil {
+			return fmt.Errorf("error in migrating database : %v", err)
+		}
+	}
+	if config.AppConfig.EnsureIndexesOnStartup {
+		if err := CreateMongoIndexes(ctx, MongoDatabase(mongoConn)); err != nil {
+			return fmt.Errorf("error in creating indexes : %v", err)
+		}
 	}
 	return nil
 }
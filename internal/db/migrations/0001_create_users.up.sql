@@ -0,0 +1,18 @@
+-- Databases created before migrations already have the users table, so this is a no-op there
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'user_role') THEN
+		CREATE TYPE user_role AS ENUM ('customer', 'seller');
+	END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS users (
+	id UUID PRIMARY KEY,
+	username TEXT NOT NULL,
+	email TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	role user_role NOT NULL DEFAULT 'customer',
+	is_verified BOOLEAN NOT NULL DEFAULT 'false',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+-- Postgres can not drop an enum value, admins are turned back into customers instead
+UPDATE users SET role = 'customer' WHERE role = 'admin';
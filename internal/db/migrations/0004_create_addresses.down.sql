@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS addresses;
+DROP TYPE IF EXISTS address_type;
@@ -0,0 +1,27 @@
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'address_type') THEN
+		CREATE TYPE address_type AS ENUM ('shipping', 'billing');
+	END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS addresses (
+	id UUID PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	type address_type NOT NULL DEFAULT 'shipping',
+	full_name TEXT NOT NULL,
+	phone TEXT NOT NULL,
+	line1 TEXT NOT NULL,
+	line2 TEXT NOT NULL DEFAULT '',
+	city TEXT NOT NULL,
+	state TEXT NOT NULL DEFAULT '',
+	postal_code TEXT NOT NULL DEFAULT '',
+	country TEXT NOT NULL,
+	is_default BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS addresses_user_id ON addresses (user_id);
+-- At most one default address of each type per user
+CREATE UNIQUE INDEX IF NOT EXISTS addresses_default_per_type ON addresses (user_id, type) WHERE is_default;
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoBackfill rewrites existing documents once so they match what the repositories and the
+// index registry expect. Applied backfills are recorded by name in the mongo_backfills collection.
+// Backfills have to be safe to run again, two instances starting at once may both run one
+type MongoBackfill struct {
+	Name string
+	Run  func(ctx context.Context, database *mongo.Database) error
+}
+
+// MongoBackfillRegistry is every backfill in the order it runs
+func MongoBackfillRegistry() []MongoBackfill {
+	return []MongoBackfill{
+		// Reviews written before the productId_userId_unique index may repeat a user, the index can
+		// only be built once only the newest review of each user per product is left
+		{Name: "dedupe_reviews", Run: dedupeReviews},
+		// A retried payment callback could create a second order for the same payment before the
+		// transactionId_unique index existed, the index can only be built once one order is left
+		{Name: "dedupe_orders", Run: dedupeOrders},
+		// Products rated before ratingSum existed only stored a rounded average, the aggregates are
+		// counted again from their reviews so updates can add to them
+		{Name: "backfill_product_ratings", Run: backfillProductRatings},
+		// Reviews written before votes have no helpfulCount, without it they would drop out of the
+		// keyset pagination of the helpful sort
+		{Name: "backfill_review_counts", Run: backfillReviewCounts},
+	}
+}
+
+// appliedBackfill is the record of a backfill that ran
+type appliedBackfill struct {
+	Name      string    `bson:"_id"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// RunMongoBackfills runs the backfills that were not applied yet and returns their names. They run
+// before the indexes are synced, since a unique index fails on the duplicates a backfill removes.
+// With dryRun the pending backfills are only listed
+func RunMongoBackfills(ctx context.Context, database *mongo.Database, dryRun bool) ([]string, error) {
+	collection := database.Collection("mongo_backfills")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applied backfills: %w", err)
+	}
+	var applied []appliedBackfill
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, fmt.Errorf("failed to list applied backfills: %w", err)
+	}
+	done := make(map[string]bool, len(applied))
+	for _, backfill := range applied {
+		done[backfill.Name] = true
+	}
+
+	var ran []string
+	for _, backfill := range MongoBackfillRegistry() {
+		if done[backfill.Name] {
+			continue
+		}
+		if dryRun {
+			ran = append(ran, backfill.Name)
+			continue
+		}
+
+		if err := backfill.Run(ctx, database); err != nil {
+			return ran, fmt.Errorf("backfill %s failed: %w", backfill.Name, err)
+		}
+		record := appliedBackfill{Name: backfill.Name, AppliedAt: time.Now()}
+		if _, err := collection.InsertOne(ctx, record); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return ran, fmt.Errorf("failed to record backfill %s: %w", backfill.Name, err)
+		}
+		log.Printf("✅ Applied backfill %s", backfill.Name)
+		ran = append(ran, backfill.Name)
+	}
+	return ran, nil
+}
+
+// dedupeReviews keeps the newest review of every user per product, deletes the others with their
+// reactions and reports and recomputes the rating aggregates of the products they belonged to
+func dedupeReviews(ctx context.Context, database *mongo.Database) error {
+	comments := database.Collection("comments")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "updatedAt", Value: -1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"productId": "$productId", "userId": "$userId"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := comments.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("failed to find duplicate reviews: %w", err)
+	}
+	var duplicates []struct {
+		Key struct {
+			ProductID string `bson:"productId"`
+		} `bson:"_id"`
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("failed to find duplicate reviews: %w", err)
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	var removed []primitive.ObjectID
+	productIds := make([]string, 0, len(duplicates))
+	for _, duplicate := range duplicates {
+		// ids are sorted newest first
+		removed = append(removed, duplicate.IDs[1:]...)
+		productIds = append(productIds, duplicate.Key.ProductID)
+	}
+
+	if _, err := comments.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": removed}}); err != nil {
+		return fmt.Errorf("failed to delete duplicate reviews: %w", err)
+	}
+	for _, name := range []string{"review_reactions", "review_reports"} {
+		if _, err := database.Collection(name).DeleteMany(ctx, bson.M{"reviewId": bson.M{"$in": removed}}); err != nil {
+			return fmt.Errorf("failed to delete %s of duplicate reviews: %w", name, err)
+		}
+	}
+	log.Printf("🧹 Removed %d duplicate reviews of %d products", len(removed), len(productIds))
+
+	return recomputeProductRatings(ctx, database, productIds)
+}
+
+// dedupeOrders keeps the last updated order of every payment, the one sellers went on with, and
+// moves the refunds of the deleted orders over to it
+func dedupeOrders(ctx context.Context, database *mongo.Database) error {
+	orders := database.Collection("orders")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "updatedAt", Value: -1}, {Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$transactionId",
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := orders.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("failed to find duplicate orders: %w", err)
+	}
+	var duplicates []struct {
+		TransactionID string   `bson:"_id"`
+		IDs           []string `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("failed to find duplicate orders: %w", err)
+	}
+
+	removed := 0
+	for _, duplicate := range duplicates {
+		// ids are sorted last updated first
+		kept, extra := duplicate.IDs[0], duplicate.IDs[1:]
+		if _, err := database.Collection("refunds").UpdateMany(ctx,
+			bson.M{"orderId": bson.M{"$in": extra}},
+			bson.M{"$set": bson.M{"orderId": kept}},
+		); err != nil {
+			return fmt.Errorf("failed to move refunds of duplicate orders of payment %s: %w", duplicate.TransactionID, err)
+		}
+		if _, err := orders.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": extra}}); err != nil {
+			return fmt.Errorf("failed to delete duplicate orders of payment %s: %w", duplicate.TransactionID, err)
+		}
+		removed += len(extra)
+	}
+	if removed > 0 {
+		log.Printf("🧹 Removed %d duplicate orders of %d payments", removed, len(duplicates))
+	}
+	return nil
+}
+
+// backfillReviewCounts sets the vote counts of reviews that have none to 0
+func backfillReviewCounts(ctx context.Context, database *mongo.Database) error {
+	comments := database.Collection("comments")
+	for _, field := range []string{"helpfulCount", "unhelpfulCount"} {
+		result, err := comments.UpdateMany(ctx, bson.M{field: bson.M{"$exists": false}}, bson.M{"$set": bson.M{field: 0}})
+		if err != nil {
+			return fmt.Errorf("failed to backfill %s: %w", field, err)
+		}
+		log.Printf("🗳️ Set the %s of %d reviews", field, result.ModifiedCount)
+	}
+	return nil
+}
+
+// productRatingBatchSize bounds how many products one recomputeProductRatings call updates
+const productRatingBatchSize = 500
+
+// backfillProductRatings computes the rating aggregates of every product without ratingSum
+func backfillProductRatings(ctx context.Context, database *mongo.Database) error {
+	cursor, err := database.Collection("products").Find(ctx,
+		bson.M{"ratingSum": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find products without rating aggregates: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var batch []string
+	total := 0
+	for cursor.Next(ctx) {
+		var product struct {
+			ID string `bson:"_id"`
+		}
+		if err := cursor.Decode(&product); err != nil {
+			return fmt.Errorf("failed to decode product: %w", err)
+		}
+		batch = append(batch, product.ID)
+		if len(batch) == productRatingBatchSize {
+			if err := recomputeProductRatings(ctx, database, batch); err != nil {
+				return err
+			}
+			total += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to find products without rating aggregates: %w", err)
+	}
+	if err := recomputeProductRatings(ctx, database, batch); err != nil {
+		return err
+	}
+	total += len(batch)
+
+	log.Printf("⭐ Computed the rating aggregates of %d products", total)
+	return nil
+}
+
+// recomputeProductRatings sets ratingSum, ratingCount, ratingHistogram and rating of the products
+// from their reviews, products without reviews get zeros
+func recomputeProductRatings(ctx context.Context, database *mongo.Database, productIds []string) error {
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"productId": bson.M{"$in": productIds}, "rating": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"productId": "$productId", "rating": "$rating"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := database.Collection("comments").Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate review ratings: %w", err)
+	}
+	var groups []struct {
+		Key struct {
+			ProductID string `bson:"productId"`
+			Rating    int    `bson:"rating"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return fmt.Errorf("failed to aggregate review ratings: %w", err)
+	}
+
+	type ratings struct {
+		sum, count int
+		// histogram is indexed by stars, index 0 is unused
+		histogram [6]int
+	}
+	byProduct := make(map[string]*ratings, len(productIds))
+	for _, productId := range productIds {
+		byProduct[productId] = &ratings{}
+	}
+	for _, group := range groups {
+		product := byProduct[group.Key.ProductID]
+		product.sum += group.Key.Rating * group.Count
+		product.count += group.Count
+		if group.Key.Rating <= 5 {
+			product.histogram[group.Key.Rating] += group.Count
+		}
+	}
+
+	updates := make([]mongo.WriteModel, 0, len(byProduct))
+	for productId, product := range byProduct {
+		average := 0.0
+		if product.count > 0 {
+			average = math.Round(float64(product.sum)/float64(product.count)*100) / 100
+		}
+		histogram := bson.M{}
+		for stars := 1; stars <= 5; stars++ {
+			histogram[fmt.Sprint(stars)] = product.histogram[stars]
+		}
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": productId}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"ratingSum":       product.sum,
+				"ratingCount":     product.count,
+				"ratingHistogram": histogram,
+				"rating":          average,
+			}}))
+	}
+	if _, err := database.Collection("products").BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to update product ratings: %w", err)
+	}
+	return nil
+}
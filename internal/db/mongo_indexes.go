@@ -0,0 +1,323 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"e-commerce.com/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoIndex declares an index the repositories rely on. Indexes are matched by name,
+// so changing the keys or options of an index means it shows up as changed drift
+type MongoIndex struct {
+	Name string
+	// Keys use 1 or -1 for the sort order, and "text" for the fields of a text index
+	Keys   bson.D
+	Unique bool
+	// TTL removes documents this long after the time in the first key, when set
+	TTL time.Duration
+}
+
+type CollectionIndexes struct {
+	Collection string
+	Indexes    []MongoIndex
+}
+
+// MongoIndexRegistry is every index of the application's collections
+func MongoIndexRegistry() []CollectionIndexes {
+	return []CollectionIndexes{
+		{Collection: "products", Indexes: []MongoIndex{
+			{Name: "sellerId_createdAt", Keys: bson.D{{Key: "sellerId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Name: "createdAt", Keys: bson.D{{Key: "createdAt", Value: -1}}},
+			{Name: "name_description_text", Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}}},
+		}},
+		{Collection: "orders", Indexes: []MongoIndex{
+			// One order per payment, a retried payment callback can never create a second order
+			{Name: "transactionId_unique", Keys: bson.D{{Key: "transactionId", Value: 1}}, Unique: true},
+			{Name: "userId_createdAt", Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Name: "products.sellerId_createdAt", Keys: bson.D{{Key: "products.sellerId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		}},
+		{Collection: "payments", Indexes: []MongoIndex{
+			{Name: "transactionUuid_unique", Keys: bson.D{{Key: "transactionUuid", Value: 1}}, Unique: true},
+			// The reconciliation job looks for old pending payments
+			{Name: "status_createdAt", Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		}},
+		{Collection: "refunds", Indexes: []MongoIndex{
+			{Name: "orderId_createdAt", Keys: bson.D{{Key: "orderId", Value: 1}, {Key: "createdAt", Value: 1}}},
+			{Name: "status_createdAt", Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}}},
+		}},
+		{Collection: "stock_reservations", Indexes: []MongoIndex{
+			// One reservation per payment, and the expiry job looks for old active ones
+			{Name: "transactionUuid_unique", Keys: bson.D{{Key: "transactionUuid", Value: 1}}, Unique: true},
+			{Name: "status_expiresAt", Keys: bson.D{{Key: "status", Value: 1}, {Key: "expiresAt", Value: 1}}},
+		}},
+		{Collection: "reconciliation_logs", Indexes: []MongoIndex{
+			{Name: "createdAt_ttl", Keys: bson.D{{Key: "createdAt", Value: -1}}, TTL: config.AppConfig.ReconciliationLogTTL},
+			{Name: "transactionUuid_createdAt", Keys: bson.D{{Key: "transactionUuid", Value: 1}, {Key: "createdAt", Value: -1}}},
+		}},
+		{Collection: "comments", Indexes: []MongoIndex{
+			// One review per user per product
+			{Name: "productId_userId_unique", Keys: bson.D{{Key: "productId", Value: 1}, {Key: "userId", Value: 1}}, Unique: true},
+			{Name: "productId_createdAt", Keys: bson.D{{Key: "productId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			// The review listing sorts by rating and by helpful votes
+			{Name: "productId_helpfulCount_createdAt", Keys: bson.D{{Key: "productId", Value: 1}, {Key: "helpfulCount", Value: -1}, {Key: "createdAt", Value: -1}}},
+			{Name: "productId_rating_createdAt", Keys: bson.D{{Key: "productId", Value: 1}, {Key: "rating", Value: -1}, {Key: "createdAt", Value: -1}}},
+		}},
+		{Collection: "review_reactions", Indexes: []MongoIndex{
+			// One vote and one reaction per user per review
+			{Name: "reviewId_userId_unique", Keys: bson.D{{Key: "reviewId", Value: 1}, {Key: "userId", Value: 1}}, Unique: true},
+		}},
+		{Collection: "review_reports", Indexes: []MongoIndex{
+			// One report per user per review
+			{Name: "reviewId_reporterId_unique", Keys: bson.D{{Key: "reviewId", Value: 1}, {Key: "reporterId", Value: 1}}, Unique: true},
+			// The moderation queue lists open reports, oldest first
+			{Name: "status_createdAt", Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		}},
+	}
+}
+
+type IndexDriftKind string
+
+const (
+	// IndexMissing is declared but does not exist
+	IndexMissing IndexDriftKind = "missing"
+	// IndexChanged exists with other keys or options than declared
+	IndexChanged IndexDriftKind = "changed"
+	// IndexExtra exists but is not declared
+	IndexExtra IndexDriftKind = "extra"
+)
+
+type IndexDrift struct {
+	Collection string
+	Name       string
+	Kind       IndexDriftKind
+	Detail     string
+}
+
+func (d IndexDrift) String() string {
+	return fmt.Sprintf("%s.%s is %s: %s", d.Collection, d.Name, d.Kind, d.Detail)
+}
+
+type IndexSyncOptions struct {
+	// DryRun only reports the drift without changing any index
+	DryRun bool
+	// RecreateChanged drops changed indexes and creates them as declared
+	RecreateChanged bool
+	// DropExtra drops indexes that are not declared
+	DropExtra bool
+}
+
+type IndexReport struct {
+	// Drift is what differed before the sync
+	Drift   []IndexDrift
+	Created []string
+	Dropped []string
+}
+
+// existingIndex is an index as listed by MongoDB
+type existingIndex struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+	Weights            bson.M `bson:"weights"`
+}
+
+// EnsureMongoIndexes compares the registry with the indexes of the database, creates the missing
+// ones and, when asked to, fixes the others. A failing index does not stop the remaining ones
+func EnsureMongoIndexes(ctx context.Context, database *mongo.Database, opts IndexSyncOptions) (*IndexReport, error) {
+	report := &IndexReport{}
+	var errs []error
+
+	for _, declared := range MongoIndexRegistry() {
+		collection := database.Collection(declared.Collection)
+
+		existing, err := listIndexes(ctx, collection)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list %s indexes: %w", declared.Collection, err))
+			continue
+		}
+
+		for _, index := range declared.Indexes {
+			current, ok := existing[index.Name]
+			delete(existing, index.Name)
+
+			if !ok {
+				report.Drift = append(report.Drift, IndexDrift{declared.Collection, index.Name, IndexMissing, describeIndex(index)})
+				if opts.DryRun {
+					continue
+				}
+				if err := createIndex(ctx, collection, index); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				report.Created = append(report.Created, declared.Collection+"."+index.Name)
+				continue
+			}
+
+			if diff := indexDiff(index, current); diff != "" {
+				report.Drift = append(report.Drift, IndexDrift{declared.Collection, index.Name, IndexChanged, diff})
+				if opts.DryRun || !opts.RecreateChanged {
+					continue
+				}
+				if _, err := collection.Indexes().DropOne(ctx, index.Name); err != nil {
+					errs = append(errs, fmt.Errorf("failed to drop %s.%s: %w", declared.Collection, index.Name, err))
+					continue
+				}
+				report.Dropped = append(report.Dropped, declared.Collection+"."+index.Name)
+				if err := createIndex(ctx, collection, index); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				report.Created = append(report.Created, declared.Collection+"."+index.Name)
+			}
+		}
+
+		extras := make([]string, 0, len(existing))
+		for name := range existing {
+			extras = append(extras, name)
+		}
+		sort.Strings(extras)
+		for _, name := range extras {
+			report.Drift = append(report.Drift, IndexDrift{declared.Collection, name, IndexExtra, "not declared in the index registry"})
+			if opts.DryRun || !opts.DropExtra {
+				continue
+			}
+			if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+				errs = append(errs, fmt.Errorf("failed to drop %s.%s: %w", declared.Collection, name, err))
+				continue
+			}
+			report.Dropped = append(report.Dropped, declared.Collection+"."+name)
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// CreateMongoIndexes runs the pending backfills and creates missing indexes at startup,
+// and warns about the rest of the drift
+func CreateMongoIndexes(ctx context.Context, database *mongo.Database) error {
+	if _, err := RunMongoBackfills(ctx, database, false); err != nil {
+		return err
+	}
+
+	report, err := EnsureMongoIndexes(ctx, database, IndexSyncOptions{})
+	for _, drift := range report.Drift {
+		if drift.Kind != IndexMissing {
+			log.Printf("⚠️ Index %s, run cmd/mongoindexes to fix it", drift)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("📇 MongoDB indexes ready, %d created", len(report.Created))
+	return nil
+}
+
+func listIndexes(ctx context.Context, collection *mongo.Collection) (map[string]existingIndex, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []existingIndex
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]existingIndex, len(indexes))
+	for _, index := range indexes {
+		// The _id index always exists and can not be changed
+		if index.Name == "_id_" {
+			continue
+		}
+		byName[index.Name] = index
+	}
+	return byName, nil
+}
+
+func createIndex(ctx context.Context, collection *mongo.Collection, index MongoIndex) error {
+	indexOpts := options.Index().SetName(index.Name)
+	if index.Unique {
+		indexOpts.SetUnique(true)
+	}
+	if index.TTL > 0 {
+		indexOpts.SetExpireAfterSeconds(int32(index.TTL.Seconds()))
+	}
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: index.Keys, Options: indexOpts})
+	if err != nil {
+		return fmt.Errorf("failed to create %s.%s: %w", collection.Name(), index.Name, err)
+	}
+	return nil
+}
+
+// indexDiff describes how an existing index differs from its declaration, empty when it does not
+func indexDiff(declared MongoIndex, current existingIndex) string {
+	var diffs []string
+
+	declaredKeys := keySignature(declared.Keys, nil)
+	currentKeys := keySignature(current.Key, current.Weights)
+	if declaredKeys != currentKeys {
+		diffs = append(diffs, fmt.Sprintf("keys %s, declared %s", currentKeys, declaredKeys))
+	}
+	if declared.Unique != current.Unique {
+		diffs = append(diffs, fmt.Sprintf("unique %t, declared %t", current.Unique, declared.Unique))
+	}
+
+	declaredTTL := int64(declared.TTL.Seconds())
+	var currentTTL int64
+	if current.ExpireAfterSeconds != nil {
+		currentTTL = *current.ExpireAfterSeconds
+	}
+	if declaredTTL != currentTTL {
+		diffs = append(diffs, fmt.Sprintf("ttl %ds, declared %ds", currentTTL, declaredTTL))
+	}
+
+	return strings.Join(diffs, ", ")
+}
+
+// keySignature writes keys as field:order. MongoDB lists a text index as _fts and _ftsx keys
+// with the fields in weights, so text fields are compared as a sorted set
+func keySignature(keys bson.D, weights bson.M) string {
+	var parts, textFields []string
+	for _, key := range keys {
+		switch {
+		case key.Key == "_fts" || key.Key == "_ftsx":
+			continue
+		case key.Value == "text":
+			textFields = append(textFields, key.Key)
+		default:
+			parts = append(parts, fmt.Sprintf("%s:%v", key.Key, key.Value))
+		}
+	}
+	for field := range weights {
+		textFields = append(textFields, field)
+	}
+
+	if len(textFields) > 0 {
+		sort.Strings(textFields)
+		parts = append(parts, "text("+strings.Join(textFields, ",")+")")
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func describeIndex(index MongoIndex) string {
+	description := keySignature(index.Keys, nil)
+	if index.Unique {
+		description += " unique"
+	}
+	if index.TTL > 0 {
+		description += fmt.Sprintf(" ttl %s", index.TTL)
+	}
+	return description
+}
@@ -0,0 +1,250 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"e-commerce.com/internal/utils"
+)
+
+const (
+	esewaStatusComplete      = "COMPLETE"
+	esewaStatusPending       = "PENDING"
+	esewaStatusAmbiguous     = "AMBIGUOUS"
+	esewaStatusCanceled      = "CANCELED"
+	esewaStatusNotFound      = "NOT_FOUND"
+	esewaStatusFullRefund    = "FULL_REFUND"
+	esewaStatusPartialRefund = "PARTIAL_REFUND"
+
+	esewaSignedFieldNames = "total_amount,transaction_uuid,product_code"
+)
+
+type EsewaConfig struct {
+	MerchantCode   string
+	SecretKey      string
+	PaymentURL     string
+	StatusCheckURL string
+	SuccessURL     string
+	FailureURL     string
+}
+
+type esewaGateway struct {
+	config EsewaConfig
+	client *http.Client
+}
+
+func NewEsewaGateway(config EsewaConfig) PaymentGateway {
+	return &esewaGateway{config: config, client: http.DefaultClient}
+}
+
+func (g *esewaGateway) Name() string {
+	return "esewa"
+}
+
+// esewaStatusResponse represents the response from eSewa status check API
+type esewaStatusResponse struct {
+	ProductCode     string  `json:"product_code"`
+	TransactionUUID string  `json:"transaction_uuid"`
+	TotalAmount     float64 `json:"total_amount"`
+	Status          string  `json:"status"`
+	RefID           *string `json:"ref_id"`
+}
+
+func (g *esewaGateway) Initiate(ctx context.Context, req *InitiateRequest) (*InitiateResponse, error) {
+	totalAmount := strconv.FormatInt(req.TotalAmount, 10)
+
+	// Generate signature - order matters: total_amount,transaction_uuid,product_code
+	signature := utils.GenerateEsewaSignature(totalAmount, req.TransactionUUID, g.config.MerchantCode, g.config.SecretKey)
+	if signature == "" {
+		return nil, errors.New("failed to generate signature")
+	}
+
+	fields := map[string]string{
+		"amount":                  strconv.FormatInt(req.Amount, 10),
+		"tax_amount":              strconv.FormatInt(req.TaxAmount, 10),
+		"product_service_charge":  strconv.FormatInt(req.ServiceCharge, 10),
+		"product_delivery_charge": strconv.FormatInt(req.DeliveryCharge, 10),
+		"total_amount":            totalAmount,
+		"transaction_uuid":        req.TransactionUUID,
+		"product_code":            g.config.MerchantCode,
+		"success_url":             g.config.SuccessURL,
+		"failure_url":             g.config.FailureURL,
+		"signed_field_names":      esewaSignedFieldNames,
+		"signature":               signature,
+	}
+
+	// For eSewa, we need to submit a form to their endpoint
+	// The response will be a redirect, so we need to handle it properly
+	form := url.Values{}
+	for key, value := range fields {
+		form.Set(key, value)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.config.PaymentURL, bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	// Read response body to check for any error messages
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	// Check if response contains error
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("eSewa returned status %d: %s", response.StatusCode, string(body))
+	}
+
+	result := &InitiateResponse{FormFields: fields}
+
+	// If it's a redirect, get the Location header
+	if location := response.Header.Get("Location"); location != "" {
+		result.PaymentURL = location
+		return result, nil
+	}
+
+	// If no redirect, check if response contains a payment URL
+	// This is a fallback - eSewa usually redirects
+	if response.Request != nil && response.Request.URL != nil {
+		result.PaymentURL = response.Request.URL.String()
+		return result, nil
+	}
+
+	// If we can't get a direct URL, return the eSewa payment URL with parameters
+	// The frontend can use this to submit the form
+	result.PaymentURL = fmt.Sprintf("%s?%s", g.config.PaymentURL, form.Encode())
+	return result, nil
+}
+
+// VerifyCallback decodes the base64 data eSewa appends to the success url and checks its signature
+func (g *esewaGateway) VerifyCallback(ctx context.Context, params map[string]string, storedSession SessionLookup) (*CallbackResult, error) {
+	encodedData := params["data"]
+	if encodedData == "" {
+		return nil, fmt.Errorf("%w: data is required", ErrInvalidCallback)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encodedData)
+	if err != nil {
+		if raw, err = base64.URLEncoding.DecodeString(encodedData); err != nil {
+			return nil, fmt.Errorf("%w: data is not valid base64", ErrInvalidCallback)
+		}
+	}
+
+	var values map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("%w: data is not valid json", ErrInvalidCallback)
+	}
+
+	// The signature is computed over the values exactly as they were sent
+	fields := make(map[string]string, len(values))
+	for key, value := range values {
+		fields[key] = fmt.Sprint(value)
+	}
+
+	if fields["transaction_uuid"] == "" || fields["signed_field_names"] == "" || fields["signature"] == "" {
+		return nil, fmt.Errorf("%w: data is missing required fields", ErrInvalidCallback)
+	}
+
+	if !utils.VerifyEsewaSignature(fields["signed_field_names"], fields, fields["signature"], g.config.SecretKey) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidCallback)
+	}
+
+	if fields["product_code"] != g.config.MerchantCode {
+		return nil, fmt.Errorf("%w: unexpected product code %s", ErrInvalidCallback, fields["product_code"])
+	}
+
+	totalAmount, err := strconv.ParseFloat(strings.ReplaceAll(fields["total_amount"], ",", ""), 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid total amount %s", ErrInvalidCallback, fields["total_amount"])
+	}
+
+	return &CallbackResult{
+		TransactionUUID: fields["transaction_uuid"],
+		GatewayRef:      fields["transaction_code"],
+		Status:          esewaStatus(fields["status"]),
+		RawStatus:       fields["status"],
+		TotalAmount:     totalAmount,
+	}, nil
+}
+
+// CheckStatus checks the status of a payment using eSewa's status check API
+func (g *esewaGateway) CheckStatus(ctx context.Context, req *StatusRequest) (*StatusResult, error) {
+	query := url.Values{}
+	query.Set("product_code", g.config.MerchantCode)
+	query.Set("total_amount", strconv.FormatInt(req.TotalAmount, 10))
+	query.Set("transaction_uuid", req.TransactionUUID)
+	statusURL := fmt.Sprintf("%s?%s", g.config.StatusCheckURL, query.Encode())
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check payment status: %v", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var statusResponse esewaStatusResponse
+	if err := json.Unmarshal(body, &statusResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse status response: %v", err)
+	}
+
+	result := &StatusResult{
+		TransactionUUID: req.TransactionUUID,
+		Status:          esewaStatus(statusResponse.Status),
+		RawStatus:       statusResponse.Status,
+		TotalAmount:     statusResponse.TotalAmount,
+	}
+	if statusResponse.RefID != nil {
+		result.GatewayRef = *statusResponse.RefID
+	}
+	return result, nil
+}
+
+// Refund is not offered by eSewa's merchant api, refunds have to be settled from the merchant portal
+func (g *esewaGateway) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	return nil, ErrRefundNotSupported
+}
+
+func esewaStatus(status string) Status {
+	switch strings.ToUpper(status) {
+	case esewaStatusComplete:
+		return StatusComplete
+	case esewaStatusPending, esewaStatusAmbiguous:
+		return StatusPending
+	case esewaStatusCanceled:
+		return StatusCanceled
+	case esewaStatusNotFound:
+		return StatusNotFound
+	case esewaStatusFullRefund, esewaStatusPartialRefund:
+		return StatusRefunded
+	default:
+		return StatusFailed
+	}
+}
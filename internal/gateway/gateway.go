@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"e-commerce.com/internal/models"
+)
+
+var (
+	ErrUnknownProvider     = errors.New("unknown payment provider")
+	ErrInvalidCallback     = errors.New("invalid payment callback")
+	ErrRefundNotSupported  = errors.New("provider does not support refunds through its api")
+	ErrTransactionNotFound = errors.New("transaction not found at provider")
+)
+
+// Status is the provider independent state of a transaction
+type Status string
+
+const (
+	StatusComplete Status = "complete"
+	StatusPending  Status = "pending"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+	StatusNotFound Status = "not_found"
+	StatusRefunded Status = "refunded"
+)
+
+// InitiateRequest describes a payment to start, all amounts are in rupees
+type InitiateRequest struct {
+	TransactionUUID string
+	UserID          string
+	Amount          int64
+	TaxAmount       int64
+	ServiceCharge   int64
+	DeliveryCharge  int64
+	TotalAmount     int64
+	Items           []models.PaymentItem
+}
+
+type InitiateResponse struct {
+	// PaymentURL is where the customer has to be sent to pay
+	PaymentURL string `json:"paymentUrl"`
+	// SessionID is the provider's id for the payment session, if it has one
+	SessionID string `json:"sessionId,omitempty"`
+	// FormFields are set when the provider expects a form post to PaymentURL
+	FormFields map[string]string `json:"formFields,omitempty"`
+}
+
+// SessionLookup returns the SessionID stored for a payment when it was initiated
+type SessionLookup func(ctx context.Context, transactionUUID string) (string, error)
+
+// CallbackResult is what a provider reported to the success url
+type CallbackResult struct {
+	TransactionUUID string
+	GatewayRef      string
+	Status          Status
+	RawStatus       string
+	TotalAmount     float64
+}
+
+type StatusRequest struct {
+	TransactionUUID string
+	SessionID       string
+	TotalAmount     int64
+}
+
+type StatusResult struct {
+	TransactionUUID string  `json:"transactionUuid"`
+	GatewayRef      string  `json:"gatewayRef,omitempty"`
+	Status          Status  `json:"status"`
+	RawStatus       string  `json:"rawStatus"`
+	TotalAmount     float64 `json:"totalAmount"`
+}
+
+type RefundRequest struct {
+	TransactionUUID string
+	SessionID       string
+	GatewayRef      string
+	Amount          int64
+	TotalAmount     int64
+	Reason          string
+}
+
+type RefundResult struct {
+	RefundRef string `json:"refundRef,omitempty"`
+	Status    Status `json:"status"`
+}
+
+// PaymentGateway is implemented by every payment provider
+type PaymentGateway interface {
+	// Name is the provider name stored on models.Payment
+	Name() string
+	Initiate(ctx context.Context, req *InitiateRequest) (*InitiateResponse, error)
+	// VerifyCallback parses the data the provider sent to the success url and
+	// authenticates it when the provider signs its callbacks. Providers that do not sign
+	// them check the session id in the callback against the one stored for the payment
+	VerifyCallback(ctx context.Context, params map[string]string, storedSession SessionLookup) (*CallbackResult, error)
+	CheckStatus(ctx context.Context, req *StatusRequest) (*StatusResult, error)
+	Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error)
+}
+
+// Registry holds the configured gateways by name
+type Registry struct {
+	gateways    map[string]PaymentGateway
+	defaultName string
+}
+
+func NewRegistry(defaultName string, gateways ...PaymentGateway) *Registry {
+	registry := &Registry{
+		gateways:    make(map[string]PaymentGateway, len(gateways)),
+		defaultName: strings.ToLower(defaultName),
+	}
+	for _, gateway := range gateways {
+		registry.Register(gateway)
+	}
+	return registry
+}
+
+func (r *Registry) Register(gateway PaymentGateway) {
+	r.gateways[strings.ToLower(gateway.Name())] = gateway
+}
+
+// Get returns the named gateway, an empty name selects the default one
+func (r *Registry) Get(name string) (PaymentGateway, error) {
+	if name == "" {
+		name = r.defaultName
+	}
+	gateway, ok := r.gateways[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return gateway, nil
+}
+
+// Names lists the registered providers
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.gateways))
+	for name := range r.gateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
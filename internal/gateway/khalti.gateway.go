@@ -0,0 +1,264 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	khaltiStatusCompleted         = "Completed"
+	khaltiStatusPending           = "Pending"
+	khaltiStatusInitiated         = "Initiated"
+	khaltiStatusRefunded          = "Refunded"
+	khaltiStatusPartiallyRefunded = "Partially Refunded"
+	khaltiStatusExpired           = "Expired"
+	khaltiStatusUserCanceled      = "User canceled"
+)
+
+type KhaltiConfig struct {
+	SecretKey string
+	// BaseURL is the ePayment api root, e.g. https://dev.khalti.com/api/v2
+	BaseURL string
+	// MerchantBaseURL is the merchant api root used for refunds, e.g. https://khalti.com/api
+	MerchantBaseURL string
+	ReturnURL       string
+	WebsiteURL      string
+}
+
+type khaltiGateway struct {
+	config KhaltiConfig
+	client *http.Client
+}
+
+func NewKhaltiGateway(config KhaltiConfig) PaymentGateway {
+	return &khaltiGateway{config: config, client: http.DefaultClient}
+}
+
+func (g *khaltiGateway) Name() string {
+	return "khalti"
+}
+
+type khaltiInitiateRequest struct {
+	ReturnURL         string                  `json:"return_url"`
+	WebsiteURL        string                  `json:"website_url"`
+	Amount            int64                   `json:"amount"`
+	PurchaseOrderID   string                  `json:"purchase_order_id"`
+	PurchaseOrderName string                  `json:"purchase_order_name"`
+	AmountBreakdown   []khaltiAmountBreakdown `json:"amount_breakdown,omitempty"`
+	ProductDetails    []khaltiProductDetail   `json:"product_details,omitempty"`
+}
+
+type khaltiAmountBreakdown struct {
+	Label  string `json:"label"`
+	Amount int64  `json:"amount"`
+}
+
+type khaltiProductDetail struct {
+	Identity   string `json:"identity"`
+	Name       string `json:"name"`
+	TotalPrice int64  `json:"total_price"`
+	Quantity   int64  `json:"quantity"`
+	UnitPrice  int64  `json:"unit_price"`
+}
+
+type khaltiInitiateResponse struct {
+	Pidx       string `json:"pidx"`
+	PaymentURL string `json:"payment_url"`
+}
+
+type khaltiLookupResponse struct {
+	Pidx          string  `json:"pidx"`
+	TotalAmount   int64   `json:"total_amount"`
+	Status        string  `json:"status"`
+	TransactionID *string `json:"transaction_id"`
+}
+
+// Khalti works in paisa while the rest of the system works in rupees
+func toPaisa(rupees int64) int64 {
+	return rupees * 100
+}
+
+func (g *khaltiGateway) Initiate(ctx context.Context, req *InitiateRequest) (*InitiateResponse, error) {
+	body := khaltiInitiateRequest{
+		ReturnURL:         g.config.ReturnURL,
+		WebsiteURL:        g.config.WebsiteURL,
+		Amount:            toPaisa(req.TotalAmount),
+		PurchaseOrderID:   req.TransactionUUID,
+		PurchaseOrderName: fmt.Sprintf("Order %s", req.TransactionUUID),
+		AmountBreakdown: []khaltiAmountBreakdown{
+			{Label: "Amount", Amount: toPaisa(req.Amount)},
+			{Label: "Tax", Amount: toPaisa(req.TaxAmount)},
+			{Label: "Service charge", Amount: toPaisa(req.ServiceCharge)},
+			{Label: "Delivery charge", Amount: toPaisa(req.DeliveryCharge)},
+		},
+	}
+	for _, item := range req.Items {
+		body.ProductDetails = append(body.ProductDetails, khaltiProductDetail{
+			Identity:   item.ProductID,
+			Name:       item.Name,
+			TotalPrice: toPaisa(item.Total),
+			Quantity:   item.Quantity,
+			UnitPrice:  toPaisa(item.UnitPrice),
+		})
+	}
+
+	var response khaltiInitiateResponse
+	if err := g.post(ctx, g.config.BaseURL+"/epayment/initiate/", body, &response); err != nil {
+		return nil, err
+	}
+
+	return &InitiateResponse{
+		PaymentURL: response.PaymentURL,
+		SessionID:  response.Pidx,
+	}, nil
+}
+
+// VerifyCallback reads the query parameters Khalti appends to the return url.
+// Khalti does not sign them, so the pidx has to be the one Khalti returned when the
+// payment was initiated, and the payment is trusted only after CheckStatus
+func (g *khaltiGateway) VerifyCallback(ctx context.Context, params map[string]string, storedSession SessionLookup) (*CallbackResult, error) {
+	if params["pidx"] == "" || params["purchase_order_id"] == "" {
+		return nil, fmt.Errorf("%w: pidx and purchase_order_id are required", ErrInvalidCallback)
+	}
+
+	sessionID, err := storedSession(ctx, params["purchase_order_id"])
+	if err != nil {
+		return nil, err
+	}
+	if sessionID == "" || sessionID != params["pidx"] {
+		return nil, fmt.Errorf("%w: pidx does not belong to purchase order %s", ErrInvalidCallback, params["purchase_order_id"])
+	}
+
+	lookup, err := g.lookup(ctx, params["pidx"])
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CallbackResult{
+		TransactionUUID: params["purchase_order_id"],
+		Status:          khaltiStatus(lookup.Status),
+		RawStatus:       lookup.Status,
+		TotalAmount:     float64(lookup.TotalAmount) / 100,
+	}
+	if lookup.TransactionID != nil {
+		result.GatewayRef = *lookup.TransactionID
+	}
+	return result, nil
+}
+
+func (g *khaltiGateway) CheckStatus(ctx context.Context, req *StatusRequest) (*StatusResult, error) {
+	if req.SessionID == "" {
+		return &StatusResult{TransactionUUID: req.TransactionUUID, Status: StatusNotFound}, nil
+	}
+
+	lookup, err := g.lookup(ctx, req.SessionID)
+	if err != nil {
+		if errors.Is(err, ErrTransactionNotFound) {
+			return &StatusResult{TransactionUUID: req.TransactionUUID, Status: StatusNotFound}, nil
+		}
+		return nil, err
+	}
+
+	result := &StatusResult{
+		TransactionUUID: req.TransactionUUID,
+		Status:          khaltiStatus(lookup.Status),
+		RawStatus:       lookup.Status,
+		TotalAmount:     float64(lookup.TotalAmount) / 100,
+	}
+	if lookup.TransactionID != nil {
+		result.GatewayRef = *lookup.TransactionID
+	}
+	return result, nil
+}
+
+func (g *khaltiGateway) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	if req.GatewayRef == "" {
+		return nil, errors.New("khalti refund requires the transaction id")
+	}
+
+	body := map[string]interface{}{}
+	// Without an amount Khalti refunds the whole transaction
+	if req.Amount < req.TotalAmount {
+		body["amount"] = toPaisa(req.Amount)
+	}
+
+	var response struct {
+		Detail string `json:"detail"`
+	}
+	url := fmt.Sprintf("%s/merchant-transaction/%s/refund/", strings.TrimRight(g.config.MerchantBaseURL, "/"), req.GatewayRef)
+	if err := g.post(ctx, url, body, &response); err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{RefundRef: req.GatewayRef, Status: StatusRefunded}, nil
+}
+
+func (g *khaltiGateway) lookup(ctx context.Context, pidx string) (*khaltiLookupResponse, error) {
+	var response khaltiLookupResponse
+	if err := g.post(ctx, g.config.BaseURL+"/epayment/lookup/", map[string]string{"pidx": pidx}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (g *khaltiGateway) post(ctx context.Context, url string, body interface{}, out interface{}) error {
+	if g.config.SecretKey == "" {
+		return errors.New("KHALTI_SECRET_KEY is required")
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Key "+g.config.SecretKey)
+
+	response, err := g.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("khalti request failed: %v", err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrTransactionNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("khalti returned status %d: %s", response.StatusCode, string(responseBody))
+	}
+
+	if err := json.Unmarshal(responseBody, out); err != nil {
+		return fmt.Errorf("failed to parse khalti response: %v", err)
+	}
+	return nil
+}
+
+func khaltiStatus(status string) Status {
+	switch status {
+	case khaltiStatusCompleted:
+		return StatusComplete
+	case khaltiStatusPending, khaltiStatusInitiated:
+		return StatusPending
+	case khaltiStatusRefunded, khaltiStatusPartiallyRefunded:
+		return StatusRefunded
+	case khaltiStatusExpired, khaltiStatusUserCanceled:
+		return StatusCanceled
+	default:
+		return StatusFailed
+	}
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"e-commerce.com/internal/utils"
+)
+
+// MockGateway is an in-process provider that completes every payment instantly.
+// It keeps its transactions in memory so the whole checkout flow can run offline.
+type MockGateway struct {
+	successURL string
+
+	mu           sync.Mutex
+	transactions map[string]*mockTransaction
+}
+
+type mockTransaction struct {
+	TotalAmount int64
+	Status      Status
+	Ref         string
+	Refunded    int64
+}
+
+type mockCallbackData struct {
+	TransactionUUID string `json:"transaction_uuid"`
+	TransactionCode string `json:"transaction_code"`
+	TotalAmount     int64  `json:"total_amount"`
+	Status          Status `json:"status"`
+}
+
+func NewMockGateway(successURL string) *MockGateway {
+	return &MockGateway{
+		successURL:   successURL,
+		transactions: make(map[string]*mockTransaction),
+	}
+}
+
+func (g *MockGateway) Name() string {
+	return "mock"
+}
+
+// Initiate marks the payment as paid and points straight at the success url,
+// with the same base64 data parameter eSewa would send
+func (g *MockGateway) Initiate(ctx context.Context, req *InitiateRequest) (*InitiateResponse, error) {
+	transaction := &mockTransaction{
+		TotalAmount: req.TotalAmount,
+		Status:      StatusComplete,
+		Ref:         "MOCK-" + utils.GenerateRandomUUID(),
+	}
+
+	g.mu.Lock()
+	g.transactions[req.TransactionUUID] = transaction
+	g.mu.Unlock()
+
+	payload, err := json.Marshal(mockCallbackData{
+		TransactionUUID: req.TransactionUUID,
+		TransactionCode: transaction.Ref,
+		TotalAmount:     req.TotalAmount,
+		Status:          StatusComplete,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("provider", g.Name())
+	query.Set("data", base64.StdEncoding.EncodeToString(payload))
+
+	return &InitiateResponse{
+		PaymentURL: fmt.Sprintf("%s?%s", g.successURL, query.Encode()),
+		SessionID:  transaction.Ref,
+	}, nil
+}
+
+func (g *MockGateway) VerifyCallback(ctx context.Context, params map[string]string, storedSession SessionLookup) (*CallbackResult, error) {
+	raw, err := base64.StdEncoding.DecodeString(params["data"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: data is not valid base64", ErrInvalidCallback)
+	}
+
+	var data mockCallbackData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("%w: data is not valid json", ErrInvalidCallback)
+	}
+
+	g.mu.Lock()
+	_, known := g.transactions[data.TransactionUUID]
+	g.mu.Unlock()
+	if !known {
+		return nil, fmt.Errorf("%w: unknown transaction %s", ErrInvalidCallback, data.TransactionUUID)
+	}
+
+	return &CallbackResult{
+		TransactionUUID: data.TransactionUUID,
+		GatewayRef:      data.TransactionCode,
+		Status:          data.Status,
+		RawStatus:       string(data.Status),
+		TotalAmount:     float64(data.TotalAmount),
+	}, nil
+}
+
+func (g *MockGateway) CheckStatus(ctx context.Context, req *StatusRequest) (*StatusResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	transaction, ok := g.transactions[req.TransactionUUID]
+	if !ok {
+		return &StatusResult{TransactionUUID: req.TransactionUUID, Status: StatusNotFound, RawStatus: string(StatusNotFound)}, nil
+	}
+
+	return &StatusResult{
+		TransactionUUID: req.TransactionUUID,
+		GatewayRef:      transaction.Ref,
+		Status:          transaction.Status,
+		RawStatus:       string(transaction.Status),
+		TotalAmount:     float64(transaction.TotalAmount),
+	}, nil
+}
+
+func (g *MockGateway) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	transaction, ok := g.transactions[req.TransactionUUID]
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+	if transaction.Refunded+req.Amount > transaction.TotalAmount {
+		return nil, fmt.Errorf("refund of %d exceeds the remaining %d", req.Amount, transaction.TotalAmount-transaction.Refunded)
+	}
+
+	transaction.Refunded += req.Amount
+	if transaction.Refunded == transaction.TotalAmount {
+		transaction.Status = StatusRefunded
+	}
+
+	return &RefundResult{RefundRef: "MOCK-REFUND-" + utils.GenerateRandomUUID(), Status: StatusRefunded}, nil
+}
+
+// SetStatus changes the state of a mock transaction, e.g. to simulate a failed or pending payment
+func (g *MockGateway) SetStatus(transactionUUID string, status Status) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if transaction, ok := g.transactions[transactionUUID]; ok {
+		transaction.Status = status
+	}
+}
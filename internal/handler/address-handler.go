@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"e-commerce.com/internal/middleware"
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type AddressHandler struct {
+	service service.AddressService
+}
+
+func NewAddressHandler(service service.AddressService) *AddressHandler {
+	return &AddressHandler{service: service}
+}
+
+// addressErrorStatus maps address service errors to HTTP status codes
+func addressErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidAddressType):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrAddressNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *AddressHandler) ListAddresses(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	addresses, err := h.service.ListAddresses(c, userId)
+	if err != nil {
+		c.JSON(addressErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": addresses})
+}
+
+func (h *AddressHandler) CreateAddress(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	address, err := h.service.CreateAddress(c, userId, &req)
+	if err != nil {
+		c.JSON(addressErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": address})
+}
+
+func (h *AddressHandler) UpdateAddress(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	address, err := h.service.UpdateAddress(c, userId, c.Param("addressId"), &req)
+	if err != nil {
+		c.JSON(addressErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": address})
+}
+
+func (h *AddressHandler) DeleteAddress(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	if err := h.service.DeleteAddress(c, userId, c.Param("addressId")); err != nil {
+		c.JSON(addressErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "address deleted"})
+}
+
+func (h *AddressHandler) SetDefaultAddress(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	if err := h.service.SetDefaultAddress(c, userId, c.Param("addressId")); err != nil {
+		c.JSON(addressErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "default address updated"})
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"e-commerce.com/internal/middleware"
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type CartHandler struct {
+	service service.CartService
+}
+
+func NewCartHandler(service service.CartService) *CartHandler {
+	return &CartHandler{service: service}
+}
+
+// cartErrorStatus maps cart service errors to HTTP status codes
+func cartErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidQuantity),
+		errors.Is(err, service.ErrInsufficientStock),
+		errors.Is(err, service.ErrEmptyCart):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrProductNotFound),
+		errors.Is(err, service.ErrCartItemNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *CartHandler) GetCart(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	cart, err := h.service.GetCart(c, userId)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": cart})
+}
+
+func (h *CartHandler) AddItem(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.AddCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	cart, err := h.service.AddItem(c, userId, req.ProductID, req.Quantity)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Item added to cart", "data": cart})
+}
+
+func (h *CartHandler) UpdateItemQuantity(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	productId := c.Param("productId")
+	if productId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required", "success": false})
+		return
+	}
+
+	var req models.UpdateCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	cart, err := h.service.UpdateItemQuantity(c, userId, productId, req.Quantity)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Cart item updated", "data": cart})
+}
+
+func (h *CartHandler) RemoveItem(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	productId := c.Param("productId")
+	if productId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required", "success": false})
+		return
+	}
+
+	cart, err := h.service.RemoveItem(c, userId, productId)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Item removed from cart", "data": cart})
+}
+
+func (h *CartHandler) ClearCart(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	if err := h.service.ClearCart(c, userId); err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Cart cleared"})
+}
@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"e-commerce.com/internal/middleware"
 	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
 	"e-commerce.com/internal/service"
 	"github.com/gin-gonic/gin"
 )
@@ -13,7 +17,38 @@ type CommentHandler struct {
 	service service.CommentService
 }
 
+func commentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidRating),
+		errors.Is(err, service.ErrInvalidReviewQuery),
+		errors.Is(err, service.ErrInvalidVote),
+		errors.Is(err, service.ErrInvalidReaction),
+		errors.Is(err, service.ErrInvalidReportReason),
+		errors.Is(err, service.ErrInvalidReportAction),
+		errors.Is(err, service.ErrEmptyReply):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrReviewNotVerified),
+		errors.Is(err, service.ErrOwnReview):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrProductNotFound),
+		errors.Is(err, service.ErrReviewNotFound),
+		errors.Is(err, service.ErrReplyNotFound),
+		errors.Is(err, service.ErrReportNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrReviewReported):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (h *CommentHandler) CreateNewComment(c *gin.Context) {
+	userId, _, userName, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
 	var userData models.ProductReviewFromClient
 
 	if err := c.ShouldBindJSON(&userData); err != nil {
@@ -22,13 +57,260 @@ func (h *CommentHandler) CreateNewComment(c *gin.Context) {
 		return
 	}
 
-	err := h.service.CreateNewComment(c.Request.Context(), &userData)
+	review, created, err := h.service.CreateNewComment(c.Request.Context(), userId, userName, &userData)
 	if err != nil {
 		fmt.Println("thisis error in hanlder : ", err)
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	if created {
+		c.JSON(http.StatusCreated, gin.H{"message": "comment created successfully", "review": review, "success": true})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "comment updated successfully", "review": review, "success": true})
+}
+
+func (h *CommentHandler) ListReviews(c *gin.Context) {
+	query := models.ReviewQuery{
+		Sort:   models.ReviewSort(c.Query("sort")),
+		Cursor: c.Query("cursor"),
+	}
+
+	var err error
+	if rating := c.Query("rating"); rating != "" {
+		if query.Rating, err = strconv.Atoi(rating); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be a number", "success": false})
+			return
+		}
+	}
+	if verified := c.Query("verified"); verified != "" {
+		if query.VerifiedOnly, err = strconv.ParseBool(verified); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "verified must be true or false", "success": false})
+			return
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number", "success": false})
+			return
+		}
+	}
+
+	page, err := h.service.ListReviews(c.Request.Context(), c.Param("productId"), query)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"reviews":    page.Reviews,
+		"nextCursor": page.NextCursor,
+		"hasMore":    page.HasMore,
+		"success":    true,
+	})
+}
+
+func (h *CommentHandler) UpdateReview(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.UpdateReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	review, err := h.service.UpdateReview(c.Request.Context(), userId, c.Param("reviewId"), &req)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "review updated successfully", "review": review, "success": true})
+}
+
+func (h *CommentHandler) DeleteReview(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	if err := h.service.DeleteReview(c.Request.Context(), userId, c.Param("reviewId")); err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "review deleted successfully", "success": true})
+}
+
+func (h *CommentHandler) VoteReview(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.ReviewVoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	review, err := h.service.VoteReview(c.Request.Context(), userId, c.Param("reviewId"), req.Vote)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "vote saved successfully", "review": review, "success": true})
+}
+
+func (h *CommentHandler) ReactToReview(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.ReviewReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	review, err := h.service.ReactToReview(c.Request.Context(), userId, c.Param("reviewId"), req.Reaction)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "reaction saved successfully", "review": review, "success": true})
+}
+
+// GetReactions lists the reactions a review can get with their emoji
+func (h *CommentHandler) GetReactions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"reactions": models.ReviewReactionEmojis, "success": true})
+}
+
+func (h *CommentHandler) ReportReview(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.ReportReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	report, err := h.service.ReportReview(c.Request.Context(), userId, c.Param("reviewId"), &req)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "review reported successfully", "report": report, "success": true})
+}
+
+func (h *CommentHandler) GetReviewReports(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status := c.DefaultQuery("status", string(models.ReviewReportOpen))
+
+	reports, total, err := h.service.GetReviewReports(c.Request.Context(), status, page, limit)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "comment created successfully", "success": true})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"reports": reports,
+			"pagination": gin.H{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+func (h *CommentHandler) ResolveReviewReport(c *gin.Context) {
+	moderatorId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.ResolveReviewReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	report, err := h.service.ResolveReviewReport(c.Request.Context(), moderatorId, c.Param("reportId"), req.Action)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
+
+func (h *CommentHandler) AddReply(c *gin.Context) {
+	userId, _, userName, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.ProductReviewReplyFromClient
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	reply, err := h.service.AddReply(c.Request.Context(), userId, userName, c.Param("reviewId"), req.Message)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "reply added successfully", "reply": reply, "success": true})
+}
+
+func (h *CommentHandler) UpdateReply(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.ProductReviewReplyFromClient
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	if err := h.service.UpdateReply(c.Request.Context(), userId, c.Param("reviewId"), c.Param("replyId"), req.Message); err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "reply updated successfully", "success": true})
+}
+
+func (h *CommentHandler) DeleteReply(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	if err := h.service.DeleteReply(c.Request.Context(), userId, c.Param("reviewId"), c.Param("replyId")); err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "reply deleted successfully", "success": true})
 }
 
 func NewCommentHandler(service service.CommentService) *CommentHandler {
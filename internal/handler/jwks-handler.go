@@ -0,0 +1,16 @@
+package handler
+
+import (
+	"net/http"
+
+	"e-commerce.com/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// JWKSHandler publishes the public keys tokens are verified with, so other services
+// can verify tokens without sharing a secret
+func JWKSHandler(c *gin.Context) {
+	// Short enough for a newly added key to be picked up before it is used for signing
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, utils.PublicJWKS())
+}
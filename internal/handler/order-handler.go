@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 
 	"e-commerce.com/internal/middleware"
+	"e-commerce.com/internal/models"
 	"e-commerce.com/internal/service"
 	"github.com/gin-gonic/gin"
 )
@@ -16,6 +19,30 @@ type OrderHandler struct {
 
 type UpdateOrderStatusRequest struct {
 	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason"`
+	// Carrier and TrackingNumber are stored with the shipment when the order is shipped
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"trackingNumber"`
+}
+
+// orderErrorStatus maps order status change errors to HTTP status codes
+func orderErrorStatus(err error) int {
+	switch {
+	case service.IsForbidden(err):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrInvalidOrderTransition):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrOrderTransitionForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrOrderStatusConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type ReturnOrderRequest struct {
+	Reason string `json:"reason"`
 }
 
 type UpdateProductStockRequest struct {
@@ -39,7 +66,7 @@ func (h *OrderHandler) FinishOrderHandler(c *gin.Context) {
 	// Accept order
 	err := h.service.OrderFinished(c, sellerId, orderId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(orderErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
@@ -113,7 +140,7 @@ func (h *OrderHandler) CancelUserOrder(c *gin.Context) {
 	// Cancel order
 	err := h.service.CancelUserOrder(c, userId, orderId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(orderErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
@@ -123,6 +150,39 @@ func (h *OrderHandler) CancelUserOrder(c *gin.Context) {
 	})
 }
 
+func (h *OrderHandler) ReturnUserOrder(c *gin.Context) {
+	// Get user ID from context
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	orderId := c.Param("orderId")
+	if orderId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order ID is required", "success": false})
+		return
+	}
+
+	var req ReturnOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	// Return order, the refund is started by the service
+	err := h.service.ReturnUserOrder(c, userId, orderId, req.Reason)
+	if err != nil {
+		c.JSON(orderErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Order returned successfully",
+	})
+}
+
 func (h *OrderHandler) GetSellerOrders(c *gin.Context) {
 	// Get seller ID from context (sellers are users with a specific role)
 	sellerId, _, _, ok := middleware.GetUserFromContext(c)
@@ -171,7 +231,7 @@ func (h *OrderHandler) GetSellerOrderDetails(c *gin.Context) {
 	// Get order details for seller
 	order, err := h.service.GetSellerOrderDetails(c, sellerId, orderId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(orderErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
@@ -201,10 +261,15 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	// Update order status
-	err := h.service.UpdateOrderStatus(c, sellerId, orderId, req.Status)
+	var shipment *models.Shipment
+	if req.Carrier != "" || req.TrackingNumber != "" {
+		shipment = &models.Shipment{Carrier: req.Carrier, TrackingNumber: req.TrackingNumber}
+	}
+
+	// Update the status of the seller's part of the order
+	err := h.service.UpdateOrderStatus(c, sellerId, orderId, req.Status, req.Reason, shipment)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(orderErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
@@ -328,7 +393,7 @@ func (h *OrderHandler) AcceptOrder(c *gin.Context) {
 	// Accept order
 	err := h.service.AcceptOrder(c, sellerId, orderId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(orderErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
@@ -355,7 +420,7 @@ func (h *OrderHandler) DeleteOrder(c *gin.Context) {
 	// Delete order
 	err := h.service.DeleteOrder(c, sellerId, orderId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(orderErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+	"e-commerce.com/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// serveAs runs handler for a request made by the signed in seller, the way the seller routes register it
+func serveAs(sellerId, method, route, path, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		c.Set("userId", sellerId)
+		c.Set("userEmail", sellerId+"@example.com")
+		c.Set("userFullName", sellerId)
+		c.Next()
+	}, handler)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// fakeOrderRepo keeps orders in memory, methods the seller handlers do not reach panic through the nil interface
+type fakeOrderRepo struct {
+	repository.OrderRepo
+
+	mu     sync.Mutex
+	orders map[string]*models.Order
+}
+
+func (r *fakeOrderRepo) GetOrderByID(ctx context.Context, orderId string) (*models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order, ok := r.orders[orderId]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	copied := *order
+	copied.SellerOrders = append([]models.SellerOrder(nil), order.SellerOrders...)
+	return &copied, nil
+}
+
+func (r *fakeOrderRepo) TransitionSellerOrderStatus(ctx context.Context, orderID string, change models.OrderStatusChange, shipment *models.Shipment) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, sub := range r.orders[orderID].SellerOrders {
+		if sub.SellerID == change.SellerID && sub.Status == change.From {
+			r.orders[orderID].SellerOrders[i].Status = change.To
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeOrderRepo) SetDerivedOrderStatus(ctx context.Context, orderID string, sellerStatuses []models.OrderStatus, change models.OrderStatusChange) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orders[orderID].Status = change.To
+	return true, nil
+}
+
+func (r *fakeOrderRepo) DeleteOrder(ctx context.Context, orderId string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.orders[orderId]
+	delete(r.orders, orderId)
+	return ok, nil
+}
+
+type quietNotifier struct{}
+
+func (quietNotifier) OrderStatusChanged(ctx context.Context, order *models.Order, change models.OrderStatusChange) {
+}
+
+// newSellerOrderHandler serves an open order and a cancelled, fully refunded one, both of seller-1
+func newSellerOrderHandler() (*OrderHandler, *fakeOrderRepo) {
+	order := func(id string, status models.OrderStatus, refunded int64) *models.Order {
+		products := []models.ProductItem{{ProductID: "product-1", SellerID: "seller-1", Quantity: 2, Price: 100, Total: 200}}
+		sellerOrders := models.NewSellerOrders(products, models.OrderStatusChange{To: status, Actor: models.OrderActorSystem})
+		sellerOrders[0].RefundedAmount = refunded
+		return &models.Order{
+			ID:             id,
+			User:           "customer-1",
+			Products:       products,
+			Amount:         200,
+			RefundedAmount: refunded,
+			Status:         status,
+			SellerOrders:   sellerOrders,
+		}
+	}
+
+	repo := &fakeOrderRepo{orders: map[string]*models.Order{
+		"order-open":   order("order-open", models.OrderStatusCreated, 0),
+		"order-closed": order("order-closed", models.OrderStatusCancelled, 200),
+	}}
+	return NewOrderHandler(service.NewOrderService(repo, nil, nil, nil, quietNotifier{})), repo
+}
+
+func TestSellerOrderHandlersForbidOtherSellers(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		route   string
+		path    string
+		body    string
+		handler func(*OrderHandler) gin.HandlerFunc
+	}{
+		{"GetSellerOrderDetails", http.MethodGet, "/seller/:orderId", "/seller/order-open", "", func(h *OrderHandler) gin.HandlerFunc { return h.GetSellerOrderDetails }},
+		{"AcceptOrder", http.MethodPut, "/seller/:orderId/accept", "/seller/order-open/accept", "", func(h *OrderHandler) gin.HandlerFunc { return h.AcceptOrder }},
+		{"UpdateOrderStatus", http.MethodPut, "/seller/:orderId/status", "/seller/order-open/status", `{"status":"paid and processing"}`, func(h *OrderHandler) gin.HandlerFunc { return h.UpdateOrderStatus }},
+		{"DeleteOrder", http.MethodDelete, "/seller/:orderId", "/seller/order-closed", "", func(h *OrderHandler) gin.HandlerFunc { return h.DeleteOrder }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, repo := newSellerOrderHandler()
+
+			recorder := serveAs("seller-2", tt.method, tt.route, tt.path, tt.body, tt.handler(h))
+			if recorder.Code != http.StatusForbidden {
+				t.Fatalf("another seller got %d, want 403: %s", recorder.Code, recorder.Body)
+			}
+			if len(repo.orders) != 2 || repo.orders["order-open"].SellerOrders[0].Status != models.OrderStatusCreated {
+				t.Fatal("another seller changed the order")
+			}
+
+			recorder = serveAs("seller-1", tt.method, tt.route, tt.path, tt.body, tt.handler(h))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("owning seller got %d, want 200: %s", recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteOrderRejectsOpenOrders(t *testing.T) {
+	h, repo := newSellerOrderHandler()
+
+	recorder := serveAs("seller-1", http.MethodDelete, "/seller/:orderId", "/seller/order-open", "", h.DeleteOrder)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("deleting an open order got %d, want 400: %s", recorder.Code, recorder.Body)
+	}
+	if _, ok := repo.orders["order-open"]; !ok {
+		t.Fatal("the open order was deleted")
+	}
+}
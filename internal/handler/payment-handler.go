@@ -1,14 +1,21 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"strconv"
 
+	"e-commerce.com/internal/gateway"
+	"e-commerce.com/internal/middleware"
+	"e-commerce.com/internal/repository"
 	"e-commerce.com/internal/service"
 	"github.com/gin-gonic/gin"
 )
 
+// idempotencyKeyHeader lets clients retry payment requests without paying or ordering twice
+const idempotencyKeyHeader = "Idempotency-Key"
+
 type PaymentHandler struct {
 	service service.PaymentService
 }
@@ -24,20 +31,53 @@ type CartItem struct {
 
 type CartItemsRequest struct {
 	CartItems []CartItem `json:"cartItems"`
+	// Provider is the payment gateway to use, e.g. esewa, khalti or mock
+	Provider string `json:"provider"`
+	// ShippingAddressID and BillingAddressID are addresses of the address book, the defaults are used without them
+	ShippingAddressID string `json:"shippingAddressId"`
+	BillingAddressID  string `json:"billingAddressId"`
 }
 
+// ProcessSuccessfulPaymentRequest carries what the provider appended to the success url.
+// Data is the base64 payload eSewa sends, Params holds the query parameters of other providers
 type ProcessSuccessfulPaymentRequest struct {
-	TransactionUUID string `json:"transaction_uuid"`
+	Provider string            `json:"provider"`
+	Data     string            `json:"data"`
+	Params   map[string]string `json:"params"`
 }
 
 func (h *PaymentHandler) InitiatePayment(c *gin.Context) {
 	var cartItemsReq CartItemsRequest
-	if err := c.ShouldBindJSON(&cartItemsReq); err != nil {
+	if err := c.ShouldBindJSON(&cartItemsReq); err != nil && !errors.Is(err, io.EOF) {
 		fmt.Println("error in data : ", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
+	opts := service.CheckoutOptions{
+		Provider:          cartItemsReq.Provider,
+		IdempotencyKey:    c.GetHeader(idempotencyKeyHeader),
+		ShippingAddressID: cartItemsReq.ShippingAddressID,
+		BillingAddressID:  cartItemsReq.BillingAddressID,
+	}
+
+	// Without explicit items the cart stored on the server is checked out
+	if len(cartItemsReq.CartItems) == 0 {
+		userId, _, _, ok := middleware.GetUserFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+			return
+		}
+
+		payment, quote, err := h.service.CheckoutCart(c, userId, opts)
+		if err != nil {
+			c.JSON(paymentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"url": payment.PaymentURL, "payment": payment, "quote": quote, "success": true})
+		return
+	}
+
 	// Convert to service CartItem type
 	cartItems := make([]service.CartItem, len(cartItemsReq.CartItems))
 	for i, item := range cartItemsReq.CartItems {
@@ -50,42 +90,46 @@ func (h *PaymentHandler) InitiatePayment(c *gin.Context) {
 		}
 	}
 
-	paymentUrl, err := h.service.InitiatePayment(c, cartItems)
+	payment, quote, err := h.service.InitiatePayment(c, cartItems, opts)
 	if err != nil {
 		fmt.Println("failed to intitate paym;ent : ", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(paymentErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"url": paymentUrl, "success": true})
+	c.JSON(http.StatusOK, gin.H{"url": payment.PaymentURL, "payment": payment, "quote": quote, "success": true})
+}
+
+// QuoteCart returns the itemized price of the stored cart
+func (h *PaymentHandler) QuoteCart(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	quote, err := h.service.QuoteCart(c, userId)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"quote": quote, "success": true})
 }
 
 func (h *PaymentHandler) CheckPaymentStatus(c *gin.Context) {
 	// Get query parameters
 	transactionUUID := c.Query("transaction_uuid")
-	productCode := c.Query("product_code")
-	totalAmountStr := c.Query("total_amount")
 
 	// Validate required parameters
-	if transactionUUID == "" || productCode == "" || totalAmountStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "transaction_uuid, product_code, and total_amount are required",
-			"success": false,
-		})
-		return
-	}
-
-	// Parse total amount
-	totalAmount, err := strconv.ParseFloat(totalAmountStr, 64)
-	if err != nil {
+	if transactionUUID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "invalid total_amount format",
+			"error":   "transaction_uuid is required",
 			"success": false,
 		})
 		return
 	}
 
-	// Check payment status
-	statusResponse, err := h.service.CheckPaymentStatus(c, transactionUUID, productCode, strconv.FormatFloat(totalAmount, 'f', -1, 64))
+	// Check payment status with the provider the payment was made with
+	statusResponse, err := h.service.CheckPaymentStatus(c, transactionUUID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   err.Error(),
@@ -110,18 +154,35 @@ func (h *PaymentHandler) ProcessSuccessfulPayment(c *gin.Context) {
 		return
 	}
 
-	if req.TransactionUUID == "" {
+	params := req.Params
+	if params == nil {
+		params = make(map[string]string)
+	}
+	if req.Data != "" {
+		params["data"] = req.Data
+	}
+	if len(params) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "transaction_uuid is required",
+			"error":   "data or params are required",
 			"success": false,
 		})
 		return
 	}
 
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
 	// Process successful payment and create order
-	order, err := h.service.ProcessSuccessfulPayment(c, req.TransactionUUID)
+	order, err := h.service.ProcessSuccessfulPayment(c, userId, service.ProcessPaymentRequest{
+		Provider:       req.Provider,
+		Params:         params,
+		IdempotencyKey: c.GetHeader(idempotencyKeyHeader),
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(paymentErrorStatus(err), gin.H{
 			"error":   err.Error(),
 			"success": false,
 		})
@@ -135,6 +196,22 @@ func (h *PaymentHandler) ProcessSuccessfulPayment(c *gin.Context) {
 	})
 }
 
+// paymentErrorStatus maps payment and checkout errors to a http status code
+func paymentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrPaymentVerificationFailed), errors.Is(err, gateway.ErrUnknownProvider):
+		return http.StatusBadRequest
+	case errors.Is(err, repository.ErrIdempotencyInProgress):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrAddressNotFound):
+		return http.StatusBadRequest
+	default:
+		return cartErrorStatus(err)
+	}
+}
+
 func NewPaymentHandler(service service.PaymentService) *PaymentHandler {
 	return &PaymentHandler{service: service}
 }
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -75,7 +76,24 @@ func (h *ProductHandler) GetSellerProducts(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"products": products, "success": true, "page": pageInt, "limit": limitInt, "total": total, "hasMore": hasMore})
 }
 
+// productErrorStatus maps product service errors to HTTP status codes
+func productErrorStatus(err error) int {
+	switch {
+	case service.IsForbidden(err):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrProductNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (h *ProductHandler) UpdateProduct(c *gin.Context) {
+	sellerId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Seller not authenticated", "success": false})
+		return
+	}
 
 	productId := c.Param("productId")
 	if productId == "" {
@@ -87,24 +105,30 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
 		return
 	}
-	err := h.service.UpdateProduct(c, productId, &product)
+	err := h.service.UpdateProduct(c, sellerId, productId, &product)
 	fmt.Println("this is product : ", product)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Product updated successfully", "success": true})
 }
 
 func (h *ProductHandler) DeleteProduct(c *gin.Context) {
+	sellerId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Seller not authenticated", "success": false})
+		return
+	}
+
 	productId := c.Param("productId")
 	if productId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Product ID is required", "success": false})
 		return
 	}
-	err := h.service.DeleteProduct(c, productId)
+	err := h.service.DeleteProduct(c, sellerId, productId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully", "success": true})
@@ -122,8 +146,13 @@ func (h *ProductHandler) GetProductById(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
 		return
 	}
-	fmt.Println("htisis revies in handler: ", reviews)
-	c.JSON(http.StatusOK, gin.H{"product": product, "reviews": reviews, "success": true})
+	c.JSON(http.StatusOK, gin.H{
+		"product":    product,
+		"summary":    product.ReviewSummary(),
+		"reviews":    reviews.Reviews,
+		"nextCursor": reviews.NextCursor,
+		"success":    true,
+	})
 }
 
 func (h *ProductHandler) GetAllProducts(c *gin.Context) {
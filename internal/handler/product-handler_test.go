@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+	"e-commerce.com/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeProductRepo holds a single product of seller-1 and records what was done to it
+type fakeProductRepo struct {
+	repository.ProductRepo
+
+	product *models.Product
+	updated bool
+	deleted bool
+}
+
+func (r *fakeProductRepo) GetProductsByIDs(ctx context.Context, productIds []string) ([]*models.Product, error) {
+	var products []*models.Product
+	for _, id := range productIds {
+		if id == r.product.ID {
+			products = append(products, r.product)
+		}
+	}
+	return products, nil
+}
+
+func (r *fakeProductRepo) UpdateProduct(ctx context.Context, sellerId, productId string, product *models.UpdateProductRequest) error {
+	r.updated = true
+	return nil
+}
+
+func (r *fakeProductRepo) DeleteProduct(ctx context.Context, sellerId, productId string) error {
+	r.deleted = true
+	return nil
+}
+
+func TestSellerProductHandlersForbidOtherSellers(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		handler func(*ProductHandler) gin.HandlerFunc
+		changed func(*fakeProductRepo) bool
+	}{
+		{"UpdateProduct", http.MethodPut, `{"name":"Kettle","price":120}`, func(h *ProductHandler) gin.HandlerFunc { return h.UpdateProduct }, func(r *fakeProductRepo) bool { return r.updated }},
+		{"DeleteProduct", http.MethodDelete, "", func(h *ProductHandler) gin.HandlerFunc { return h.DeleteProduct }, func(r *fakeProductRepo) bool { return r.deleted }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeProductRepo{product: &models.Product{ID: "product-1", SellerID: "seller-1", Name: "Kettle"}}
+			h := NewProductHandler(service.NewProductService(repo, nil))
+
+			recorder := serveAs("seller-2", tt.method, "/products/:productId", "/products/product-1", tt.body, tt.handler(h))
+			if recorder.Code != http.StatusForbidden {
+				t.Fatalf("another seller got %d, want 403: %s", recorder.Code, recorder.Body)
+			}
+			if tt.changed(repo) {
+				t.Fatal("another seller changed the product")
+			}
+
+			recorder = serveAs("seller-1", tt.method, "/products/:productId", "/products/product-1", tt.body, tt.handler(h))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("owning seller got %d, want 200: %s", recorder.Code, recorder.Body)
+			}
+			if !tt.changed(repo) {
+				t.Fatal("the owning seller's change was not stored")
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"e-commerce.com/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type ReconciliationHandler struct {
+	service service.ReconciliationService
+}
+
+func NewReconciliationHandler(service service.ReconciliationService) *ReconciliationHandler {
+	return &ReconciliationHandler{service: service}
+}
+
+// GetLogs lists reconciliation decisions, filterable by decision and transaction_uuid
+func (h *ReconciliationHandler) GetLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	logs, total, err := h.service.GetLogs(c, c.Query("decision"), c.Query("transaction_uuid"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"logs": logs,
+			"pagination": gin.H{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
+
+// RunReconciliation runs a reconciliation pass now instead of waiting for the worker
+func (h *ReconciliationHandler) RunReconciliation(c *gin.Context) {
+	logs, err := h.service.ReconcileStalePayments(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "data": logs, "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": logs})
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"e-commerce.com/internal/middleware"
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type RefundHandler struct {
+	service service.RefundService
+}
+
+func NewRefundHandler(service service.RefundService) *RefundHandler {
+	return &RefundHandler{service: service}
+}
+
+// refundErrorStatus maps refund service errors to HTTP status codes
+func refundErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrRefundExceedsOrder),
+		errors.Is(err, service.ErrRefundNotAllowed):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrRefundLineNotFound):
+		return http.StatusNotFound
+	case service.IsForbidden(err):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// bindRefundRequest reads an optional refund body, an empty body refunds the whole order
+func bindRefundRequest(c *gin.Context) (models.CreateRefundRequest, bool) {
+	var req models.CreateRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return req, false
+	}
+	return req, true
+}
+
+func (h *RefundHandler) SellerRefundOrder(c *gin.Context) {
+	sellerId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Seller not authenticated", "success": false})
+		return
+	}
+
+	orderId := c.Param("orderId")
+	if orderId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order ID is required", "success": false})
+		return
+	}
+
+	req, ok := bindRefundRequest(c)
+	if !ok {
+		return
+	}
+
+	refund, err := h.service.SellerRefundOrder(c, sellerId, orderId, req)
+	if err != nil {
+		c.JSON(refundErrorStatus(err), gin.H{"error": err.Error(), "data": refund, "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": refund})
+}
+
+func (h *RefundHandler) AdminRefundOrder(c *gin.Context) {
+	adminId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	orderId := c.Param("orderId")
+	if orderId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order ID is required", "success": false})
+		return
+	}
+
+	req, ok := bindRefundRequest(c)
+	if !ok {
+		return
+	}
+
+	refund, err := h.service.RefundOrder(c, orderId, req, models.RefundInitiatorAdmin, adminId)
+	if err != nil {
+		c.JSON(refundErrorStatus(err), gin.H{"error": err.Error(), "data": refund, "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": refund})
+}
+
+func (h *RefundHandler) GetUserOrderRefunds(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	refunds, err := h.service.GetUserOrderRefunds(c, userId, c.Param("orderId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": refunds})
+}
+
+func (h *RefundHandler) GetOrderRefunds(c *gin.Context) {
+	refunds, err := h.service.GetOrderRefunds(c, c.Param("orderId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": refunds})
+}
+
+func (h *RefundHandler) GetRefunds(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	refunds, total, err := h.service.GetRefunds(c, c.Query("status"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"refunds": refunds,
+			"pagination": gin.H{
+				"page":  page,
+				"limit": limit,
+				"total": total,
+			},
+		},
+	})
+}
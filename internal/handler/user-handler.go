@@ -1,23 +1,28 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
+	"e-commerce.com/internal/middleware"
 	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
 	"e-commerce.com/internal/service"
 	"e-commerce.com/internal/utils"
 	"github.com/gin-gonic/gin"
 )
 
 type UserHandler struct {
-	userService service.UserService
+	userService  service.UserService
+	tokenService service.TokenService
 }
 
-func NewUserHandler(userService service.UserService) *UserHandler {
+func NewUserHandler(userService service.UserService, tokenService service.TokenService) *UserHandler {
 	return &UserHandler{
-		userService: userService,
+		userService:  userService,
+		tokenService: tokenService,
 	}
 }
 
@@ -52,13 +57,8 @@ func (h *UserHandler) LoginUserHandler(c *gin.Context) {
 		return
 	}
 
-	token, err := utils.GenerateJWT(utils.JwtDataType{
-		UserId:   user.ID,
-		FullName: user.Username,
-		Email:    user.Email,
-	})
-
-	if token == "" || err != nil {
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "failed to generate token",
 			"success": false,
@@ -67,9 +67,11 @@ func (h *UserHandler) LoginUserHandler(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"token":   token,
-		"message": "login successfully",
+		"success":      true,
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+		"message":      "login successfully",
 	})
 
 }
@@ -107,6 +109,15 @@ func (h *UserHandler) DeleteUserSessionHandler(c *gin.Context) {
 		return
 	}
 
+	// The token and its refresh tokens stop working right away instead of when they expire
+	if err := h.tokenService.RevokeTokens(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "failed to revoke token",
+			"success": false,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "successfully deleted user session",
 		"success": true,
@@ -206,15 +217,8 @@ func (h *UserHandler) VerifyUserTokenHandler(c *gin.Context) {
 		return
 	}
 
-	jwtData := utils.JwtDataType{
-		UserId:   userData.ID,
-		FullName: userData.Username,
-		Email:    userData.Email,
-	}
-
-	token, err := utils.GenerateJWT(jwtData)
-
-	if token == "" || err != nil {
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), userData)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "user created, login due to internal problem",
 			"success": false,
@@ -223,9 +227,11 @@ func (h *UserHandler) VerifyUserTokenHandler(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"token":   token,
-		"message": "verified successfully",
+		"success":      true,
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+		"message":      "verified successfully",
 	})
 }
 
@@ -255,6 +261,8 @@ func (h *UserHandler) RegisterUserHandler(c *gin.Context) {
 		statusCode := http.StatusInternalServerError
 		if err.Error() == "email already registered" {
 			statusCode = http.StatusConflict
+		} else if errors.Is(err, service.ErrInvalidRole) {
+			statusCode = http.StatusBadRequest
 		}
 
 		c.JSON(statusCode, gin.H{
@@ -274,3 +282,232 @@ func (h *UserHandler) RegisterUserHandler(c *gin.Context) {
 	})
 
 }
+
+// RefreshTokenHandler exchanges a refresh token for a new access token and refresh token.
+// Every refresh token can be used once, reusing one logs out all tokens of its login
+func (h *UserHandler) RefreshTokenHandler(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "refreshToken is required",
+			"success": false,
+		})
+		return
+	}
+
+	tokens, err := h.tokenService.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrInvalidRefreshToken) || errors.Is(err, utils.ErrRefreshTokenReused) {
+			statusCode = http.StatusUnauthorized
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error":   err.Error(),
+			"success": false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+		"message":      "token refreshed",
+	})
+}
+
+func (h *UserHandler) ForgotPasswordHandler(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "a valid email is required",
+			"success": false,
+		})
+		return
+	}
+
+	if err := h.userService.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   err.Error(),
+			"success": false,
+		})
+		return
+	}
+
+	// The same answer for every email, registered or not
+	c.JSON(http.StatusOK, gin.H{
+		"message": "if an account exists for this email, a password reset link has been sent",
+		"success": true,
+	})
+}
+
+func (h *UserHandler) ResetPasswordHandler(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "token and password are required",
+			"success": false,
+		})
+		return
+	}
+
+	err := h.userService.ResetPassword(c.Request.Context(), req.Token, req.Password)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrInvalidResetToken) || errors.Is(err, service.ErrWeakPassword) {
+			statusCode = http.StatusBadRequest
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error":   err.Error(),
+			"success": false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "password reset successfully, please log in again",
+		"success": true,
+	})
+}
+
+// userErrorStatus maps profile errors to HTTP status codes
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrWeakPassword),
+		errors.Is(err, service.ErrInvalidProfile),
+		errors.Is(err, utils.ErrInvalidEmailChangeToken):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrIncorrectPassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrEmailExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *UserHandler) GetProfileHandler(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	user, err := h.userService.GetProfile(c.Request.Context(), userId)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user.Safe(), "success": true})
+}
+
+func (h *UserHandler) UpdateProfileHandler(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request payload", "success": false})
+		return
+	}
+
+	user, err := h.userService.UpdateProfile(c.Request.Context(), userId, &req)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user.Safe(), "message": "profile updated", "success": true})
+}
+
+// ChangePasswordHandler changes the password and logs out every other device. The caller
+// gets a new pair of tokens since the one it used is revoked as well
+func (h *UserHandler) ChangePasswordHandler(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currentPassword and newPassword are required", "success": false})
+		return
+	}
+
+	user, err := h.userService.ChangePassword(c.Request.Context(), userId, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "password changed, please log in again",
+			"success": false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+		"message":      "password changed successfully",
+	})
+}
+
+func (h *UserHandler) ChangeEmailHandler(c *gin.Context) {
+	userId, _, _, ok := middleware.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated", "success": false})
+		return
+	}
+
+	var req models.ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a valid newEmail and password are required", "success": false})
+		return
+	}
+
+	if err := h.userService.RequestEmailChange(c.Request.Context(), userId, req.NewEmail, req.Password); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "a confirmation link has been sent to the new email",
+		"success": true,
+	})
+}
+
+func (h *UserHandler) ConfirmEmailChangeHandler(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid  token", "success": false})
+		return
+	}
+
+	user, err := h.userService.ConfirmEmailChange(c.Request.Context(), token)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":    user.Safe(),
+		"message": "email changed successfully, please log in again",
+		"success": true,
+	})
+}
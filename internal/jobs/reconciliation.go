@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/service"
+)
+
+// ReconciliationWorker runs the payment reconciliation on a fixed interval
+type ReconciliationWorker struct {
+	service  service.ReconciliationService
+	interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewReconciliationWorker(service service.ReconciliationService, interval time.Duration) *ReconciliationWorker {
+	return &ReconciliationWorker{service: service, interval: interval}
+}
+
+// Start runs the first pass right away and then one per interval until Stop is called
+func (w *ReconciliationWorker) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			w.run(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	log.Printf("🔁 Payment reconciliation running every %s", w.interval)
+}
+
+// Stop cancels the current pass and waits for it to finish
+func (w *ReconciliationWorker) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (w *ReconciliationWorker) run(ctx context.Context) {
+	logs, err := w.service.ReconcileStalePayments(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("⚠️ Payment reconciliation failed: %v", err)
+	}
+	if len(logs) == 0 {
+		return
+	}
+
+	decisions := make(map[models.ReconciliationDecision]int)
+	for _, entry := range logs {
+		decisions[entry.Decision]++
+	}
+	log.Printf("🔁 Reconciled %d pending payments: %v", len(logs), decisions)
+}
@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"e-commerce.com/internal/service"
+)
+
+// ReservationWorker gives back the stock of reservations whose payment was not completed in time
+type ReservationWorker struct {
+	service  service.ReservationService
+	interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewReservationWorker(service service.ReservationService, interval time.Duration) *ReservationWorker {
+	return &ReservationWorker{service: service, interval: interval}
+}
+
+// Start runs the first pass right away and then one per interval until Stop is called
+func (w *ReservationWorker) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			w.run(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	log.Printf("📦 Stock reservation expiry running every %s", w.interval)
+}
+
+// Stop cancels the current pass and waits for it to finish
+func (w *ReservationWorker) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (w *ReservationWorker) run(ctx context.Context) {
+	expired, err := w.service.ExpireReservations(ctx)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("⚠️ Stock reservation expiry failed: %v", err)
+	}
+	if expired > 0 {
+		log.Printf("📦 Released the stock of %d expired reservations", expired)
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"net/http"
 	"strings"
 
+	"e-commerce.com/internal/models"
 	"e-commerce.com/internal/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 )
 
-// UserTokenVerification middleware verifies JWT tokens and extracts user information
-func UserTokenVerification() gin.HandlerFunc {
+// UserTokenVerification middleware verifies JWT tokens and extracts user information.
+// Revoked tokens are looked up in redisClient
+func UserTokenVerification(redisClient *redis.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := utils.ExtractToken(c, "user_token")
 
@@ -20,6 +23,7 @@ func UserTokenVerification() gin.HandlerFunc {
 				"error":   "Token is required",
 			})
 			c.Abort()
+			return
 		}
 
 		// Parse and validate the JWT token
@@ -43,10 +47,30 @@ func UserTokenVerification() gin.HandlerFunc {
 			return
 		}
 
+		// Tokens issued before a password reset are no longer accepted
+		revoked, err := utils.IsTokenRevoked(c.Request.Context(), redisClient, claims)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   "failed to verify token",
+			})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "Token has been revoked",
+			})
+			c.Abort()
+			return
+		}
+
 		// Store user information in the context for use in handlers
 		c.Set("userId", claims.UserId)
 		c.Set("userEmail", claims.Email)
 		c.Set("userFullName", claims.FullName)
+		c.Set("userRole", claimsRole(claims))
 		c.Set("userClaims", claims)
 
 		// Continue to the next middleware or handler
@@ -55,7 +79,7 @@ func UserTokenVerification() gin.HandlerFunc {
 }
 
 // OptionalUserTokenVerification middleware verifies JWT tokens if present, but doesn't require them
-func OptionalUserTokenVerification() gin.HandlerFunc {
+func OptionalUserTokenVerification(redisClient *redis.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -99,10 +123,17 @@ func OptionalUserTokenVerification() gin.HandlerFunc {
 			return
 		}
 
+		if revoked, err := utils.IsTokenRevoked(c.Request.Context(), redisClient, claims); err != nil || revoked {
+			// Revoked token, continue without user context
+			c.Next()
+			return
+		}
+
 		// Store user information in the context for use in handlers
 		c.Set("userId", claims.UserId)
 		c.Set("userEmail", claims.Email)
 		c.Set("userFullName", claims.FullName)
+		c.Set("userRole", claimsRole(claims))
 		c.Set("userClaims", claims)
 
 		// Continue to the next middleware or handler
@@ -110,6 +141,54 @@ func OptionalUserTokenVerification() gin.HandlerFunc {
 	}
 }
 
+// RequireRole only lets through users whose token carries one of the given roles.
+// It has to run after UserTokenVerification
+func RequireRole(roles ...models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := GetUserRoleFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "User not authenticated",
+			})
+			c.Abort()
+			return
+		}
+
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("role %s is not allowed to access this resource", role),
+		})
+		c.Abort()
+	}
+}
+
+// claimsRole treats tokens issued before roles were added to the claims as customer tokens
+func claimsRole(claims *utils.Claims) models.Role {
+	if claims.Role == "" {
+		return models.RoleCustomer
+	}
+	return claims.Role
+}
+
+// GetUserRoleFromContext extracts the role of the authenticated user from the Gin context
+func GetUserRoleFromContext(c *gin.Context) (models.Role, bool) {
+	role, exists := c.Get("userRole")
+	if !exists {
+		return "", false
+	}
+
+	userRole, ok := role.(models.Role)
+	return userRole, ok
+}
+
 // GetUserFromContext extracts user information from the Gin context
 func GetUserFromContext(c *gin.Context) (string, string, string, bool) {
 	userId, exists := c.Get("userId")
@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+type AddressType string
+
+const (
+	AddressShipping AddressType = "shipping"
+	AddressBilling  AddressType = "billing"
+)
+
+// Address is an entry of a user's address book, each user has at most one default address per type
+type Address struct {
+	ID         string      `json:"id" db:"id"`
+	UserID     string      `json:"userId" db:"user_id"`
+	Type       AddressType `json:"type" db:"type"`
+	FullName   string      `json:"fullName" db:"full_name"`
+	Phone      string      `json:"phone" db:"phone"`
+	Line1      string      `json:"line1" db:"line1"`
+	Line2      string      `json:"line2" db:"line2"`
+	City       string      `json:"city" db:"city"`
+	State      string      `json:"state" db:"state"`
+	PostalCode string      `json:"postalCode" db:"postal_code"`
+	Country    string      `json:"country" db:"country"`
+	IsDefault  bool        `json:"isDefault" db:"is_default"`
+	CreatedAt  time.Time   `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time   `json:"updatedAt" db:"updated_at"`
+}
+
+type AddressRequest struct {
+	Type       AddressType `json:"type"`
+	FullName   string      `json:"fullName" binding:"required"`
+	Phone      string      `json:"phone" binding:"required"`
+	Line1      string      `json:"line1" binding:"required"`
+	Line2      string      `json:"line2"`
+	City       string      `json:"city" binding:"required"`
+	State      string      `json:"state"`
+	PostalCode string      `json:"postalCode"`
+	Country    string      `json:"country" binding:"required"`
+	IsDefault  bool        `json:"isDefault"`
+}
+
+// AddressSnapshot is the copy of an address stored with a payment and its order,
+// later edits of the address book do not change where an order goes
+type AddressSnapshot struct {
+	AddressID  string `json:"addressId" bson:"addressId"`
+	FullName   string `json:"fullName" bson:"fullName"`
+	Phone      string `json:"phone" bson:"phone"`
+	Line1      string `json:"line1" bson:"line1"`
+	Line2      string `json:"line2,omitempty" bson:"line2,omitempty"`
+	City       string `json:"city" bson:"city"`
+	State      string `json:"state,omitempty" bson:"state,omitempty"`
+	PostalCode string `json:"postalCode,omitempty" bson:"postalCode,omitempty"`
+	Country    string `json:"country" bson:"country"`
+}
+
+func (a *Address) Snapshot() *AddressSnapshot {
+	return &AddressSnapshot{
+		AddressID:  a.ID,
+		FullName:   a.FullName,
+		Phone:      a.Phone,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		State:      a.State,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+// CartItem is a single entry of a user's cart as it is stored in Redis
+type CartItem struct {
+	ProductID string `json:"productId"`
+	Quantity  int64  `json:"quantity"`
+}
+
+// CartLine is a cart entry resolved against the current product data
+type CartLine struct {
+	ProductID string `json:"productId"`
+	SellerID  string `json:"sellerId"`
+	Name      string `json:"name"`
+	Image     string `json:"image"`
+	Quantity  int64  `json:"quantity"`
+	UnitPrice int64  `json:"unitPrice"`
+	Discount  int    `json:"discount"`
+	LineTotal int64  `json:"lineTotal"`
+	Stock     int    `json:"stock"`
+	Available bool   `json:"available"`
+	Issue     string `json:"issue,omitempty"`
+}
+
+type Cart struct {
+	UserID    string     `json:"userId"`
+	Lines     []CartLine `json:"lines"`
+	ItemCount int64      `json:"itemCount"`
+	Subtotal  int64      `json:"subtotal"`
+	HasIssues bool       `json:"hasIssues"`
+}
+
+type AddCartItemRequest struct {
+	ProductID string `json:"productId" binding:"required"`
+	Quantity  int64  `json:"quantity" binding:"required,min=1"`
+}
+
+type UpdateCartItemRequest struct {
+	Quantity int64 `json:"quantity" binding:"required,min=1"`
+}
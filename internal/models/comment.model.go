@@ -6,34 +6,199 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// ProductReviewReaction represents reactions (like 👍 ❤️ etc.) to a review
+type ReviewVote string
+
+const (
+	ReviewVoteHelpful   ReviewVote = "helpful"
+	ReviewVoteUnhelpful ReviewVote = "unhelpful"
+)
+
+// ReviewReaction is the name of an emoji reaction, counts are stored by name
+type ReviewReaction string
+
+const (
+	ReviewReactionLike  ReviewReaction = "like"
+	ReviewReactionLove  ReviewReaction = "love"
+	ReviewReactionHaha  ReviewReaction = "haha"
+	ReviewReactionWow   ReviewReaction = "wow"
+	ReviewReactionSad   ReviewReaction = "sad"
+	ReviewReactionAngry ReviewReaction = "angry"
+)
+
+// ReviewReactionEmojis are the reactions a review can get, with the emoji shown for each
+var ReviewReactionEmojis = map[ReviewReaction]string{
+	ReviewReactionLike:  "👍",
+	ReviewReactionLove:  "❤️",
+	ReviewReactionHaha:  "😂",
+	ReviewReactionWow:   "😮",
+	ReviewReactionSad:   "😢",
+	ReviewReactionAngry: "😡",
+}
+
+// ProductReviewReaction represents reactions (like 👍 ❤️ etc.) to a review. A user has one of them
+// per review, holding the user's helpful vote and emoji reaction
+type ProductReviewReaction struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ReviewID primitive.ObjectID `bson:"reviewId" json:"reviewId"`
+	UserId   string             `bson:"userId" json:"userId"`
+	// Vote and Reaction are empty when the user did not vote or react
+	Vote      ReviewVote     `bson:"vote,omitempty" json:"vote,omitempty"`
+	Reaction  ReviewReaction `bson:"reaction,omitempty" json:"reaction,omitempty"`
+	CreatedAt time.Time      `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time      `bson:"updatedAt" json:"updatedAt"`
+}
 
 // ProductReviewReply represents a reply to a review
 type ProductReviewReply struct {
-	ReplyID   primitive.ObjectID `bson:"replyId,omitempty" json:"replyId"`
-	Message   string             `bson:"message" json:"message"`
-	UserId    string             `bson:"userId" json:"userId"`
-	UserName  string             `bson:"userName" json:"userName"`
-	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ReplyID  primitive.ObjectID `bson:"replyId,omitempty" json:"replyId"`
+	Message  string             `bson:"message" json:"message"`
+	UserId   string             `bson:"userId" json:"userId"`
+	UserName string             `bson:"userName" json:"userName"`
+	// Official marks the response of the product's seller
+	Official  bool      `bson:"official" json:"official"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ProductReviewReplyFromClient is a reply as posted or edited, the author is the authenticated user
+type ProductReviewReplyFromClient struct {
+	Message string `json:"message" binding:"required"`
 }
 
 // ProductReview represents a user's review on a product
 type ProductReview struct {
-	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
-	ProductId string               `bson:"productId" json:"productId"`
-	UserId    string               `bson:"userId" json:"userId"`
-	UserName  string               `bson:"userName" json:"userName"`
-	Rating    int                  `bson:"rating" json:"rating"` // e.g. 1-5 stars
-	Comment   string               `bson:"comment" json:"comment"`
-	CreatedAt time.Time            `bson:"createdAt" json:"createdAt"`
-	UpdatedAt time.Time            `bson:"updatedAt" json:"updatedAt"`
-	Replies   []ProductReviewReply `bson:"replies,omitempty" json:"replies,omitempty"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProductId string             `bson:"productId" json:"productId"`
+	UserId    string             `bson:"userId" json:"userId"`
+	UserName  string             `bson:"userName" json:"userName"`
+	Rating    int                `bson:"rating" json:"rating"` // e.g. 1-5 stars
+	Comment   string             `bson:"comment" json:"comment"`
+	// VerifiedPurchase is set when the user had the product delivered in one of their orders
+	VerifiedPurchase bool `bson:"verifiedPurchase" json:"verifiedPurchase"`
+	// HelpfulCount is how many users found the review helpful, the key of the helpful sort
+	HelpfulCount   int `bson:"helpfulCount" json:"helpfulCount"`
+	UnhelpfulCount int `bson:"unhelpfulCount" json:"unhelpfulCount"`
+	// ReactionCounts counts the emoji reactions by name
+	ReactionCounts map[ReviewReaction]int `bson:"reactionCounts,omitempty" json:"reactionCounts,omitempty"`
+	CreatedAt      time.Time              `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time              `bson:"updatedAt" json:"updatedAt"`
+	Replies        []ProductReviewReply   `bson:"replies,omitempty" json:"replies,omitempty"`
 }
 
+// ProductReviewFromClient is a review as posted, the reviewer is the authenticated user
 type ProductReviewFromClient struct {
-	ProductId string `bson:"productId" json:"productId"`
-	UserId    string `bson:"userId" json:"userId"`
-	UserName  string `bson:"userName" json:"userName"`
+	ProductId string `bson:"productId" json:"productId" binding:"required"`
 	Rating    int    `bson:"rating" json:"rating"` // e.g. 1-5 stars
 	Comment   string `bson:"comment" json:"comment"`
 }
+
+// UpdateReviewRequest replaces the rating and comment of a review
+type UpdateReviewRequest struct {
+	Rating  int    `json:"rating"` // e.g. 1-5 stars
+	Comment string `json:"comment"`
+}
+
+type ReviewSort string
+
+const (
+	ReviewSortNewest  ReviewSort = "newest"
+	ReviewSortHighest ReviewSort = "highest"
+	ReviewSortLowest  ReviewSort = "lowest"
+	ReviewSortHelpful ReviewSort = "helpful"
+)
+
+// ReviewQuery selects a page of the reviews of a product
+type ReviewQuery struct {
+	Sort ReviewSort
+	// Rating only lists reviews with this many stars, 0 lists all of them
+	Rating       int
+	VerifiedOnly bool
+	// Cursor is the NextCursor of the previous page, empty for the first page
+	Cursor string
+	Limit  int
+}
+
+// ReviewCursor is the position after the last review of a page, in the order of Sort
+type ReviewCursor struct {
+	Sort         ReviewSort         `json:"s"`
+	ID           primitive.ObjectID `json:"id"`
+	CreatedAt    time.Time          `json:"c"`
+	Rating       int                `json:"r"`
+	HelpfulCount int                `json:"h"`
+}
+
+type ReviewPage struct {
+	Reviews []ProductReview `json:"reviews"`
+	// NextCursor is empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// ReviewSummary is the rating of a product over all its reviews
+type ReviewSummary struct {
+	Rating          float64         `json:"rating"`
+	RatingCount     int             `json:"ratingCount"`
+	RatingHistogram RatingHistogram `json:"ratingHistogram"`
+}
+
+// ReviewVoteRequest sets the user's vote on a review, an empty vote takes it back
+type ReviewVoteRequest struct {
+	Vote ReviewVote `json:"vote"`
+}
+
+// ReviewReactionRequest sets the user's reaction on a review, an empty reaction takes it back
+type ReviewReactionRequest struct {
+	Reaction ReviewReaction `json:"reaction"`
+}
+
+type ReviewReportReason string
+
+const (
+	ReviewReportSpam      ReviewReportReason = "spam"
+	ReviewReportAbusive   ReviewReportReason = "abusive"
+	ReviewReportOffensive ReviewReportReason = "offensive"
+	ReviewReportFake      ReviewReportReason = "fake"
+	ReviewReportOther     ReviewReportReason = "other"
+)
+
+type ReviewReportStatus string
+
+const (
+	// ReviewReportOpen reports wait in the moderation queue
+	ReviewReportOpen ReviewReportStatus = "open"
+	// ReviewReportRemoved reports got the review removed
+	ReviewReportRemoved ReviewReportStatus = "removed"
+	// ReviewReportDismissed reports left the review as it is
+	ReviewReportDismissed ReviewReportStatus = "dismissed"
+)
+
+// ReviewReport is a user's report of an abusive review, moderators resolve it
+type ReviewReport struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ReviewID   primitive.ObjectID `bson:"reviewId" json:"reviewId"`
+	ProductId  string             `bson:"productId" json:"productId"`
+	ReporterId string             `bson:"reporterId" json:"reporterId"`
+	Reason     ReviewReportReason `bson:"reason" json:"reason"`
+	Details    string             `bson:"details,omitempty" json:"details,omitempty"`
+	Status     ReviewReportStatus `bson:"status" json:"status"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	ResolvedAt *time.Time         `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+	ResolvedBy string             `bson:"resolvedBy,omitempty" json:"resolvedBy,omitempty"`
+}
+
+type ReportReviewRequest struct {
+	Reason  ReviewReportReason `json:"reason" binding:"required"`
+	Details string             `json:"details"`
+}
+
+type ReviewReportAction string
+
+const (
+	ReviewReportActionRemove  ReviewReportAction = "remove"
+	ReviewReportActionDismiss ReviewReportAction = "dismiss"
+)
+
+// ResolveReviewReportRequest either removes the reported review or dismisses the report
+type ResolveReviewReportRequest struct {
+	Action ReviewReportAction `json:"action" binding:"required"`
+}
@@ -5,21 +5,127 @@ import (
 )
 
 type ProductItem struct {
-	ProductID string `json:"productId" bson:"productId"`
-	SellerID  string `json:"sellerId" bson:"sellerId"` // Add seller ID to track which seller the product belongs to
-	Quantity  int64  `json:"quantity" bson:"quantity"`
-	Price     int64  `json:"price" bson:"price"`
+	ProductID       string `json:"productId" bson:"productId"`
+	SellerID        string `json:"sellerId" bson:"sellerId"` // Add seller ID to track which seller the product belongs to
+	Name            string `json:"name,omitempty" bson:"name,omitempty"`
+	Quantity        int64  `json:"quantity" bson:"quantity"`
+	Price           int64  `json:"price" bson:"price"` // unit price at the time of purchase
+	DiscountPercent int    `json:"discountPercent" bson:"discountPercent"`
+	Discount        int64  `json:"discount" bson:"discount"` // discount for the whole line
+	Total           int64  `json:"total" bson:"total"`
+	// RefundedQuantity counts the units claimed by refunds, it is raised before the money is sent back
+	RefundedQuantity int64 `json:"refundedQuantity,omitempty" bson:"refundedQuantity,omitempty"`
+}
+
+// LineTotal is what was paid for the line, orders created before totals were stored
+// get it worked out from the unit price
+func (p ProductItem) LineTotal() int64 {
+	if p.Total != 0 {
+		return p.Total
+	}
+	return p.Price*p.Quantity - p.Discount
 }
 
 type Order struct {
-	ID            string        `json:"id" bson:"_id,omitempty"`
-	User          string        `json:"userId" bson:"userId"`
-	Amount        int64         `json:"amount" bson:"amount"`
-	Products      []ProductItem `json:"products,omitempty" bson:"products"`
-	TransactionID string        `json:"transactionId" bson:"transactionId"`
-	Status        OrderStatus   `json:"status" bson:"status"`
-	CreatedAt     time.Time     `json:"createdAt" bson:"createdAt"`
-	UpdatedAt     time.Time     `json:"updatedAt" bson:"updatedAt"`
+	ID             string              `json:"id" bson:"_id,omitempty"`
+	User           string              `json:"userId" bson:"userId"`
+	Amount         int64               `json:"amount" bson:"amount"`
+	Products       []ProductItem       `json:"products,omitempty" bson:"products"`
+	TransactionID  string              `json:"transactionId" bson:"transactionId"`
+	Status         OrderStatus         `json:"status" bson:"status"`
+	RefundedAmount int64               `json:"refundedAmount" bson:"refundedAmount"`
+	StatusHistory  []OrderStatusChange `json:"statusHistory" bson:"statusHistory,omitempty"`
+	// ShippingAddress and BillingAddress are the addresses chosen at checkout, as they were then
+	ShippingAddress *AddressSnapshot `json:"shippingAddress,omitempty" bson:"shippingAddress,omitempty"`
+	BillingAddress  *AddressSnapshot `json:"billingAddress,omitempty" bson:"billingAddress,omitempty"`
+	// SellerOrders split the order by seller, Status is derived from theirs
+	SellerOrders []SellerOrder `json:"sellerOrders,omitempty" bson:"sellerOrders,omitempty"`
+	CreatedAt    time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// SellerOrder is the part of an order one seller fulfils. Each seller moves its own
+// sub-order through the status machine independently of the other sellers
+type SellerOrder struct {
+	SellerID       string              `json:"sellerId" bson:"sellerId"`
+	Status         OrderStatus         `json:"status" bson:"status"`
+	Amount         int64               `json:"amount" bson:"amount"` // sum of the line totals, without tax and delivery
+	RefundedAmount int64               `json:"refundedAmount" bson:"refundedAmount"`
+	Products       []ProductItem       `json:"products" bson:"products"`
+	Shipment       *Shipment           `json:"shipment,omitempty" bson:"shipment,omitempty"`
+	StatusHistory  []OrderStatusChange `json:"statusHistory" bson:"statusHistory,omitempty"`
+	UpdatedAt      time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Shipment is how a seller sent its part of an order
+type Shipment struct {
+	Carrier        string     `json:"carrier,omitempty" bson:"carrier,omitempty"`
+	TrackingNumber string     `json:"trackingNumber,omitempty" bson:"trackingNumber,omitempty"`
+	ShippedAt      *time.Time `json:"shippedAt,omitempty" bson:"shippedAt,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+}
+
+// NewSellerOrders groups order lines by seller, in the order the sellers first appear.
+// Every sub-order starts in initial.To with initial as its first history entry
+func NewSellerOrders(products []ProductItem, initial OrderStatusChange) []SellerOrder {
+	var sellerOrders []SellerOrder
+	index := make(map[string]int)
+	for _, item := range products {
+		i, ok := index[item.SellerID]
+		if !ok {
+			change := initial
+			change.SellerID = item.SellerID
+			i = len(sellerOrders)
+			index[item.SellerID] = i
+			sellerOrders = append(sellerOrders, SellerOrder{
+				SellerID:      item.SellerID,
+				Status:        initial.To,
+				StatusHistory: []OrderStatusChange{change},
+				UpdatedAt:     initial.At,
+			})
+		}
+		sellerOrders[i].Products = append(sellerOrders[i].Products, item)
+		sellerOrders[i].Amount += item.LineTotal()
+	}
+	return sellerOrders
+}
+
+// SellerOrder returns the seller's sub-order, or nil when the seller has none
+func (o *Order) SellerOrder(sellerId string) *SellerOrder {
+	for i := range o.SellerOrders {
+		if o.SellerOrders[i].SellerID == sellerId {
+			return &o.SellerOrders[i]
+		}
+	}
+	return nil
+}
+
+// ForSeller returns a copy of the order narrowed down to the seller's sub-order, so a seller
+// only sees its own lines, amount and status. Orders without sub-orders are split on the fly
+func (o *Order) ForSeller(sellerId string) *Order {
+	view := *o
+	// Sellers get the address to ship to, not the one the customer is billed at
+	view.BillingAddress = nil
+	if len(view.SellerOrders) == 0 {
+		view.SellerOrders = NewSellerOrders(o.Products, OrderStatusChange{To: o.Status, Actor: OrderActorSystem, At: o.UpdatedAt})
+	}
+
+	sub := view.SellerOrder(sellerId)
+	if sub == nil {
+		view.Products = nil
+		view.SellerOrders = nil
+		view.Amount = 0
+		view.RefundedAmount = 0
+		return &view
+	}
+
+	view.Products = sub.Products
+	view.Amount = sub.Amount
+	view.Status = sub.Status
+	view.RefundedAmount = sub.RefundedAmount
+	view.StatusHistory = sub.StatusHistory
+	view.SellerOrders = []SellerOrder{*sub}
+	return &view
 }
 
 type OrderStatus string
@@ -30,17 +136,45 @@ const (
 	OrderStatusShipping          OrderStatus = "shipping"
 	OrderStatusDelivered         OrderStatus = "delivered"
 	OrderStatusCancelled         OrderStatus = "cancelled"
+	OrderStatusReturned          OrderStatus = "returned"
+	OrderStatusRefunded          OrderStatus = "refunded"
+)
+
+// OrderActor is who moved an order to a new status
+type OrderActor string
+
+const (
+	OrderActorCustomer OrderActor = "customer"
+	OrderActorSeller   OrderActor = "seller"
+	OrderActorSystem   OrderActor = "system"
 )
 
+// OrderStatusChange is one entry of an order's status history
+type OrderStatusChange struct {
+	From    OrderStatus `json:"from,omitempty" bson:"from,omitempty"`
+	To      OrderStatus `json:"to" bson:"to"`
+	Actor   OrderActor  `json:"actor" bson:"actor"`
+	ActorID string      `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	// SellerID is set on changes of a seller's sub-order
+	SellerID string    `json:"sellerId,omitempty" bson:"sellerId,omitempty"`
+	Reason   string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	At       time.Time `json:"at" bson:"at"`
+}
+
 type OrderWithProductDetails struct {
-	ID            string                `json:"id" bson:"_id,omitempty"`
-	User          string                `json:"userId" bson:"userId"`
-	Amount        int64                 `json:"amount" bson:"amount"`
-	Products      []ProductWithQuantity `json:"products,omitempty" bson:"products"`
-	TransactionID string                `json:"transactionId" bson:"transactionId"`
-	Status        OrderStatus           `json:"status" bson:"status"`
-	CreatedAt     time.Time             `json:"createdAt" bson:"createdAt"`
-	UpdatedAt     time.Time             `json:"updatedAt" bson:"updatedAt"`
+	ID              string                `json:"id" bson:"_id,omitempty"`
+	User            string                `json:"userId" bson:"userId"`
+	Amount          int64                 `json:"amount" bson:"amount"`
+	Products        []ProductWithQuantity `json:"products,omitempty" bson:"products"`
+	TransactionID   string                `json:"transactionId" bson:"transactionId"`
+	Status          OrderStatus           `json:"status" bson:"status"`
+	RefundedAmount  int64                 `json:"refundedAmount" bson:"refundedAmount"`
+	StatusHistory   []OrderStatusChange   `json:"statusHistory" bson:"statusHistory,omitempty"`
+	ShippingAddress *AddressSnapshot      `json:"shippingAddress,omitempty" bson:"shippingAddress,omitempty"`
+	BillingAddress  *AddressSnapshot      `json:"billingAddress,omitempty" bson:"billingAddress,omitempty"`
+	SellerOrders    []SellerOrder         `json:"sellerOrders,omitempty" bson:"sellerOrders,omitempty"`
+	CreatedAt       time.Time             `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       time.Time             `json:"updatedAt" bson:"updatedAt"`
 }
 
 type ProductWithQuantity struct {
@@ -57,4 +191,6 @@ type ProductWithQuantity struct {
 	CreatedAt      time.Time   `json:"createdAt" bson:"createdAt"`
 	UpdatedAt      time.Time   `json:"updatedAt" bson:"updatedAt"`
 	BoughtQuantity int64       `json:"boughtQuantity" bson:"boughtQuantity"`
+	LineDiscount   int64       `json:"lineDiscount" bson:"lineDiscount"`
+	LineTotal      int64       `json:"lineTotal" bson:"lineTotal"`
 }
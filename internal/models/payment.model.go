@@ -9,15 +9,40 @@ const (
 	PaymentStatusSuccess  PaymentStatus = "success"
 	PaymentStatusFailed   PaymentStatus = "failed"
 	PaymentStatusRefunded PaymentStatus = "refunded"
+	// PaymentStatusPartiallyRefunded is a successful payment of which only a part was given back
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	// PaymentStatusExpired is a payment that stayed pending longer than the configured TTL
+	PaymentStatusExpired PaymentStatus = "expired"
 )
 
+// PaymentItem is a checkout line snapshotted when the payment is initiated
+type PaymentItem struct {
+	ProductID       string `json:"productId" bson:"productId"`
+	SellerID        string `json:"sellerId" bson:"sellerId"`
+	Name            string `json:"name" bson:"name"`
+	Quantity        int64  `json:"quantity" bson:"quantity"`
+	UnitPrice       int64  `json:"unitPrice" bson:"unitPrice"`
+	DiscountPercent int    `json:"discountPercent" bson:"discountPercent"`
+	Discount        int64  `json:"discount" bson:"discount"`
+	Total           int64  `json:"total" bson:"total"`
+}
+
 type Payment struct {
 	ID              string        `json:"id" bson:"_id"`
 	Amount          int64         `json:"amount" bson:"amount"`
 	UserId          string        `json:"userId" bson:"userId"`
 	TransactionUuid string        `json:"transactionUuid" bson:"transactionUuid"`
 	ProductIDs      []string      `json:"productIds" bson:"productIds"`
-	Status          PaymentStatus `json:"status" bson:"status"`
-	CreatedAt       time.Time     `json:"createdAt" bson:"createdAt"`
-	UpdatedAt       time.Time     `json:"updatedAt" bson:"updatedAt"`
+	Items           []PaymentItem `json:"items,omitempty" bson:"items,omitempty"`
+	// ShippingAddress and BillingAddress are snapshotted from the address book at checkout
+	ShippingAddress  *AddressSnapshot `json:"shippingAddress,omitempty" bson:"shippingAddress,omitempty"`
+	BillingAddress   *AddressSnapshot `json:"billingAddress,omitempty" bson:"billingAddress,omitempty"`
+	Status           PaymentStatus    `json:"status" bson:"status"`
+	Provider         string           `json:"provider" bson:"provider"`
+	GatewaySessionID string           `json:"gatewaySessionId,omitempty" bson:"gatewaySessionId,omitempty"`
+	GatewayRef       string           `json:"gatewayRef,omitempty" bson:"gatewayRef,omitempty"`
+	FailureReason    string           `json:"failureReason,omitempty" bson:"failureReason,omitempty"`
+	RefundedAmount   int64            `json:"refundedAmount" bson:"refundedAmount"`
+	CreatedAt        time.Time        `json:"createdAt" bson:"createdAt"`
+	UpdatedAt        time.Time        `json:"updatedAt" bson:"updatedAt"`
 }
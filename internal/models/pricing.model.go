@@ -0,0 +1,30 @@
+package models
+
+// QuoteLine is a single priced line of a checkout quote
+type QuoteLine struct {
+	ProductID       string `json:"productId"`
+	SellerID        string `json:"sellerId"`
+	Name            string `json:"name"`
+	Quantity        int64  `json:"quantity"`
+	UnitPrice       int64  `json:"unitPrice"`
+	DiscountPercent int    `json:"discountPercent"`
+	Subtotal        int64  `json:"subtotal"`
+	Discount        int64  `json:"discount"`
+	Total           int64  `json:"total"`
+}
+
+// PriceQuote is the server computed price of a checkout, all amounts are in rupees
+type PriceQuote struct {
+	Lines         []QuoteLine `json:"lines"`
+	Subtotal      int64       `json:"subtotal"`
+	Discount      int64       `json:"discount"`
+	Tax           int64       `json:"tax"`
+	ServiceCharge int64       `json:"serviceCharge"`
+	Delivery      int64       `json:"delivery"`
+	Total         int64       `json:"total"`
+}
+
+// Amount is the taxable amount after discounts
+func (q *PriceQuote) Amount() int64 {
+	return q.Subtotal - q.Discount
+}
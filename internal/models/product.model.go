@@ -13,10 +13,27 @@ type Product struct {
 	Category    interface{} `json:"category" bson:"category"`
 	Images      []string    `json:"images" bson:"images"`
 	Stock       int         `json:"stock" bson:"stock"`
-	Rating      int         `json:"rating" bson:"rating"`
-	RatingCount int         `json:"ratingCount" bson:"ratingCount"`
-	CreatedAt   time.Time   `json:"createdAt" bson:"createdAt"`
-	UpdatedAt   time.Time   `json:"updatedAt" bson:"updatedAt"`
+	// Rating is the average of the review ratings, RatingSum / RatingCount
+	Rating          float64         `json:"rating" bson:"rating"`
+	RatingCount     int             `json:"ratingCount" bson:"ratingCount"`
+	RatingSum       int             `json:"-" bson:"ratingSum"`
+	RatingHistogram RatingHistogram `json:"ratingHistogram" bson:"ratingHistogram"`
+	CreatedAt       time.Time       `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt" bson:"updatedAt"`
+}
+
+// RatingHistogram counts the reviews of a product by star rating
+type RatingHistogram struct {
+	OneStar   int `json:"1" bson:"1"`
+	TwoStar   int `json:"2" bson:"2"`
+	ThreeStar int `json:"3" bson:"3"`
+	FourStar  int `json:"4" bson:"4"`
+	FiveStar  int `json:"5" bson:"5"`
+}
+
+// ReviewSummary returns the rating aggregates of the product
+func (p *Product) ReviewSummary() ReviewSummary {
+	return ReviewSummary{Rating: p.Rating, RatingCount: p.RatingCount, RatingHistogram: p.RatingHistogram}
 }
 
 type UpdateProductRequest struct {
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// ReconciliationDecision is what the reconciliation job did with a pending payment
+type ReconciliationDecision string
+
+const (
+	ReconciliationCompleted ReconciliationDecision = "completed"
+	ReconciliationFailed    ReconciliationDecision = "failed"
+	ReconciliationExpired   ReconciliationDecision = "expired"
+	// ReconciliationPending leaves the payment alone, it is checked again on the next run
+	ReconciliationPending ReconciliationDecision = "pending"
+	ReconciliationError   ReconciliationDecision = "error"
+)
+
+// ReconciliationLog records one decision of the reconciliation job for finance to review
+type ReconciliationLog struct {
+	ID              string                 `json:"id" bson:"_id"`
+	PaymentID       string                 `json:"paymentId" bson:"paymentId"`
+	TransactionUuid string                 `json:"transactionUuid" bson:"transactionUuid"`
+	UserID          string                 `json:"userId" bson:"userId"`
+	Provider        string                 `json:"provider" bson:"provider"`
+	Amount          int64                  `json:"amount" bson:"amount"`
+	GatewayStatus   string                 `json:"gatewayStatus,omitempty" bson:"gatewayStatus,omitempty"`
+	GatewayAmount   float64                `json:"gatewayAmount,omitempty" bson:"gatewayAmount,omitempty"`
+	Decision        ReconciliationDecision `json:"decision" bson:"decision"`
+	OrderID         string                 `json:"orderId,omitempty" bson:"orderId,omitempty"`
+	Message         string                 `json:"message" bson:"message"`
+	PaymentAge      string                 `json:"paymentAge" bson:"paymentAge"`
+	CreatedAt       time.Time              `json:"createdAt" bson:"createdAt"`
+}
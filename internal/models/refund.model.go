@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusSucceeded RefundStatus = "succeeded"
+	RefundStatusFailed    RefundStatus = "failed"
+	// RefundStatusManual is a refund the provider can not make through its api,
+	// finance settles it from the provider's merchant portal
+	RefundStatusManual RefundStatus = "manual"
+)
+
+// RefundInitiator is who asked for a refund
+type RefundInitiator string
+
+const (
+	RefundInitiatorSeller RefundInitiator = "seller"
+	RefundInitiatorAdmin  RefundInitiator = "admin"
+	RefundInitiatorSystem RefundInitiator = "system"
+)
+
+// RefundItem is the part of an order line a refund gives back
+type RefundItem struct {
+	ProductID string `json:"productId" bson:"productId"`
+	SellerID  string `json:"sellerId" bson:"sellerId"`
+	Quantity  int64  `json:"quantity" bson:"quantity"`
+	Amount    int64  `json:"amount" bson:"amount"`
+}
+
+type Refund struct {
+	ID              string          `json:"id" bson:"_id"`
+	OrderID         string          `json:"orderId" bson:"orderId"`
+	PaymentID       string          `json:"paymentId" bson:"paymentId"`
+	TransactionUuid string          `json:"transactionUuid" bson:"transactionUuid"`
+	UserID          string          `json:"userId" bson:"userId"`
+	Provider        string          `json:"provider" bson:"provider"`
+	Items           []RefundItem    `json:"items" bson:"items"`
+	Amount          int64           `json:"amount" bson:"amount"`
+	Reason          string          `json:"reason" bson:"reason"`
+	InitiatedBy     RefundInitiator `json:"initiatedBy" bson:"initiatedBy"`
+	InitiatorID     string          `json:"initiatorId,omitempty" bson:"initiatorId,omitempty"`
+	Status          RefundStatus    `json:"status" bson:"status"`
+	GatewayRef      string          `json:"gatewayRef,omitempty" bson:"gatewayRef,omitempty"`
+	FailureReason   string          `json:"failureReason,omitempty" bson:"failureReason,omitempty"`
+	CreatedAt       time.Time       `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt" bson:"updatedAt"`
+}
+
+type RefundLineRequest struct {
+	ProductID string `json:"productId" binding:"required"`
+	Quantity  int64  `json:"quantity" binding:"required,min=1"`
+}
+
+// CreateRefundRequest refunds the given lines, without lines everything that is left is refunded.
+// SellerID limits the refund to the lines of that seller's sub-order
+type CreateRefundRequest struct {
+	Items    []RefundLineRequest `json:"items" binding:"dive"`
+	Reason   string              `json:"reason"`
+	SellerID string              `json:"sellerId"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// ReservationStatus tracks the stock held back for a payment
+type ReservationStatus string
+
+const (
+	// ReservationActive holds the stock until the payment completes or the reservation expires
+	ReservationActive ReservationStatus = "active"
+	// ReservationCommitted turned into the stock sold with an order
+	ReservationCommitted ReservationStatus = "committed"
+	// ReservationReleased gave the stock back because the payment failed
+	ReservationReleased ReservationStatus = "released"
+	// ReservationExpired gave the stock back because the payment was not completed in time
+	ReservationExpired ReservationStatus = "expired"
+)
+
+type ReservedItem struct {
+	ProductID string `json:"productId" bson:"productId"`
+	Quantity  int64  `json:"quantity" bson:"quantity"`
+}
+
+// StockReservation is the stock taken off the products when a payment is started.
+// The stock is decremented right away, committing only marks it as sold
+type StockReservation struct {
+	ID              string            `json:"id" bson:"_id"`
+	TransactionUuid string            `json:"transactionUuid" bson:"transactionUuid"`
+	UserID          string            `json:"userId" bson:"userId"`
+	Items           []ReservedItem    `json:"items" bson:"items"`
+	Status          ReservationStatus `json:"status" bson:"status"`
+	Reason          string            `json:"reason,omitempty" bson:"reason,omitempty"`
+	ExpiresAt       time.Time         `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt       time.Time         `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt" bson:"updatedAt"`
+}
@@ -9,6 +9,8 @@ type Role string
 const (
 	RoleCustomer Role = "customer"
 	RoleSeller   Role = "seller"
+	// RoleAdmin can not be chosen at registration, it is granted directly in the database
+	RoleAdmin Role = "admin"
 )
 
 type User struct {
@@ -18,8 +20,25 @@ type User struct {
 	Password   string    `json:"password,omitempty" db:"password"` // hashed
 	Role       Role      `json:"role" db:"role"`                   // ENUM: customer/seller
 	IsVerified bool      `json:"isVerified" db:"is_verified"`      // snake_case
-	CreatedAt  time.Time `json:"createdAt" db:"created_at"`        // snake_case
-	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"`        // snake_case
+	Phone      string    `json:"phone" db:"phone"`
+	AvatarURL  string    `json:"avatarUrl" db:"avatar_url"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"` // snake_case
+	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"` // snake_case
+}
+
+// Safe returns the user without the password hash
+func (u *User) Safe() SafeUser {
+	return SafeUser{
+		ID:         u.ID,
+		Username:   u.Username,
+		Email:      u.Email,
+		Role:       u.Role,
+		IsVerified: u.IsVerified,
+		Phone:      u.Phone,
+		AvatarURL:  u.AvatarURL,
+		CreatedAt:  u.CreatedAt,
+		UpdatedAt:  u.UpdatedAt,
+	}
 }
 
 type UserLogin struct {
@@ -27,12 +46,52 @@ type UserLogin struct {
 	Password string `json:"password"`
 }
 
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// UpdateProfileRequest changes the fields that are set and keeps the others
+type UpdateProfileRequest struct {
+	Username  *string `json:"userName"`
+	Phone     *string `json:"phone"`
+	AvatarURL *string `json:"avatarUrl"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"newEmail" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
 type SafeUser struct {
 	ID         string    `json:"id"`
 	Username   string    `json:"userName"`
 	Email      string    `json:"email"`
 	Role       Role      `json:"role"`
 	IsVerified bool      `json:"isVerified"`
+	Phone      string    `json:"phone,omitempty"`
+	AvatarURL  string    `json:"avatarUrl,omitempty"`
 	CreatedAt  time.Time `json:"createdAt"`
 	UpdatedAt  time.Time `json:"updatedAt"`
 }
+
+// TokenPair is returned on login, a new access token is got with the refresh token once it expires
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	// ExpiresIn is the lifetime of the access token in seconds
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
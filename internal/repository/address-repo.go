@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AddressRepo interface {
+	CreateAddress(ctx context.Context, address *models.Address) error
+	GetAddresses(ctx context.Context, userId string) ([]models.Address, error)
+	// GetAddress returns nil when the user has no address with the id
+	GetAddress(ctx context.Context, userId, addressId string) (*models.Address, error)
+	// GetDefaultAddress returns nil when the user has no default address of the type
+	GetDefaultAddress(ctx context.Context, userId string, addressType models.AddressType) (*models.Address, error)
+	UpdateAddress(ctx context.Context, address *models.Address) (bool, error)
+	DeleteAddress(ctx context.Context, userId, addressId string) (bool, error)
+	SetDefaultAddress(ctx context.Context, userId, addressId string) (bool, error)
+}
+
+const addressColumns = `id, user_id, type, full_name, phone, line1, line2, city, state, postal_code, country, is_default, created_at, updated_at`
+
+type addressRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewAddressRepository(pool *pgxpool.Pool) AddressRepo {
+	return &addressRepo{pool: pool}
+}
+
+func scanAddress(row pgx.Row) (*models.Address, error) {
+	var address models.Address
+	err := row.Scan(
+		&address.ID,
+		&address.UserID,
+		&address.Type,
+		&address.FullName,
+		&address.Phone,
+		&address.Line1,
+		&address.Line2,
+		&address.City,
+		&address.State,
+		&address.PostalCode,
+		&address.Country,
+		&address.IsDefault,
+		&address.CreatedAt,
+		&address.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// CreateAddress stores a new address. A default address replaces the user's previous default of its type
+func (r *addressRepo) CreateAddress(ctx context.Context, address *models.Address) error {
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		if address.IsDefault {
+			if err := clearDefaultAddress(ctx, tx, address.UserID, address.Type); err != nil {
+				return err
+			}
+		}
+
+		query := `
+			INSERT INTO addresses (` + addressColumns + `)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		`
+		_, err := tx.Exec(ctx, query,
+			address.ID,
+			address.UserID,
+			address.Type,
+			address.FullName,
+			address.Phone,
+			address.Line1,
+			address.Line2,
+			address.City,
+			address.State,
+			address.PostalCode,
+			address.Country,
+			address.IsDefault,
+			address.CreatedAt,
+			address.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create address: %w", err)
+		}
+		return nil
+	})
+}
+
+func (r *addressRepo) GetAddresses(ctx context.Context, userId string) ([]models.Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = $1 ORDER BY is_default DESC, created_at`
+
+	rows, err := r.pool.Query(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses: %w", err)
+	}
+	defer rows.Close()
+
+	addresses := []models.Address{}
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read address: %w", err)
+		}
+		addresses = append(addresses, *address)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get addresses: %w", err)
+	}
+	return addresses, nil
+}
+
+func (r *addressRepo) GetAddress(ctx context.Context, userId, addressId string) (*models.Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE id = $1 AND user_id = $2`
+
+	address, err := scanAddress(r.pool.QueryRow(ctx, query, addressId, userId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get address: %w", err)
+	}
+	return address, nil
+}
+
+func (r *addressRepo) GetDefaultAddress(ctx context.Context, userId string, addressType models.AddressType) (*models.Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = $1 AND type = $2 AND is_default`
+
+	address, err := scanAddress(r.pool.QueryRow(ctx, query, userId, addressType))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default address: %w", err)
+	}
+	return address, nil
+}
+
+// UpdateAddress replaces the fields of an address of the user, false when there is no such address
+func (r *addressRepo) UpdateAddress(ctx context.Context, address *models.Address) (bool, error) {
+	updated := false
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		if address.IsDefault {
+			if err := clearDefaultAddress(ctx, tx, address.UserID, address.Type); err != nil {
+				return err
+			}
+		}
+
+		query := `
+			UPDATE addresses
+			SET type = $1, full_name = $2, phone = $3, line1 = $4, line2 = $5, city = $6,
+				state = $7, postal_code = $8, country = $9, is_default = $10, updated_at = $11
+			WHERE id = $12 AND user_id = $13
+		`
+		result, err := tx.Exec(ctx, query,
+			address.Type,
+			address.FullName,
+			address.Phone,
+			address.Line1,
+			address.Line2,
+			address.City,
+			address.State,
+			address.PostalCode,
+			address.Country,
+			address.IsDefault,
+			address.UpdatedAt,
+			address.ID,
+			address.UserID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update address: %w", err)
+		}
+		updated = result.RowsAffected() > 0
+		return nil
+	})
+	return updated, err
+}
+
+func (r *addressRepo) DeleteAddress(ctx context.Context, userId, addressId string) (bool, error) {
+	result, err := r.pool.Exec(ctx, `DELETE FROM addresses WHERE id = $1 AND user_id = $2`, addressId, userId)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete address: %w", err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// SetDefaultAddress makes the address the user's default of its type, false when there is no such address
+func (r *addressRepo) SetDefaultAddress(ctx context.Context, userId, addressId string) (bool, error) {
+	updated := false
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		var addressType models.AddressType
+		err := tx.QueryRow(ctx, `SELECT type FROM addresses WHERE id = $1 AND user_id = $2 FOR UPDATE`, addressId, userId).Scan(&addressType)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get address: %w", err)
+		}
+
+		if err := clearDefaultAddress(ctx, tx, userId, addressType); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE addresses SET is_default = true, updated_at = $1 WHERE id = $2`, time.Now(), addressId); err != nil {
+			return fmt.Errorf("failed to set default address: %w", err)
+		}
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+// clearDefaultAddress unsets the user's default address of the type, so another one can become the default
+func clearDefaultAddress(ctx context.Context, tx pgx.Tx, userId string, addressType models.AddressType) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE addresses SET is_default = false, updated_at = $1 WHERE user_id = $2 AND type = $3 AND is_default`,
+		time.Now(), userId, addressType,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear default address: %w", err)
+	}
+	return nil
+}
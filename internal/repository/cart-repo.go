@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// carts are kept for a month after the last change
+const cartTTL = 30 * 24 * time.Hour
+
+type CartRepo interface {
+	GetCartItems(ctx context.Context, userId string) ([]models.CartItem, error)
+	GetCartItemQuantity(ctx context.Context, userId, productId string) (int64, error)
+	SetCartItemQuantity(ctx context.Context, userId, productId string, quantity int64) error
+	// IncrementCartItem adds delta to the quantity of a cart item and returns the new quantity
+	IncrementCartItem(ctx context.Context, userId, productId string, delta int64) (int64, error)
+	RemoveCartItem(ctx context.Context, userId, productId string) error
+	ClearCart(ctx context.Context, userId string) error
+}
+
+type cartRepo struct {
+	redisClient *redis.Client
+}
+
+// cartKey is the same key PaymentRepo.ClearUserCart removes after checkout
+func cartKey(userId string) string {
+	return fmt.Sprintf("cart:%s", userId)
+}
+
+func (r *cartRepo) GetCartItems(ctx context.Context, userId string) ([]models.CartItem, error) {
+	entries, err := r.redisClient.HGetAll(ctx, cartKey(userId)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart for user %s: %w", userId, err)
+	}
+
+	items := make([]models.CartItem, 0, len(entries))
+	for productId, rawQuantity := range entries {
+		quantity, err := strconv.ParseInt(rawQuantity, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity stored for product %s: %w", productId, err)
+		}
+		items = append(items, models.CartItem{ProductID: productId, Quantity: quantity})
+	}
+	return items, nil
+}
+
+func (r *cartRepo) GetCartItemQuantity(ctx context.Context, userId, productId string) (int64, error) {
+	quantity, err := r.redisClient.HGet(ctx, cartKey(userId), productId).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get cart item: %w", err)
+	}
+	return quantity, nil
+}
+
+func (r *cartRepo) SetCartItemQuantity(ctx context.Context, userId, productId string, quantity int64) error {
+	key := cartKey(userId)
+	pipe := r.redisClient.TxPipeline()
+	pipe.HSet(ctx, key, productId, quantity)
+	pipe.Expire(ctx, key, cartTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to update cart item: %w", err)
+	}
+	return nil
+}
+
+func (r *cartRepo) IncrementCartItem(ctx context.Context, userId, productId string, delta int64) (int64, error) {
+	key := cartKey(userId)
+	pipe := r.redisClient.TxPipeline()
+	quantity := pipe.HIncrBy(ctx, key, productId, delta)
+	pipe.Expire(ctx, key, cartTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to update cart item: %w", err)
+	}
+	return quantity.Val(), nil
+}
+
+func (r *cartRepo) RemoveCartItem(ctx context.Context, userId, productId string) error {
+	if err := r.redisClient.HDel(ctx, cartKey(userId), productId).Err(); err != nil {
+		return fmt.Errorf("failed to remove cart item: %w", err)
+	}
+	return nil
+}
+
+func (r *cartRepo) ClearCart(ctx context.Context, userId string) error {
+	if err := r.redisClient.Del(ctx, cartKey(userId)).Err(); err != nil {
+		return fmt.Errorf("failed to clear cart for user %s: %w", userId, err)
+	}
+	return nil
+}
+
+func NewCartRepository(redisClient *redis.Client) CartRepo {
+	return &cartRepo{redisClient: redisClient}
+}
@@ -6,90 +6,506 @@ import (
 	"fmt"
 	"time"
 
-	"e-commerce.com/internal/db"
 	"e-commerce.com/internal/models"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type CommentRepo interface {
-	CreateComment(ctx context.Context, order *models.ProductReview) error
+	// UpsertReview stores the user's review of the product, replacing the user's earlier review of it.
+	// It reports whether the review is new
+	UpsertReview(ctx context.Context, review *models.ProductReview) (bool, error)
+	// ListReviews returns up to limit reviews of the product in the order of query.Sort, starting
+	// after the cursor position when after is set. Only query.Sort, Rating and VerifiedOnly are used
+	ListReviews(ctx context.Context, productId string, query models.ReviewQuery, after *models.ReviewCursor, limit int) ([]models.ProductReview, error)
+	// GetReview returns nil when there is no review with the id
+	GetReview(ctx context.Context, reviewId primitive.ObjectID) (*models.ProductReview, error)
+	// AddReply appends reply to the review, false when there is no such review
+	AddReply(ctx context.Context, reviewId primitive.ObjectID, reply *models.ProductReviewReply) (bool, error)
+	// UpdateReply changes the message of userId's reply, false when the review has no such reply
+	UpdateReply(ctx context.Context, reviewId, replyId primitive.ObjectID, userId, message string, updatedAt time.Time) (bool, error)
+	// DeleteReply removes userId's reply, false when the review has no such reply
+	DeleteReply(ctx context.Context, reviewId, replyId primitive.ObjectID, userId string) (bool, error)
+	// UpdateReview changes the rating and comment of userId's review, nil when the user has no such review
+	UpdateReview(ctx context.Context, reviewId primitive.ObjectID, userId string, rating int, comment string, updatedAt time.Time) (*models.ProductReview, error)
+	// DeleteReview removes userId's review, false when the user has no such review
+	DeleteReview(ctx context.Context, reviewId primitive.ObjectID, userId string) (bool, error)
+	// RemoveReview removes a review of any user, false when there is no such review
+	RemoveReview(ctx context.Context, reviewId primitive.ObjectID) (bool, error)
+	// SetVote and SetReaction store userId's vote or reaction on the review, empty takes it back.
+	// The counts of the review follow, they return the user's previous vote or reaction
+	SetVote(ctx context.Context, reviewId primitive.ObjectID, userId string, vote models.ReviewVote) (models.ReviewVote, error)
+	SetReaction(ctx context.Context, reviewId primitive.ObjectID, userId string, reaction models.ReviewReaction) (models.ReviewReaction, error)
+	// CreateReport returns ErrReviewReported when the user reported the review before
+	CreateReport(ctx context.Context, report *models.ReviewReport) error
+	GetReports(ctx context.Context, status string, skip, limit int) ([]*models.ReviewReport, int64, error)
+	// ResolveReport moves an open report to status, nil when there is no such open report
+	ResolveReport(ctx context.Context, reportId primitive.ObjectID, status models.ReviewReportStatus, resolvedBy string, resolvedAt time.Time) (*models.ReviewReport, error)
+	// ResolveReviewReports moves every open report of the review to status
+	ResolveReviewReports(ctx context.Context, reviewId primitive.ObjectID, status models.ReviewReportStatus, resolvedBy string, resolvedAt time.Time) error
 }
 
+// ErrReviewReported is returned when a user reports the same review twice
+var ErrReviewReported = errors.New("you already reported this review")
+
 type commentRepo struct {
-	pool        *pgxpool.Pool
-	mongoClient *mongo.Client
-	redisClient *redis.Client
+	mongoDB *mongo.Database
 }
 
-func (r *commentRepo) CreateComment(ctx context.Context, review *models.ProductReview) error {
-	commentsCol := r.mongoClient.Database("ecommerce").Collection("comments")
-	productsCol := r.mongoClient.Database("ecommerce").Collection("products")
+func (r *commentRepo) UpsertReview(ctx context.Context, review *models.ProductReview) (bool, error) {
+	commentsCol := r.mongoDB.Collection("comments")
+
+	// 1️⃣ Insert the review, or replace the user's earlier one. The productId_userId_unique index
+	// keeps it at one review per user per product
+	filter := bson.M{"productId": review.ProductId, "userId": review.UserId}
+	if review.ID.IsZero() {
+		review.ID = primitive.NewObjectID()
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"userName":         review.UserName,
+			"rating":           review.Rating,
+			"comment":          review.Comment,
+			"verifiedPurchase": review.VerifiedPurchase,
+			"updatedAt":        review.UpdatedAt,
+		},
+		"$setOnInsert": bson.M{
+			"_id":            review.ID,
+			"helpfulCount":   0,
+			"unhelpfulCount": 0,
+			"createdAt":      review.CreatedAt,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
 
-	// 1️⃣ Insert the new review
-	if _, err := commentsCol.InsertOne(ctx, review); err != nil {
-		fmt.Println("error creating comment:", err)
-		return fmt.Errorf("failed to insert review: %w", err)
+	var previous models.ProductReview
+	err := commentsCol.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous)
+	created := errors.Is(err, mongo.ErrNoDocuments)
+	if err != nil && !created {
+		fmt.Println("error saving review:", err)
+		return false, fmt.Errorf("failed to save review: %w", err)
+	}
+	if !created {
+		review.ID = previous.ID
+		review.CreatedAt = previous.CreatedAt
+		review.Replies = previous.Replies
+	}
+
+	// 2️⃣ Update the rating aggregates, a replaced review swaps its rating instead of adding one
+	removed := 0
+	if !created {
+		removed = previous.Rating
+	}
+	if err := r.applyRatingChange(ctx, review.ProductId, review.Rating, removed); err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+func (r *commentRepo) UpdateReview(ctx context.Context, reviewId primitive.ObjectID, userId string, rating int, comment string, updatedAt time.Time) (*models.ProductReview, error) {
+	collection := r.mongoDB.Collection("comments")
+	update := bson.M{"$set": bson.M{
+		"rating":    rating,
+		"comment":   comment,
+		"updatedAt": updatedAt,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	var previous models.ProductReview
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": reviewId, "userId": userId}, update, opts).Decode(&previous)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update review: %w", err)
 	}
 
-	// 2️⃣ Find the product
-	filter := bson.M{"_id": review.ProductId}
+	if err := r.applyRatingChange(ctx, previous.ProductId, rating, previous.Rating); err != nil {
+		return nil, err
+	}
+
+	review := previous
+	review.Rating = rating
+	review.Comment = comment
+	review.UpdatedAt = updatedAt
+	return &review, nil
+}
+
+func (r *commentRepo) DeleteReview(ctx context.Context, reviewId primitive.ObjectID, userId string) (bool, error) {
+	return r.deleteReview(ctx, bson.M{"_id": reviewId, "userId": userId})
+}
+
+func (r *commentRepo) RemoveReview(ctx context.Context, reviewId primitive.ObjectID) (bool, error) {
+	return r.deleteReview(ctx, bson.M{"_id": reviewId})
+}
+
+// deleteReview deletes the review matching filter with its votes and reactions, and takes its
+// rating off the product
+func (r *commentRepo) deleteReview(ctx context.Context, filter bson.M) (bool, error) {
+	collection := r.mongoDB.Collection("comments")
+
+	var deleted models.ProductReview
+	err := collection.FindOneAndDelete(ctx, filter).Decode(&deleted)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete review: %w", err)
+	}
+
+	if err := r.applyRatingChange(ctx, deleted.ProductId, 0, deleted.Rating); err != nil {
+		return false, err
+	}
+	if _, err := r.mongoDB.Collection("review_reactions").DeleteMany(ctx, bson.M{"reviewId": deleted.ID}); err != nil {
+		fmt.Printf("WARNING: failed to delete reactions of review %s: %v\n", deleted.ID.Hex(), err)
+	}
+	return true, nil
+}
+
+// voteCountFields are the review counts of the votes
+var voteCountFields = map[models.ReviewVote]string{
+	models.ReviewVoteHelpful:   "helpfulCount",
+	models.ReviewVoteUnhelpful: "unhelpfulCount",
+}
 
-	var product models.Product
-	if err := productsCol.FindOne(ctx, filter).Decode(&product); err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return fmt.Errorf("product not found for ID %s", review.ProductId)
+func (r *commentRepo) SetVote(ctx context.Context, reviewId primitive.ObjectID, userId string, vote models.ReviewVote) (models.ReviewVote, error) {
+	previous, err := r.setReactionField(ctx, reviewId, userId, "vote", string(vote))
+	if err != nil {
+		return "", err
+	}
+
+	counts := map[string]int{}
+	if previous.Vote != "" {
+		counts[voteCountFields[previous.Vote]]--
+	}
+	if vote != "" {
+		counts[voteCountFields[vote]]++
+	}
+	if err := r.incReviewCounts(ctx, reviewId, counts); err != nil {
+		return "", err
+	}
+	return previous.Vote, nil
+}
+
+func (r *commentRepo) SetReaction(ctx context.Context, reviewId primitive.ObjectID, userId string, reaction models.ReviewReaction) (models.ReviewReaction, error) {
+	previous, err := r.setReactionField(ctx, reviewId, userId, "reaction", string(reaction))
+	if err != nil {
+		return "", err
+	}
+
+	counts := map[string]int{}
+	if previous.Reaction != "" {
+		counts["reactionCounts."+string(previous.Reaction)]--
+	}
+	if reaction != "" {
+		counts["reactionCounts."+string(reaction)]++
+	}
+	if err := r.incReviewCounts(ctx, reviewId, counts); err != nil {
+		return "", err
+	}
+	return previous.Reaction, nil
+}
+
+// setReactionField sets or, when value is empty, unsets a field of userId's reaction document and
+// returns the document as it was. The reviewId_userId_unique index keeps one document per user per review,
+// and as the update returns the previous value atomically the counts always move by the real change
+func (r *commentRepo) setReactionField(ctx context.Context, reviewId primitive.ObjectID, userId, field, value string) (*models.ProductReviewReaction, error) {
+	collection := r.mongoDB.Collection("review_reactions")
+	now := time.Now()
+
+	filter := bson.M{"reviewId": reviewId, "userId": userId}
+	var update bson.M
+	if value == "" {
+		update = bson.M{"$unset": bson.M{field: ""}, "$set": bson.M{"updatedAt": now}}
+	} else {
+		update = bson.M{
+			"$set":         bson.M{field: value, "updatedAt": now},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "createdAt": now},
 		}
-		return fmt.Errorf("failed to find product: %w", err)
 	}
+	opts := options.FindOneAndUpdate().SetUpsert(value != "").SetReturnDocument(options.Before)
+
+	var previous models.ProductReviewReaction
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to save %s: %w", field, err)
+	}
+	return &previous, nil
+}
 
-	// 3️⃣ Compute new average rating
-	newCount := product.RatingCount + 1
-	newAvg := ((product.Rating * (product.RatingCount)) + (review.Rating)) / (newCount)
+func (r *commentRepo) incReviewCounts(ctx context.Context, reviewId primitive.ObjectID, counts map[string]int) error {
+	inc := bson.M{}
+	for field, change := range counts {
+		if change != 0 {
+			inc[field] = change
+		}
+	}
+	if len(inc) == 0 {
+		return nil
+	}
 
-	// Ensure it's clamped to range (e.g. 1–6)
-	if newAvg < 1 {
-		newAvg = 1
-	} else if newAvg > 5 {
-		newAvg = 5
+	collection := r.mongoDB.Collection("comments")
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": reviewId}, bson.M{"$inc": inc}); err != nil {
+		return fmt.Errorf("failed to update review counts: %w", err)
 	}
+	return nil
+}
 
-	// 4️⃣ Update rating atomically
-	update := bson.M{
-		"$set": bson.M{
-			"rating":    newAvg,
-			"updatedAt": time.Now(),
-		},
-		"$inc": bson.M{
-			"ratingCount": 1,
-		},
+func (r *commentRepo) CreateReport(ctx context.Context, report *models.ReviewReport) error {
+	collection := r.mongoDB.Collection("review_reports")
+	_, err := collection.InsertOne(ctx, report)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrReviewReported
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
 	}
+	return nil
+}
 
-	if _, err := productsCol.UpdateOne(ctx, filter, update); err != nil {
-		return fmt.Errorf("failed to update product rating: %w", err)
+func (r *commentRepo) GetReports(ctx context.Context, status string, skip, limit int) ([]*models.ReviewReport, int64, error) {
+	collection := r.mongoDB.Collection("review_reports")
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
 	}
 
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The oldest reports are moderated first
+	opts := options.Find().
+		SetSort(bson.M{"createdAt": 1}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	reports := make([]*models.ReviewReport, 0)
+	if err := cursor.All(ctx, &reports); err != nil {
+		return nil, 0, err
+	}
+	return reports, total, nil
+}
+
+func (r *commentRepo) ResolveReport(ctx context.Context, reportId primitive.ObjectID, status models.ReviewReportStatus, resolvedBy string, resolvedAt time.Time) (*models.ReviewReport, error) {
+	collection := r.mongoDB.Collection("review_reports")
+	filter := bson.M{"_id": reportId, "status": models.ReviewReportOpen}
+	update := bson.M{"$set": bson.M{"status": status, "resolvedBy": resolvedBy, "resolvedAt": resolvedAt}}
+
+	var report models.ReviewReport
+	err := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&report)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve report: %w", err)
+	}
+	return &report, nil
+}
+
+func (r *commentRepo) ResolveReviewReports(ctx context.Context, reviewId primitive.ObjectID, status models.ReviewReportStatus, resolvedBy string, resolvedAt time.Time) error {
+	collection := r.mongoDB.Collection("review_reports")
+	filter := bson.M{"reviewId": reviewId, "status": models.ReviewReportOpen}
+	update := bson.M{"$set": bson.M{"status": status, "resolvedBy": resolvedBy, "resolvedAt": resolvedAt}}
+
+	if _, err := collection.UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to resolve reports: %w", err)
+	}
 	return nil
 }
 
-func NewCommentRepositry() CommentRepo {
-	mongoClient, err := db.GetMongoClient()
+// applyRatingChange adds the star rating added and takes away the star rating removed from the
+// rating aggregates of the product, 0 stands for no rating. The sum, count and histogram change
+// and the average is derived from them in one pipeline update, so concurrent reviews never
+// overwrite each other's changes. Products rated before the sum was stored get their aggregates
+// from the backfill_product_ratings backfill of internal/db
+func (r *commentRepo) applyRatingChange(ctx context.Context, productId string, added, removed int) error {
+	productsCol := r.mongoDB.Collection("products")
+
+	countChange := 0
+	histogram := make(map[int]int)
+	if added > 0 {
+		countChange++
+		histogram[added]++
+	}
+	if removed > 0 {
+		countChange--
+		histogram[removed]--
+	}
+
+	aggregates := bson.M{
+		"ratingSum":   bson.M{"$add": bson.A{"$ratingSum", added - removed}},
+		"ratingCount": bson.M{"$add": bson.A{"$ratingCount", countChange}},
+		"updatedAt":   time.Now(),
+	}
+	for stars, change := range histogram {
+		if change == 0 {
+			continue
+		}
+		field := fmt.Sprintf("ratingHistogram.%d", stars)
+		aggregates[field] = bson.M{"$add": bson.A{"$" + field, change}}
+	}
+
+	average := bson.M{
+		"rating": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$ratingCount", 0}},
+			bson.M{"$round": bson.A{bson.M{"$divide": bson.A{"$ratingSum", "$ratingCount"}}, 2}},
+			0,
+		}},
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$set", Value: aggregates}}, {{Key: "$set", Value: average}}}
+	result, err := productsCol.UpdateOne(ctx, bson.M{"_id": productId}, pipeline)
 	if err != nil {
-		return nil
+		return fmt.Errorf("failed to update product rating: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("product not found for ID %s", productId)
+	}
+	return nil
+}
+
+// reviewSortKey is one field of a review sort with its direction, 1 or -1
+type reviewSortKey struct {
+	field     string
+	direction int
+	value     func(cursor *models.ReviewCursor) interface{}
+}
+
+var (
+	sortByCreatedAt = reviewSortKey{"createdAt", -1, func(c *models.ReviewCursor) interface{} { return c.CreatedAt }}
+	sortByID        = reviewSortKey{"_id", -1, func(c *models.ReviewCursor) interface{} { return c.ID }}
+)
+
+// reviewSortKeys lists the keys of a sort, the newest review and then the id break ties
+func reviewSortKeys(sort models.ReviewSort) []reviewSortKey {
+	rating := func(c *models.ReviewCursor) interface{} { return c.Rating }
+	switch sort {
+	case models.ReviewSortHighest:
+		return []reviewSortKey{{"rating", -1, rating}, sortByCreatedAt, sortByID}
+	case models.ReviewSortLowest:
+		return []reviewSortKey{{"rating", 1, rating}, sortByCreatedAt, sortByID}
+	case models.ReviewSortHelpful:
+		helpful := func(c *models.ReviewCursor) interface{} { return c.HelpfulCount }
+		return []reviewSortKey{{"helpfulCount", -1, helpful}, sortByCreatedAt, sortByID}
+	default:
+		return []reviewSortKey{sortByCreatedAt, sortByID}
+	}
+}
+
+func (r *commentRepo) ListReviews(ctx context.Context, productId string, query models.ReviewQuery, after *models.ReviewCursor, limit int) ([]models.ProductReview, error) {
+	collection := r.mongoDB.Collection("comments")
+	keys := reviewSortKeys(query.Sort)
+
+	filter := bson.M{"productId": productId}
+	if query.Rating > 0 {
+		filter["rating"] = query.Rating
+	}
+	if query.VerifiedOnly {
+		filter["verifiedPurchase"] = true
+	}
+	// Every review has a helpfulCount, older ones got it from the backfill_review_counts backfill,
+	// so the keyset below and the productId_helpfulCount_createdAt index see all of them
+	if after != nil {
+		// Keyset pagination: a review comes after the cursor when it is past it on the first key
+		// that differs, e.g. {rating < r} or {rating = r, createdAt < c} or {rating = r, createdAt = c, _id < id}
+		var positions []bson.M
+		for i, key := range keys {
+			condition := bson.M{}
+			for _, equal := range keys[:i] {
+				condition[equal.field] = equal.value(after)
+			}
+			operator := "$lt"
+			if key.direction > 0 {
+				operator = "$gt"
+			}
+			condition[key.field] = bson.M{operator: key.value(after)}
+			positions = append(positions, condition)
+		}
+		filter["$or"] = positions
+	}
+
+	sort := bson.D{}
+	for _, key := range keys {
+		sort = append(sort, bson.E{Key: key.field, Value: key.direction})
 	}
-	redisClient, err := db.GetRedisClient()
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(sort).SetLimit(int64(limit)))
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	reviews := []models.ProductReview{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		return nil, fmt.Errorf("failed to decode reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+func (r *commentRepo) GetReview(ctx context.Context, reviewId primitive.ObjectID) (*models.ProductReview, error) {
+	collection := r.mongoDB.Collection("comments")
+	var review models.ProductReview
+	err := collection.FindOne(ctx, bson.M{"_id": reviewId}).Decode(&review)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
 	}
-	pool, err := db.GetPostgresPool()
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("failed to get review: %w", err)
+	}
+	return &review, nil
+}
+
+func (r *commentRepo) AddReply(ctx context.Context, reviewId primitive.ObjectID, reply *models.ProductReviewReply) (bool, error) {
+	collection := r.mongoDB.Collection("comments")
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": reviewId}, bson.M{"$push": bson.M{"replies": reply}})
+	if err != nil {
+		return false, fmt.Errorf("failed to add reply: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *commentRepo) UpdateReply(ctx context.Context, reviewId, replyId primitive.ObjectID, userId, message string, updatedAt time.Time) (bool, error) {
+	collection := r.mongoDB.Collection("comments")
+	filter := bson.M{
+		"_id":     reviewId,
+		"replies": bson.M{"$elemMatch": bson.M{"replyId": replyId, "userId": userId}},
 	}
-	return &commentRepo{
-		pool:        pool,
-		mongoClient: mongoClient,
-		redisClient: redisClient,
+	update := bson.M{"$set": bson.M{
+		"replies.$.message":   message,
+		"replies.$.updatedAt": updatedAt,
+	}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to update reply: %w", err)
 	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *commentRepo) DeleteReply(ctx context.Context, reviewId, replyId primitive.ObjectID, userId string) (bool, error) {
+	collection := r.mongoDB.Collection("comments")
+	reply := bson.M{"replyId": replyId, "userId": userId}
+	filter := bson.M{"_id": reviewId, "replies": bson.M{"$elemMatch": reply}}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"replies": reply}})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete reply: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func NewCommentRepository(mongoDB *mongo.Database) CommentRepo {
+	return &commentRepo{mongoDB: mongoDB}
 }
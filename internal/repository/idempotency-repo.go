@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// idempotency keys are remembered for a day, long enough for any client retry
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyInProgressTTL bounds how long a key stays claimed by a request that never finished,
+// a crashed request must not block retries with the key for a whole day
+const idempotencyInProgressTTL = 2 * time.Minute
+
+// IdempotencyRepo remembers the result of requests made with an Idempotency-Key
+type IdempotencyRepo interface {
+	// Reserve claims the key for a new request identified by requestHash. When the key was used before,
+	// reserved is false and result holds what the first request stored.
+	// A key reused for a different request returns ErrIdempotencyKeyReused
+	Reserve(ctx context.Context, scope, userId, key, requestHash string) (result string, reserved bool, err error)
+	Save(ctx context.Context, scope, userId, key, requestHash, result string) error
+	Release(ctx context.Context, scope, userId, key string) error
+}
+
+// ErrIdempotencyInProgress is returned when a request with the same key is still running
+var ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still in progress")
+
+// ErrIdempotencyKeyReused is returned when a key is sent again with a different request
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+// idempotencyRecord is what is stored under a key, Result is empty while the first request runs
+type idempotencyRecord struct {
+	RequestHash string `json:"requestHash"`
+	InProgress  bool   `json:"inProgress,omitempty"`
+	Result      string `json:"result,omitempty"`
+}
+
+type idempotencyRepo struct {
+	redisClient *redis.Client
+}
+
+// keys are scoped per user so one user can not replay another user's result
+func idempotencyKey(scope, userId, key string) string {
+	return fmt.Sprintf("idempotency:%s:%s:%s", scope, userId, key)
+}
+
+func (r *idempotencyRepo) Reserve(ctx context.Context, scope, userId, key, requestHash string) (string, bool, error) {
+	redisKey := idempotencyKey(scope, userId, key)
+	marker, err := json.Marshal(idempotencyRecord{RequestHash: requestHash, InProgress: true})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to encode idempotency key: %w", err)
+	}
+	reserved, err := r.redisClient.SetNX(ctx, redisKey, marker, idempotencyInProgressTTL).Result()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if reserved {
+		return "", true, nil
+	}
+
+	stored, err := r.redisClient.Get(ctx, redisKey).Result()
+	if err == redis.Nil {
+		// The key expired between the two calls, try once more
+		return r.Reserve(ctx, scope, userId, key, requestHash)
+	} else if err != nil {
+		return "", false, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal([]byte(stored), &record); err != nil {
+		return "", false, fmt.Errorf("failed to decode idempotency key: %w", err)
+	}
+	if record.RequestHash != requestHash {
+		return "", false, ErrIdempotencyKeyReused
+	}
+	if record.InProgress {
+		return "", false, ErrIdempotencyInProgress
+	}
+	return record.Result, false, nil
+}
+
+// Save stores the result of a finished request and keeps it for the full idempotency ttl
+func (r *idempotencyRepo) Save(ctx context.Context, scope, userId, key, requestHash, result string) error {
+	record, err := json.Marshal(idempotencyRecord{RequestHash: requestHash, Result: result})
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency result: %w", err)
+	}
+	if err := r.redisClient.Set(ctx, idempotencyKey(scope, userId, key), record, idempotencyTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save idempotency result: %w", err)
+	}
+	return nil
+}
+
+// Release forgets a reserved key so a failed request can be retried with it
+func (r *idempotencyRepo) Release(ctx context.Context, scope, userId, key string) error {
+	if err := r.redisClient.Del(ctx, idempotencyKey(scope, userId, key)).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func NewIdempotencyRepository(redisClient *redis.Client) IdempotencyRepo {
+	return &idempotencyRepo{redisClient: redisClient}
+}
@@ -2,13 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
-	"e-commerce.com/internal/db"
 	"e-commerce.com/internal/models"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,25 +15,50 @@ import (
 type OrderRepo interface {
 	CreateOrder(ctx context.Context, order *models.Order) error
 	GetOrderByTransactionID(ctx context.Context, transactionID string) (*models.Order, error)
+	GetOrderByID(ctx context.Context, orderId string) (*models.Order, error)
 	UpdateOrderStatus(ctx context.Context, orderID string, status string) error
+	// SetSellerOrders stores the sub-orders of an order that was created without them
+	SetSellerOrders(ctx context.Context, orderID string, sellerOrders []models.SellerOrder) error
+	// TransitionSellerOrderStatus moves change.SellerID's sub-order that is still in change.From to
+	// change.To and appends change to its history. A non nil shipment replaces the stored one.
+	// It reports false when the sub-order has another status
+	TransitionSellerOrderStatus(ctx context.Context, orderID string, change models.OrderStatusChange, shipment *models.Shipment) (bool, error)
+	// SetDerivedOrderStatus moves an order to change.To as long as its sub-orders still have
+	// sellerStatuses, the statuses change.To was derived from
+	SetDerivedOrderStatus(ctx context.Context, orderID string, sellerStatuses []models.OrderStatus, change models.OrderStatusChange) (bool, error)
 	GetUserOrders(ctx context.Context, userId string) ([]models.OrderWithProductDetails, int64, error)
 	GetUserOrderDetails(ctx context.Context, userId, orderId string) (*models.Order, error)
 	GetSellerOrders(ctx context.Context, sellerId string, skip, limit int, status string) ([]*models.Order, int64, error)
 	GetSellerOrderDetails(ctx context.Context, sellerId, orderId string) (*models.Order, error)
 	GetSellerOrdersWithDetails(ctx context.Context, sellerId string) ([]models.OrderWithProductDetails, int64, error)
 	AcceptOrder(ctx context.Context, orderId string) error
-	DeleteOrder(ctx context.Context, orderId string) error
+	// DeleteOrder removes an order whose sub-orders are all closed and that was paid back in full.
+	// It reports false when the order is not in that state
+	DeleteOrder(ctx context.Context, orderId string) (bool, error)
+	// AddRefundedAmount adds amount to the order and sellerAmounts to the sub-orders of those sellers
+	AddRefundedAmount(ctx context.Context, orderId string, amount int64, sellerAmounts map[string]int64) error
+	// ClaimRefundQuantities adds the quantities to the refunded quantity of the order lines in one update,
+	// only when every line has that many units left. It reports false when one of them has not
+	ClaimRefundQuantities(ctx context.Context, orderId string, quantities map[string]int64) (bool, error)
+	// ReleaseRefundQuantities gives back quantities claimed for a refund that failed
+	ReleaseRefundQuantities(ctx context.Context, orderId string, quantities map[string]int64) error
+	// HasDeliveredProduct reports whether the user has an order in which the product was delivered
+	HasDeliveredProduct(ctx context.Context, userId, productId string) (bool, error)
 }
 
+// ErrOrderExists is returned when an order for the same transaction was already created
+var ErrOrderExists = errors.New("an order for this transaction already exists")
+
 type orderRepo struct {
-	pool        *pgxpool.Pool
-	mongoClient *mongo.Client
-	redisClient *redis.Client
+	mongoDB *mongo.Database
 }
 
 func (r *orderRepo) CreateOrder(ctx context.Context, order *models.Order) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 	_, err := collection.InsertOne(ctx, order)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrOrderExists
+	}
 	if err != nil {
 		return err
 	}
@@ -43,7 +66,7 @@ func (r *orderRepo) CreateOrder(ctx context.Context, order *models.Order) error
 }
 
 func (r *orderRepo) GetOrderByTransactionID(ctx context.Context, transactionID string) (*models.Order, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 	var order models.Order
 	err := collection.FindOne(ctx, bson.M{"transactionId": transactionID}).Decode(&order)
 	if err != nil {
@@ -52,8 +75,18 @@ func (r *orderRepo) GetOrderByTransactionID(ctx context.Context, transactionID s
 	return &order, nil
 }
 
+func (r *orderRepo) GetOrderByID(ctx context.Context, orderId string) (*models.Order, error) {
+	collection := r.mongoDB.Collection("orders")
+	var order models.Order
+	err := collection.FindOne(ctx, bson.M{"_id": orderId}).Decode(&order)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
 func (r *orderRepo) UpdateOrderStatus(ctx context.Context, orderID string, status string) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 	_, err := collection.UpdateOne(
 		ctx,
 		bson.M{"_id": orderID},
@@ -62,8 +95,74 @@ func (r *orderRepo) UpdateOrderStatus(ctx context.Context, orderID string, statu
 	return err
 }
 
+func (r *orderRepo) SetSellerOrders(ctx context.Context, orderID string, sellerOrders []models.SellerOrder) error {
+	collection := r.mongoDB.Collection("orders")
+	// Only the first request to split the order stores its sub-orders
+	_, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": orderID, "sellerOrders": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"sellerOrders": sellerOrders}},
+	)
+	return err
+}
+
+func (r *orderRepo) TransitionSellerOrderStatus(ctx context.Context, orderID string, change models.OrderStatusChange, shipment *models.Shipment) (bool, error) {
+	collection := r.mongoDB.Collection("orders")
+
+	set := bson.M{
+		"sellerOrders.$.status":    change.To,
+		"sellerOrders.$.updatedAt": change.At,
+		"updatedAt":                change.At,
+	}
+	if shipment != nil {
+		set["sellerOrders.$.shipment"] = shipment
+	}
+
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":          orderID,
+			"sellerOrders": bson.M{"$elemMatch": bson.M{"sellerId": change.SellerID, "status": change.From}},
+		},
+		bson.M{
+			"$set":  set,
+			"$push": bson.M{"sellerOrders.$.statusHistory": change},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *orderRepo) SetDerivedOrderStatus(ctx context.Context, orderID string, sellerStatuses []models.OrderStatus, change models.OrderStatusChange) (bool, error) {
+	collection := r.mongoDB.Collection("orders")
+
+	filter := bson.M{
+		"_id":          orderID,
+		"status":       change.From,
+		"sellerOrders": bson.M{"$size": len(sellerStatuses)},
+	}
+	for i, status := range sellerStatuses {
+		filter[fmt.Sprintf("sellerOrders.%d.status", i)] = status
+	}
+
+	result, err := collection.UpdateOne(
+		ctx,
+		filter,
+		bson.M{
+			"$set":  bson.M{"status": change.To, "updatedAt": change.At},
+			"$push": bson.M{"statusHistory": change},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
 func (r *orderRepo) GetUserOrders(ctx context.Context, userId string) ([]models.OrderWithProductDetails, int64, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 
 	// Build filter
 	filter := bson.M{"userId": userId}
@@ -97,6 +196,11 @@ func (r *orderRepo) GetUserOrders(ctx context.Context, userId string) ([]models.
 		orderWithProductDetails.Amount = order.Amount
 		orderWithProductDetails.TransactionID = order.TransactionID
 		orderWithProductDetails.Status = order.Status
+		orderWithProductDetails.RefundedAmount = order.RefundedAmount
+		orderWithProductDetails.StatusHistory = order.StatusHistory
+		orderWithProductDetails.ShippingAddress = order.ShippingAddress
+		orderWithProductDetails.BillingAddress = order.BillingAddress
+		orderWithProductDetails.SellerOrders = order.SellerOrders
 		orderWithProductDetails.CreatedAt = order.CreatedAt
 		orderWithProductDetails.UpdatedAt = order.UpdatedAt
 
@@ -107,30 +211,40 @@ func (r *orderRepo) GetUserOrders(ctx context.Context, userId string) ([]models.
 }
 
 func (r *orderRepo) getProductDetailsWithQuantity(ctx context.Context, productData []models.ProductItem) ([]models.ProductWithQuantity, error) {
-	product_collection := r.mongoClient.Database("ecommerce").Collection("products")
+	product_collection := r.mongoDB.Collection("products")
 	var productsWithQuantity []models.ProductWithQuantity
 
 	for _, productItem := range productData {
 		var product models.Product
 		err := product_collection.FindOne(ctx, bson.M{"_id": productItem.ProductID}).Decode(&product)
-		if err != nil {
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, err
 		}
 
+		// Price, discount and quantity come from the snapshot taken at checkout,
+		// the product itself may have changed or been deleted since
 		productWithQuantity := models.ProductWithQuantity{
-			ID:             product.ID,
-			Name:           product.Name,
+			ID:             productItem.ProductID,
+			Name:           productItem.Name,
 			Description:    product.Description,
-			Price:          product.Price,
+			Price:          int(productItem.Price),
 			Quantity:       product.Quantity,
-			Discount:       product.Discount,
-			SellerID:       product.SellerID,
+			Discount:       productItem.DiscountPercent,
+			SellerID:       productItem.SellerID,
 			Category:       product.Category,
 			Images:         product.Images,
 			Stock:          product.Stock,
 			CreatedAt:      product.CreatedAt,
 			UpdatedAt:      product.UpdatedAt,
 			BoughtQuantity: productItem.Quantity,
+			LineDiscount:   productItem.Discount,
+			LineTotal:      productItem.Total,
+		}
+		if productWithQuantity.Name == "" {
+			productWithQuantity.Name = product.Name
+		}
+		if productWithQuantity.LineTotal == 0 {
+			productWithQuantity.LineTotal = productItem.Price*productItem.Quantity - productItem.Discount
 		}
 		productsWithQuantity = append(productsWithQuantity, productWithQuantity)
 	}
@@ -138,7 +252,7 @@ func (r *orderRepo) getProductDetailsWithQuantity(ctx context.Context, productDa
 }
 
 func (r *orderRepo) GetUserOrderDetails(ctx context.Context, userId, orderId string) (*models.Order, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 	var order models.Order
 	err := collection.FindOne(ctx, bson.M{"_id": orderId, "userId": userId}).Decode(&order)
 	if err != nil {
@@ -148,14 +262,18 @@ func (r *orderRepo) GetUserOrderDetails(ctx context.Context, userId, orderId str
 }
 
 func (r *orderRepo) GetSellerOrders(ctx context.Context, sellerId string, skip, limit int, status string) ([]*models.Order, int64, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 
 	// Build filter: find orders that contain products with the given sellerId
 	filter := bson.M{
 		"products.sellerId": sellerId, // Match nested product sellerId
 	}
+	// The status a seller filters on is the one of its own sub-order
 	if status != "" {
-		filter["status"] = status
+		filter["$or"] = []bson.M{
+			{"sellerOrders": bson.M{"$elemMatch": bson.M{"sellerId": sellerId, "status": status}}},
+			{"sellerOrders": bson.M{"$exists": false}, "status": status},
+		}
 	}
 
 	// Count total orders before pagination
@@ -185,21 +303,26 @@ func (r *orderRepo) GetSellerOrders(ctx context.Context, sellerId string, skip,
 		return nil, 0, err
 	}
 
+	// Sellers only see their own part of every order
+	for i, order := range sellerOrders {
+		sellerOrders[i] = order.ForSeller(sellerId)
+	}
+
 	return sellerOrders, total, nil
 }
 
 func (r *orderRepo) GetSellerOrderDetails(ctx context.Context, sellerId, orderId string) (*models.Order, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 	var order models.Order
-	err := collection.FindOne(ctx, bson.M{"_id": orderId}).Decode(&order)
+	err := collection.FindOne(ctx, bson.M{"_id": orderId, "products.sellerId": sellerId}).Decode(&order)
 	if err != nil {
 		return nil, err
 	}
-	return &order, nil
+	return order.ForSeller(sellerId), nil
 }
 
 func (r *orderRepo) GetSellerOrdersWithDetails(ctx context.Context, sellerId string) ([]models.OrderWithProductDetails, int64, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 
 	// Build filter for seller orders (orders containing seller's products)
 	filter := bson.M{"products.sellerId": sellerId}
@@ -223,12 +346,18 @@ func (r *orderRepo) GetSellerOrdersWithDetails(ctx context.Context, sellerId str
 	// Filter orders that contain seller's products
 	var sellerOrdersWithDetails []models.OrderWithProductDetails
 	for _, order := range allOrders {
+		order = order.ForSeller(sellerId)
+
 		var orderWithDetails models.OrderWithProductDetails
 		orderWithDetails.ID = order.ID
 		orderWithDetails.User = order.User
 		orderWithDetails.Amount = order.Amount
 		orderWithDetails.TransactionID = order.TransactionID
 		orderWithDetails.Status = order.Status
+		orderWithDetails.RefundedAmount = order.RefundedAmount
+		orderWithDetails.StatusHistory = order.StatusHistory
+		orderWithDetails.ShippingAddress = order.ShippingAddress
+		orderWithDetails.SellerOrders = order.SellerOrders
 		orderWithDetails.CreatedAt = order.CreatedAt
 		orderWithDetails.UpdatedAt = order.UpdatedAt
 
@@ -245,7 +374,7 @@ func (r *orderRepo) GetSellerOrdersWithDetails(ctx context.Context, sellerId str
 }
 
 func (r *orderRepo) AcceptOrder(ctx context.Context, orderId string) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
+	collection := r.mongoDB.Collection("orders")
 	_, err := collection.UpdateOne(
 		ctx,
 		bson.M{"_id": orderId},
@@ -254,28 +383,134 @@ func (r *orderRepo) AcceptOrder(ctx context.Context, orderId string) error {
 	return err
 }
 
-func (r *orderRepo) DeleteOrder(ctx context.Context, orderId string) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("orders")
-	_, err := collection.DeleteOne(ctx, bson.M{"_id": orderId})
+func (r *orderRepo) DeleteOrder(ctx context.Context, orderId string) (bool, error) {
+	collection := r.mongoDB.Collection("orders")
+	openStatuses := []models.OrderStatus{
+		models.OrderStatusCreated,
+		models.OrderStatusPaidAndProcessing,
+		models.OrderStatusShipping,
+		models.OrderStatusDelivered,
+	}
+	result, err := collection.DeleteOne(ctx, bson.M{
+		"_id":                 orderId,
+		"sellerOrders.0":      bson.M{"$exists": true},
+		"sellerOrders.status": bson.M{"$nin": openStatuses},
+		"$expr":               bson.M{"$gte": bson.A{"$refundedAmount", "$amount"}},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r *orderRepo) AddRefundedAmount(ctx context.Context, orderId string, amount int64, sellerAmounts map[string]int64) error {
+	collection := r.mongoDB.Collection("orders")
+
+	inc := bson.M{"refundedAmount": amount}
+	opts := options.Update()
+	var filters []interface{}
+	for sellerId, sellerAmount := range sellerAmounts {
+		name := fmt.Sprintf("s%d", len(filters))
+		inc[fmt.Sprintf("sellerOrders.$[%s].refundedAmount", name)] = sellerAmount
+		filters = append(filters, bson.M{name + ".sellerId": sellerId})
+	}
+	if len(filters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: filters})
+	}
+
+	_, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": orderId},
+		bson.M{
+			"$inc": inc,
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		opts,
+	)
 	return err
 }
 
-func NewOrderRepository() OrderRepo {
-	mongoClient, err := db.GetMongoClient()
-	if err != nil {
-		return nil
+func (r *orderRepo) ClaimRefundQuantities(ctx context.Context, orderId string, quantities map[string]int64) (bool, error) {
+	collection := r.mongoDB.Collection("orders")
+
+	// Every line needs quantity - refundedQuantity >= the claimed units, checked in the same update that claims them
+	var left []interface{}
+	for productId, quantity := range quantities {
+		unitsLeft := bson.M{"$sum": bson.M{"$map": bson.M{
+			"input": bson.M{"$filter": bson.M{
+				"input": "$products",
+				"as":    "line",
+				"cond":  bson.M{"$eq": bson.A{"$$line.productId", productId}},
+			}},
+			"as": "line",
+			"in": bson.M{"$subtract": bson.A{"$$line.quantity", bson.M{"$ifNull": bson.A{"$$line.refundedQuantity", 0}}}},
+		}}}
+		left = append(left, bson.M{"$gte": bson.A{unitsLeft, quantity}})
 	}
-	redisClient, err := db.GetRedisClient()
-	if err != nil {
-		return nil
+	if len(left) == 0 {
+		return true, nil
 	}
-	pool, err := db.GetPostgresPool()
+
+	result, err := r.incRefundQuantities(ctx, collection, bson.M{"_id": orderId, "$expr": bson.M{"$and": left}}, quantities, 1)
 	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *orderRepo) ReleaseRefundQuantities(ctx context.Context, orderId string, quantities map[string]int64) error {
+	if len(quantities) == 0 {
 		return nil
 	}
-	return &orderRepo{
-		pool:        pool,
-		mongoClient: mongoClient,
-		redisClient: redisClient,
+	_, err := r.incRefundQuantities(ctx, r.mongoDB.Collection("orders"), bson.M{"_id": orderId}, quantities, -1)
+	return err
+}
+
+// incRefundQuantities adds sign * quantity to the refunded quantity of each line of the matched order
+func (r *orderRepo) incRefundQuantities(ctx context.Context, collection *mongo.Collection, filter bson.M, quantities map[string]int64, sign int64) (*mongo.UpdateResult, error) {
+	inc := bson.M{}
+	var filters []interface{}
+	for productId, quantity := range quantities {
+		name := fmt.Sprintf("p%d", len(filters))
+		inc[fmt.Sprintf("products.$[%s].refundedQuantity", name)] = sign * quantity
+		filters = append(filters, bson.M{name + ".productId": productId})
+	}
+
+	return collection.UpdateOne(
+		ctx,
+		filter,
+		bson.M{
+			"$inc": inc,
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: filters}),
+	)
+}
+
+func (r *orderRepo) HasDeliveredProduct(ctx context.Context, userId, productId string) (bool, error) {
+	collection := r.mongoDB.Collection("orders")
+	filter := bson.M{
+		"userId": userId,
+		"$or": []bson.M{
+			{"sellerOrders": bson.M{"$elemMatch": bson.M{
+				"status":             models.OrderStatusDelivered,
+				"products.productId": productId,
+			}}},
+			// Orders created before sub-orders only have the order status
+			{"sellerOrders": bson.M{"$exists": false}, "status": models.OrderStatusDelivered, "products.productId": productId},
+		},
+	}
+
+	err := collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check delivered orders: %w", err)
 	}
+	return true, nil
+}
+
+func NewOrderRepository(mongoDB *mongo.Database) OrderRepo {
+	return &orderRepo{mongoDB: mongoDB}
 }
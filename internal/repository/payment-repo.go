@@ -5,12 +5,11 @@ import (
 	"fmt"
 	"time"
 
-	"e-commerce.com/internal/db"
 	"e-commerce.com/internal/models"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type PaymentRepo interface {
@@ -18,12 +17,23 @@ type PaymentRepo interface {
 	CheckProductAvailability(ctx context.Context, productIds []string) ([]*models.Product, bool, error)
 	GetPaymentByTransactionUUID(ctx context.Context, transactionUUID string) (*models.Payment, error)
 	UpdatePaymentStatus(ctx context.Context, paymentID string, status models.PaymentStatus) error
+	SetPaymentSessionID(ctx context.Context, paymentID, sessionID string) error
+	// MarkPaymentSuccessful and MarkPaymentFailed only move a pending payment,
+	// they report false when another request already changed its status
+	MarkPaymentSuccessful(ctx context.Context, paymentID, gatewayRef string) (bool, error)
+	MarkPaymentFailed(ctx context.Context, paymentID, reason string) (bool, error)
+	MarkPaymentExpired(ctx context.Context, paymentID, reason string) (bool, error)
+	// GetStalePendingPayments returns pending payments created before olderThan, oldest first
+	GetStalePendingPayments(ctx context.Context, olderThan time.Time, limit int) ([]*models.Payment, error)
 	ClearUserCart(ctx context.Context, userID string) error
+	// AdjustRefundedAmount adds delta to the refunded amount of a successful payment and
+	// updates its status to match. It reports false when the payment is not refundable
+	// or the refunded total would fall outside 0..amount
+	AdjustRefundedAmount(ctx context.Context, paymentID string, delta int64) (bool, error)
 }
 
 type paymentRepo struct {
-	pool        *pgxpool.Pool
-	mongoClient *mongo.Client
+	mongoDB     *mongo.Database
 	redisClient *redis.Client
 }
 
@@ -35,7 +45,7 @@ func (r *paymentRepo) CheckProductAvailability(ctx context.Context, productIds [
 
 	fmt.Printf("DEBUG: Checking availability for product IDs: %v\n", productIds)
 
-	collection := r.mongoClient.Database("ecommerce").Collection("products")
+	collection := r.mongoDB.Collection("products")
 
 	// Create the query with proper validation
 	query := bson.M{"_id": bson.M{"$in": productIds}}
@@ -57,7 +67,7 @@ func (r *paymentRepo) CheckProductAvailability(ctx context.Context, productIds [
 	return products, true, nil
 }
 func (r *paymentRepo) CreatePayment(ctx context.Context, payment *models.Payment) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("payments")
+	collection := r.mongoDB.Collection("payments")
 	_, err := collection.InsertOne(ctx, payment)
 	if err != nil {
 		return err
@@ -66,7 +76,7 @@ func (r *paymentRepo) CreatePayment(ctx context.Context, payment *models.Payment
 }
 
 func (r *paymentRepo) GetPaymentByTransactionUUID(ctx context.Context, transactionUUID string) (*models.Payment, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("payments")
+	collection := r.mongoDB.Collection("payments")
 	var payment models.Payment
 
 	// Use the correct field name from the model: TransactionUuid
@@ -80,7 +90,7 @@ func (r *paymentRepo) GetPaymentByTransactionUUID(ctx context.Context, transacti
 }
 
 func (r *paymentRepo) UpdatePaymentStatus(ctx context.Context, paymentID string, status models.PaymentStatus) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("payments")
+	collection := r.mongoDB.Collection("payments")
 	_, err := collection.UpdateOne(
 		ctx,
 		bson.M{"_id": paymentID},
@@ -89,6 +99,89 @@ func (r *paymentRepo) UpdatePaymentStatus(ctx context.Context, paymentID string,
 	return err
 }
 
+func (r *paymentRepo) SetPaymentSessionID(ctx context.Context, paymentID, sessionID string) error {
+	collection := r.mongoDB.Collection("payments")
+	_, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": paymentID},
+		bson.M{"$set": bson.M{"gatewaySessionId": sessionID, "updatedAt": time.Now()}},
+	)
+	return err
+}
+
+func (r *paymentRepo) MarkPaymentSuccessful(ctx context.Context, paymentID, gatewayRef string) (bool, error) {
+	collection := r.mongoDB.Collection("payments")
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": paymentID, "status": models.PaymentStatusPending},
+		bson.M{"$set": bson.M{
+			"status":     models.PaymentStatusSuccess,
+			"gatewayRef": gatewayRef,
+			"updatedAt":  time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *paymentRepo) MarkPaymentFailed(ctx context.Context, paymentID, reason string) (bool, error) {
+	collection := r.mongoDB.Collection("payments")
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": paymentID, "status": models.PaymentStatusPending},
+		bson.M{"$set": bson.M{
+			"status":        models.PaymentStatusFailed,
+			"failureReason": reason,
+			"updatedAt":     time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *paymentRepo) MarkPaymentExpired(ctx context.Context, paymentID, reason string) (bool, error) {
+	collection := r.mongoDB.Collection("payments")
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": paymentID, "status": models.PaymentStatusPending},
+		bson.M{"$set": bson.M{
+			"status":        models.PaymentStatusExpired,
+			"failureReason": reason,
+			"updatedAt":     time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *paymentRepo) GetStalePendingPayments(ctx context.Context, olderThan time.Time, limit int) ([]*models.Payment, error) {
+	collection := r.mongoDB.Collection("payments")
+
+	filter := bson.M{
+		"status":    models.PaymentStatusPending,
+		"createdAt": bson.M{"$lt": olderThan},
+	}
+	opts := options.Find().SetSort(bson.M{"createdAt": 1}).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	payments := make([]*models.Payment, 0)
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
 func (r *paymentRepo) ClearUserCart(ctx context.Context, userID string) error {
 	// Clear the user's cart from Redis
 	cartKey := fmt.Sprintf("cart:%s", userID)
@@ -101,22 +194,41 @@ func (r *paymentRepo) ClearUserCart(ctx context.Context, userID string) error {
 	return nil
 }
 
-func NewPaymentRepository() PaymentRepo {
-	mongoClient, err := db.GetMongoClient()
-	if err != nil {
-		return nil
+func (r *paymentRepo) AdjustRefundedAmount(ctx context.Context, paymentID string, delta int64) (bool, error) {
+	collection := r.mongoDB.Collection("payments")
+	refunded := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$refundedAmount", 0}}, delta}}
+
+	// The check and the update run as one operation, concurrent refunds can not exceed the amount paid
+	filter := bson.M{
+		"_id": paymentID,
+		"status": bson.M{"$in": bson.A{
+			models.PaymentStatusSuccess,
+			models.PaymentStatusPartiallyRefunded,
+			models.PaymentStatusRefunded,
+		}},
+		"$expr": bson.M{"$and": bson.A{
+			bson.M{"$gte": bson.A{refunded, 0}},
+			bson.M{"$lte": bson.A{refunded, "$amount"}},
+		}},
 	}
-	redisClient, err := db.GetRedisClient()
-	if err != nil {
-		return nil
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"refundedAmount": refunded, "updatedAt": time.Now()}}},
+		{{Key: "$set", Value: bson.M{"status": bson.M{"$switch": bson.M{
+			"branches": bson.A{
+				bson.M{"case": bson.M{"$gte": bson.A{"$refundedAmount", "$amount"}}, "then": models.PaymentStatusRefunded},
+				bson.M{"case": bson.M{"$gt": bson.A{"$refundedAmount", 0}}, "then": models.PaymentStatusPartiallyRefunded},
+			},
+			"default": models.PaymentStatusSuccess,
+		}}}}},
 	}
-	pool, err := db.GetPostgresPool()
+
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return nil
-	}
-	return &paymentRepo{
-		pool:        pool,
-		mongoClient: mongoClient,
-		redisClient: redisClient,
+		return false, err
 	}
+	return result.MatchedCount == 1, nil
+}
+
+func NewPaymentRepository(mongoDB *mongo.Database, redisClient *redis.Client) PaymentRepo {
+	return &paymentRepo{mongoDB: mongoDB, redisClient: redisClient}
 }
@@ -5,10 +5,7 @@ import (
 	"fmt"
 	"sync"
 
-	"e-commerce.com/internal/db"
 	"e-commerce.com/internal/models"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,21 +14,25 @@ import (
 type ProductRepo interface {
 	CreateProduct(ctx context.Context, product *models.Product) (*models.Product, error)
 	GetSellerProducts(ctx context.Context, sellerId string, page int, limit int) ([]*models.Product, int64, bool, error)
-	UpdateProduct(ctx context.Context, productId string, product *models.UpdateProductRequest) error
-	DeleteProduct(ctx context.Context, productId string) error
-	GetProductByID(ctx context.Context, productId string) (*models.Product, []models.ProductReview, error)
+	UpdateProduct(ctx context.Context, sellerId, productId string, product *models.UpdateProductRequest) error
+	DeleteProduct(ctx context.Context, sellerId, productId string) error
+	GetProductByID(ctx context.Context, productId string) (*models.Product, error)
 	GetAllProducts(ctx context.Context, search *string, limit, offset int) (*models.ProductResponse, error)
 	UpdateProductStock(ctx context.Context, sellerId, productId string, stock int) error
+	// ReserveStock takes quantity off the stock in one update, only while enough is left.
+	// It reports false when the product does not have quantity in stock
+	ReserveStock(ctx context.Context, productId string, quantity int64) (bool, error)
+	// ReleaseStock puts quantity back on the stock in one update
+	ReleaseStock(ctx context.Context, productId string, quantity int64) error
+	GetProductsByIDs(ctx context.Context, productIds []string) ([]*models.Product, error)
 }
 
 type productRepo struct {
-	pool        *pgxpool.Pool
-	mongoClient *mongo.Client
-	redisClient *redis.Client
+	mongoDB *mongo.Database
 }
 
 func (r *productRepo) GetAllProducts(ctx context.Context, search *string, limit, offset int) (*models.ProductResponse, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("products")
+	collection := r.mongoDB.Collection("products")
 
 	// Build the base filter
 	filter := bson.M{}
@@ -48,19 +49,21 @@ func (r *productRepo) GetAllProducts(ctx context.Context, search *string, limit,
 
 	// Projection for query
 	projection := bson.M{
-		"_id":         1,
-		"name":        1,
-		"description": 1,
-		"price":       1,
-		"quantity":    1,
-		"discount":    1,
-		"sellerId":    1,
-		"category":    1,
-		"images":      1,
-		"stock":       1,
-		"rating":      1,
-		"createdAt":   1,
-		"updatedAt":   1,
+		"_id":             1,
+		"name":            1,
+		"description":     1,
+		"price":           1,
+		"quantity":        1,
+		"discount":        1,
+		"sellerId":        1,
+		"category":        1,
+		"images":          1,
+		"stock":           1,
+		"rating":          1,
+		"ratingCount":     1,
+		"ratingHistogram": 1,
+		"createdAt":       1,
+		"updatedAt":       1,
 	}
 
 	opts := options.Find().
@@ -124,16 +127,16 @@ func (r *productRepo) GetAllProducts(ctx context.Context, search *string, limit,
 	return &productResponse, nil
 }
 
-func (r *productRepo) UpdateProduct(ctx context.Context, productId string, product *models.UpdateProductRequest) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("products")
-	filter := bson.M{"_id": productId}
+func (r *productRepo) UpdateProduct(ctx context.Context, sellerId, productId string, product *models.UpdateProductRequest) error {
+	collection := r.mongoDB.Collection("products")
+	// The seller is part of the filter, a product can not be moved to another seller
+	filter := bson.M{"_id": productId, "sellerId": sellerId}
 	update := bson.M{"$set": bson.M{
 		"name":        product.Name,
 		"description": product.Description,
 		"price":       product.Price,
 		"quantity":    product.Quantity,
 		"discount":    product.Discount,
-		"sellerId":    product.SellerID,
 		"category":    product.Category,
 		"images":      product.Images,
 		"stock":       product.Stock,
@@ -145,9 +148,9 @@ func (r *productRepo) UpdateProduct(ctx context.Context, productId string, produ
 	return nil
 }
 
-func (r *productRepo) DeleteProduct(ctx context.Context, productId string) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("products")
-	_, err := collection.DeleteOne(ctx, bson.M{"_id": productId})
+func (r *productRepo) DeleteProduct(ctx context.Context, sellerId, productId string) error {
+	collection := r.mongoDB.Collection("products")
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": productId, "sellerId": sellerId})
 	if err != nil {
 		return err
 	}
@@ -155,7 +158,7 @@ func (r *productRepo) DeleteProduct(ctx context.Context, productId string) error
 }
 
 func (r *productRepo) CreateProduct(ctx context.Context, product *models.Product) (*models.Product, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("products")
+	collection := r.mongoDB.Collection("products")
 	_, err := collection.InsertOne(ctx, product)
 	if err != nil {
 		return nil, err
@@ -164,7 +167,7 @@ func (r *productRepo) CreateProduct(ctx context.Context, product *models.Product
 }
 
 func (r *productRepo) GetSellerProducts(ctx context.Context, sellerId string, page int, limit int) ([]*models.Product, int64, bool, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("products")
+	collection := r.mongoDB.Collection("products")
 
 	// Validate input parameters
 	if page < 1 {
@@ -218,38 +221,18 @@ func (r *productRepo) GetSellerProducts(ctx context.Context, sellerId string, pa
 	return products, total, hasMore, nil
 }
 
-func (r *productRepo) GetProductByID(ctx context.Context, productId string) (*models.Product, []models.ProductReview, error) {
-	collection := r.mongoClient.Database("ecommerce").Collection("products")
-	commentCollection := r.mongoClient.Database("ecommerce").Collection("comments")
-
-	filter := bson.M{"_id": productId}
-	commentFilter := bson.M{"productId": productId}
+func (r *productRepo) GetProductByID(ctx context.Context, productId string) (*models.Product, error) {
+	collection := r.mongoDB.Collection("products")
 
 	var product models.Product
-	var comments []models.ProductReview
-
-	// 1️⃣ Get the product
-	if err := collection.FindOne(ctx, filter).Decode(&product); err != nil {
-		return nil, nil, err
-	}
-
-	// 2️⃣ Get all comments for the product
-	cursor, err := commentCollection.Find(ctx, commentFilter)
-	if err != nil {
-		return &product, nil, err
-	}
-	defer cursor.Close(ctx)
-
-	// 3️⃣ Decode all comments (can be empty — not an error)
-	if err := cursor.All(ctx, &comments); err != nil {
-		return &product, nil, err
+	if err := collection.FindOne(ctx, bson.M{"_id": productId}).Decode(&product); err != nil {
+		return nil, err
 	}
-
-	return &product, comments, nil
+	return &product, nil
 }
 
 func (r *productRepo) UpdateProductStock(ctx context.Context, sellerId, productId string, stock int) error {
-	collection := r.mongoClient.Database("ecommerce").Collection("products")
+	collection := r.mongoDB.Collection("products")
 	filter := bson.M{"_id": productId, "sellerId": sellerId}
 	update := bson.M{"$set": bson.M{"stock": stock}}
 	_, err := collection.UpdateOne(ctx, filter, update)
@@ -259,22 +242,49 @@ func (r *productRepo) UpdateProductStock(ctx context.Context, sellerId, productI
 	return nil
 }
 
-func NewProductRepository() ProductRepo {
-	mongoClient, err := db.GetMongoClient()
+func (r *productRepo) ReserveStock(ctx context.Context, productId string, quantity int64) (bool, error) {
+	collection := r.mongoDB.Collection("products")
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": productId, "stock": bson.M{"$gte": quantity}},
+		bson.M{"$inc": bson.M{"stock": -quantity}},
+	)
 	if err != nil {
-		return nil
+		return false, err
 	}
-	redisClient, err := db.GetRedisClient()
-	if err != nil {
-		return nil
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *productRepo) ReleaseStock(ctx context.Context, productId string, quantity int64) error {
+	collection := r.mongoDB.Collection("products")
+	_, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": productId},
+		bson.M{"$inc": bson.M{"stock": quantity}},
+	)
+	return err
+}
+
+func (r *productRepo) GetProductsByIDs(ctx context.Context, productIds []string) ([]*models.Product, error) {
+	collection := r.mongoDB.Collection("products")
+
+	products := make([]*models.Product, 0, len(productIds))
+	if len(productIds) == 0 {
+		return products, nil
 	}
-	pool, err := db.GetPostgresPool()
+
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": productIds}})
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &productRepo{
-		pool:        pool,
-		mongoClient: mongoClient,
-		redisClient: redisClient,
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
 	}
+	return products, nil
+}
+
+func NewProductRepository(mongoDB *mongo.Database) ProductRepo {
+	return &productRepo{mongoDB: mongoDB}
 }
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+
+	"e-commerce.com/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReconciliationRepo interface {
+	CreateLog(ctx context.Context, log *models.ReconciliationLog) error
+	GetLogs(ctx context.Context, decision, transactionUUID string, skip, limit int) ([]*models.ReconciliationLog, int64, error)
+}
+
+type reconciliationRepo struct {
+	mongoDB *mongo.Database
+}
+
+func (r *reconciliationRepo) CreateLog(ctx context.Context, log *models.ReconciliationLog) error {
+	collection := r.mongoDB.Collection("reconciliation_logs")
+	_, err := collection.InsertOne(ctx, log)
+	return err
+}
+
+func (r *reconciliationRepo) GetLogs(ctx context.Context, decision, transactionUUID string, skip, limit int) ([]*models.ReconciliationLog, int64, error) {
+	collection := r.mongoDB.Collection("reconciliation_logs")
+
+	filter := bson.M{}
+	if decision != "" {
+		filter["decision"] = decision
+	}
+	if transactionUUID != "" {
+		filter["transactionUuid"] = transactionUUID
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	logs := make([]*models.ReconciliationLog, 0)
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+func NewReconciliationRepository(mongoDB *mongo.Database) ReconciliationRepo {
+	return &reconciliationRepo{mongoDB: mongoDB}
+}
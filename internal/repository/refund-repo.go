@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RefundRepo interface {
+	CreateRefund(ctx context.Context, refund *models.Refund) error
+	UpdateRefundStatus(ctx context.Context, refundId string, status models.RefundStatus, gatewayRef, failureReason string) error
+	GetRefundByID(ctx context.Context, refundId string) (*models.Refund, error)
+	GetRefundsByOrderID(ctx context.Context, orderId string) ([]*models.Refund, error)
+	GetRefunds(ctx context.Context, status string, skip, limit int) ([]*models.Refund, int64, error)
+}
+
+type refundRepo struct {
+	mongoDB *mongo.Database
+}
+
+func (r *refundRepo) CreateRefund(ctx context.Context, refund *models.Refund) error {
+	collection := r.mongoDB.Collection("refunds")
+	_, err := collection.InsertOne(ctx, refund)
+	return err
+}
+
+func (r *refundRepo) UpdateRefundStatus(ctx context.Context, refundId string, status models.RefundStatus, gatewayRef, failureReason string) error {
+	collection := r.mongoDB.Collection("refunds")
+	_, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": refundId},
+		bson.M{"$set": bson.M{
+			"status":        status,
+			"gatewayRef":    gatewayRef,
+			"failureReason": failureReason,
+			"updatedAt":     time.Now(),
+		}},
+	)
+	return err
+}
+
+func (r *refundRepo) GetRefundByID(ctx context.Context, refundId string) (*models.Refund, error) {
+	collection := r.mongoDB.Collection("refunds")
+	var refund models.Refund
+	if err := collection.FindOne(ctx, bson.M{"_id": refundId}).Decode(&refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (r *refundRepo) GetRefundsByOrderID(ctx context.Context, orderId string) ([]*models.Refund, error) {
+	collection := r.mongoDB.Collection("refunds")
+	cursor, err := collection.Find(ctx, bson.M{"orderId": orderId}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	refunds := make([]*models.Refund, 0)
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+func (r *refundRepo) GetRefunds(ctx context.Context, status string, skip, limit int) ([]*models.Refund, int64, error) {
+	collection := r.mongoDB.Collection("refunds")
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"createdAt": -1}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	refunds := make([]*models.Refund, 0)
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return nil, 0, err
+	}
+	return refunds, total, nil
+}
+
+func NewRefundRepository(mongoDB *mongo.Database) RefundRepo {
+	return &refundRepo{mongoDB: mongoDB}
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReservationRepo interface {
+	CreateReservation(ctx context.Context, reservation *models.StockReservation) error
+	GetReservationByTransactionUUID(ctx context.Context, transactionUUID string) (*models.StockReservation, error)
+	// TransitionReservation moves a reservation that is still in from to to.
+	// It reports false when the reservation has another status
+	TransitionReservation(ctx context.Context, id string, from, to models.ReservationStatus, reason string) (bool, error)
+	// GetExpiredReservations returns active reservations that expired before now, oldest first
+	GetExpiredReservations(ctx context.Context, now time.Time, limit int) ([]*models.StockReservation, error)
+	// ExtendReservation moves the expiry of a reservation that is still active
+	ExtendReservation(ctx context.Context, id string, expiresAt time.Time) error
+}
+
+type reservationRepo struct {
+	mongoDB *mongo.Database
+}
+
+func (r *reservationRepo) CreateReservation(ctx context.Context, reservation *models.StockReservation) error {
+	collection := r.mongoDB.Collection("stock_reservations")
+	_, err := collection.InsertOne(ctx, reservation)
+	return err
+}
+
+func (r *reservationRepo) GetReservationByTransactionUUID(ctx context.Context, transactionUUID string) (*models.StockReservation, error) {
+	collection := r.mongoDB.Collection("stock_reservations")
+	var reservation models.StockReservation
+	err := collection.FindOne(ctx, bson.M{"transactionUuid": transactionUUID}).Decode(&reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func (r *reservationRepo) TransitionReservation(ctx context.Context, id string, from, to models.ReservationStatus, reason string) (bool, error) {
+	collection := r.mongoDB.Collection("stock_reservations")
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": from},
+		bson.M{"$set": bson.M{"status": to, "reason": reason, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *reservationRepo) ExtendReservation(ctx context.Context, id string, expiresAt time.Time) error {
+	collection := r.mongoDB.Collection("stock_reservations")
+	_, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": models.ReservationActive},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt, "updatedAt": time.Now()}},
+	)
+	return err
+}
+
+func (r *reservationRepo) GetExpiredReservations(ctx context.Context, now time.Time, limit int) ([]*models.StockReservation, error) {
+	collection := r.mongoDB.Collection("stock_reservations")
+
+	opts := options.Find().
+		SetSort(bson.M{"expiresAt": 1}).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, bson.M{
+		"status":    models.ReservationActive,
+		"expiresAt": bson.M{"$lt": now},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reservations := make([]*models.StockReservation, 0)
+	if err := cursor.All(ctx, &reservations); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+func NewReservationRepository(mongoDB *mongo.Database) ReservationRepo {
+	return &reservationRepo{mongoDB: mongoDB}
+}
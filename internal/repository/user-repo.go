@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"time"
 
-	"e-commerce.com/internal/db"
 	"e-commerce.com/internal/models"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
@@ -24,8 +24,21 @@ type UserRepo interface {
 	DeleteUserSessionFromRedis(ctx context.Context, userId string) error
 	LoginUser(ctx context.Context, userLogin *models.UserLogin) (*models.User, error)
 	CreateUserSessionInRedis(ctx context.Context, user *models.User) error
+	UpdatePassword(ctx context.Context, userId, hashedPassword string) error
+	GetUserByID(ctx context.Context, userId string) (*models.User, error)
+	UpdateProfile(ctx context.Context, userId string, profile *models.UpdateProfileRequest) (*models.User, error)
+	UpdateEmail(ctx context.Context, userId, email string) error
 }
 
+// uniqueViolationCode is the Postgres error code of a unique constraint violation
+const uniqueViolationCode = "23505"
+
+// ErrEmailExists is returned when an email is changed to one another user already has
+var ErrEmailExists = errors.New("email already registered")
+
+// userColumns are the columns scanned by scanUser, in its order
+const userColumns = `id, username, email, password, role, is_verified, phone, avatar_url, created_at, updated_at`
+
 type userRepo struct {
 	pool        *pgxpool.Pool
 	redisClient *redis.Client
@@ -227,44 +240,117 @@ func (r *userRepo) CreateUser(ctx context.Context, user *models.User) error {
 }
 
 func (r *userRepo) GetUserByEmail(email string) (*models.User, error) {
-	query := `
-		SELECT 
-			id, username, email, password, role, is_verified, created_at, updated_at
-		FROM users 
-		WHERE email = $1
-	`
-	var user models.User
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+
+	user, err := scanUser(r.pool.QueryRow(context.Background(), query, email))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (r *userRepo) GetUserByID(ctx context.Context, userId string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
 
-	err := r.pool.QueryRow(context.Background(), query, email).Scan(
+	user, err := scanUser(r.pool.QueryRow(ctx, query, userId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	var user models.User
+	err := row.Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.Password,
 		&user.Role,
 		&user.IsVerified,
+		&user.Phone,
+		&user.AvatarURL,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
 
+// UpdateProfile changes the profile fields that are set in profile and returns the updated user
+func (r *userRepo) UpdateProfile(ctx context.Context, userId string, profile *models.UpdateProfileRequest) (*models.User, error) {
+	query := `
+		UPDATE users
+		SET username = COALESCE($1, username),
+			phone = COALESCE($2, phone),
+			avatar_url = COALESCE($3, avatar_url),
+			updated_at = $4
+		WHERE id = $5
+		RETURNING ` + userColumns
+
+	user, err := scanUser(r.pool.QueryRow(ctx, query, profile.Username, profile.Phone, profile.AvatarURL, time.Now(), userId))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
+			return nil, fmt.Errorf("user %s not found", userId)
 		}
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to update profile: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func NewUserRepository() UserRepo {
-	pool, err := db.GetPostgresPool()
+func (r *userRepo) UpdateEmail(ctx context.Context, userId, email string) error {
+	query := `
+		UPDATE users
+		SET email = $1, updated_at = $2
+		WHERE id = $3
+	`
+
+	result, err := r.pool.Exec(ctx, query, email, time.Now(), userId)
 	if err != nil {
-		return nil
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+			return ErrEmailExists
+		}
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user %s not found", userId)
 	}
-	redisClient, err := db.GetRedisClient()
 
+	return nil
+}
+
+func (r *userRepo) UpdatePassword(ctx context.Context, userId, hashedPassword string) error {
+	query := `
+		UPDATE users
+		SET password = $1, updated_at = $2
+		WHERE id = $3
+	`
+
+	result, err := r.pool.Exec(ctx, query, hashedPassword, time.Now(), userId)
 	if err != nil {
-		return nil
+		return fmt.Errorf("failed to update password: %w", err)
 	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user %s not found", userId)
+	}
+
+	return nil
+}
+
+func NewUserRepository(pool *pgxpool.Pool, redisClient *redis.Client) UserRepo {
 	return &userRepo{pool: pool, redisClient: redisClient}
 }
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"e-commerce.com/internal/app"
+	"e-commerce.com/internal/middleware"
+	"e-commerce.com/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func AdminRouter(router *gin.RouterGroup, appConfig *app.App) {
+	adminRoute := router.Group("/admin", middleware.UserTokenVerification(appConfig.RedisClient), middleware.RequireRole(models.RoleAdmin))
+
+	adminRoute.GET("/refunds", appConfig.RefundHandler.GetRefunds)
+	adminRoute.GET("/orders/:orderId/refunds", appConfig.RefundHandler.GetOrderRefunds)
+	adminRoute.POST("/orders/:orderId/refunds", appConfig.RefundHandler.AdminRefundOrder)
+
+	adminRoute.GET("/reconciliation-logs", appConfig.ReconciliationHandler.GetLogs)
+	adminRoute.POST("/reconciliation/run", appConfig.ReconciliationHandler.RunReconciliation)
+
+	adminRoute.GET("/review-reports", appConfig.CommentHandler.GetReviewReports)
+	adminRoute.POST("/review-reports/:reportId/resolve", appConfig.CommentHandler.ResolveReviewReport)
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"e-commerce.com/internal/app"
+	"e-commerce.com/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func CartRouter(router *gin.RouterGroup, appConfig *app.App) {
+	cartRoute := router.Group("/cart")
+
+	cartRoute.GET("", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.CartHandler.GetCart)
+	cartRoute.DELETE("", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.CartHandler.ClearCart)
+	cartRoute.POST("/items", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.CartHandler.AddItem)
+	cartRoute.PUT("/items/:productId", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.CartHandler.UpdateItemQuantity)
+	cartRoute.DELETE("/items/:productId", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.CartHandler.RemoveItem)
+}
@@ -8,6 +8,20 @@ import (
 
 func CommentROuter(router *gin.RouterGroup, appConfig *app.App) {
 	commentRoute := router.Group("/comment-service")
-	commentRoute.POST("/create-comment", middleware.UserTokenVerification(), appConfig.CommentHandler.CreateNewComment)
+	commentRoute.POST("/create-comment", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.CommentHandler.CreateNewComment)
+	commentRoute.GET("/products/:productId/reviews", appConfig.CommentHandler.ListReviews)
+	commentRoute.GET("/reactions", appConfig.CommentHandler.GetReactions)
+
+	reviews := commentRoute.Group("/reviews/:reviewId", middleware.UserTokenVerification(appConfig.RedisClient))
+	reviews.PUT("", appConfig.CommentHandler.UpdateReview)
+	reviews.DELETE("", appConfig.CommentHandler.DeleteReview)
+	reviews.PUT("/vote", appConfig.CommentHandler.VoteReview)
+	reviews.PUT("/reaction", appConfig.CommentHandler.ReactToReview)
+	reviews.POST("/report", appConfig.CommentHandler.ReportReview)
+
+	replies := commentRoute.Group("/reviews/:reviewId/replies", middleware.UserTokenVerification(appConfig.RedisClient))
+	replies.POST("", appConfig.CommentHandler.AddReply)
+	replies.PUT("/:replyId", appConfig.CommentHandler.UpdateReply)
+	replies.DELETE("/:replyId", appConfig.CommentHandler.DeleteReply)
 
 }
@@ -9,7 +9,8 @@ import (
 func PaymentServiceRouter(router *gin.RouterGroup, appConfig *app.App) {
 	paymentServiceRoute := router.Group("/payment-service")
 
-	paymentServiceRoute.POST("/initiate-payment", middleware.UserTokenVerification(), appConfig.PaymentHandler.InitiatePayment)
-	paymentServiceRoute.GET("/check-status", middleware.UserTokenVerification(), appConfig.PaymentHandler.CheckPaymentStatus)
-	paymentServiceRoute.POST("/process-successful-payment", middleware.UserTokenVerification(), appConfig.PaymentHandler.ProcessSuccessfulPayment)
+	paymentServiceRoute.POST("/initiate-payment", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.PaymentHandler.InitiatePayment)
+	paymentServiceRoute.GET("/quote", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.PaymentHandler.QuoteCart)
+	paymentServiceRoute.GET("/check-status", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.PaymentHandler.CheckPaymentStatus)
+	paymentServiceRoute.POST("/process-successful-payment", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.PaymentHandler.ProcessSuccessfulPayment)
 }
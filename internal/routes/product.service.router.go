@@ -3,16 +3,18 @@ package routes
 import (
 	"e-commerce.com/internal/app"
 	"e-commerce.com/internal/middleware"
+	"e-commerce.com/internal/models"
 	"github.com/gin-gonic/gin"
 )
 
 func ProductServiceRouter(router *gin.RouterGroup, appConfig *app.App) {
 	productServiceRoute := router.Group("/product-service")
+	sellerOnly := middleware.RequireRole(models.RoleSeller, models.RoleAdmin)
 
-	productServiceRoute.POST("/create-product", middleware.UserTokenVerification(), appConfig.ProductHandler.CreateProduct)
-	productServiceRoute.GET("/get-seller-products", middleware.UserTokenVerification(), appConfig.ProductHandler.GetSellerProducts)
-	productServiceRoute.PUT("/update-product/:productId", middleware.UserTokenVerification(), appConfig.ProductHandler.UpdateProduct)
-	productServiceRoute.DELETE("/delete-product/:productId", middleware.UserTokenVerification(), appConfig.ProductHandler.DeleteProduct)
+	productServiceRoute.POST("/create-product", middleware.UserTokenVerification(appConfig.RedisClient), sellerOnly, appConfig.ProductHandler.CreateProduct)
+	productServiceRoute.GET("/get-seller-products", middleware.UserTokenVerification(appConfig.RedisClient), sellerOnly, appConfig.ProductHandler.GetSellerProducts)
+	productServiceRoute.PUT("/update-product/:productId", middleware.UserTokenVerification(appConfig.RedisClient), sellerOnly, appConfig.ProductHandler.UpdateProduct)
+	productServiceRoute.DELETE("/delete-product/:productId", middleware.UserTokenVerification(appConfig.RedisClient), sellerOnly, appConfig.ProductHandler.DeleteProduct)
 	productServiceRoute.GET("/get-product-by-id/:productId", appConfig.ProductHandler.GetProductById)
 	productServiceRoute.GET("/get-all-products", appConfig.ProductHandler.GetAllProducts)
 }
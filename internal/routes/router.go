@@ -2,14 +2,19 @@ package routes
 
 import (
 	"e-commerce.com/internal/app"
+	"e-commerce.com/internal/handler"
 	"github.com/gin-gonic/gin"
 )
 
 func SetUpRoutes(app *gin.Engine, appConfig *app.App) {
+	app.GET("/.well-known/jwks.json", handler.JWKSHandler)
+
 	apiGroup := app.Group("/api/v1")
 	UserServiceRouter(apiGroup, appConfig)
 	ProductServiceRouter(apiGroup, appConfig)
 	PaymentServiceRouter(apiGroup, appConfig)
 	OrderRouter(apiGroup, appConfig)
 	CommentROuter(apiGroup, appConfig)
+	CartRouter(apiGroup, appConfig)
+	AdminRouter(apiGroup, appConfig)
 }
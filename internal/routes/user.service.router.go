@@ -11,7 +11,7 @@ import (
 
 func UserServiceRouter(router *gin.RouterGroup, appConfig *app.App) {
 	userServiceRoute := router.Group("/user-service")
-	userServiceRoute.GET("/user-token-verification", middleware.UserTokenVerification(), func(c *gin.Context) {
+	userServiceRoute.GET("/user-token-verification", middleware.UserTokenVerification(appConfig.RedisClient), func(c *gin.Context) {
 		userEmail, ok := c.Get("userEmail")
 		if !ok {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -58,4 +58,20 @@ func UserServiceRouter(router *gin.RouterGroup, appConfig *app.App) {
 
 	userServiceRoute.POST("/create-user", appConfig.UserHandler.RegisterUserHandler)
 	userServiceRoute.POST("/login", appConfig.UserHandler.LoginUserHandler)
+	userServiceRoute.POST("/refresh", appConfig.UserHandler.RefreshTokenHandler)
+	userServiceRoute.POST("/forgot-password", appConfig.UserHandler.ForgotPasswordHandler)
+	userServiceRoute.POST("/reset-password", appConfig.UserHandler.ResetPasswordHandler)
+	userServiceRoute.GET("/confirm-email-change", appConfig.UserHandler.ConfirmEmailChangeHandler)
+
+	userServiceRoute.GET("/profile", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.UserHandler.GetProfileHandler)
+	userServiceRoute.PUT("/profile", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.UserHandler.UpdateProfileHandler)
+	userServiceRoute.POST("/change-password", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.UserHandler.ChangePasswordHandler)
+	userServiceRoute.POST("/change-email", middleware.UserTokenVerification(appConfig.RedisClient), appConfig.UserHandler.ChangeEmailHandler)
+
+	addressRoute := userServiceRoute.Group("/addresses", middleware.UserTokenVerification(appConfig.RedisClient))
+	addressRoute.GET("", appConfig.AddressHandler.ListAddresses)
+	addressRoute.POST("", appConfig.AddressHandler.CreateAddress)
+	addressRoute.PUT("/:addressId", appConfig.AddressHandler.UpdateAddress)
+	addressRoute.DELETE("/:addressId", appConfig.AddressHandler.DeleteAddress)
+	addressRoute.POST("/:addressId/default", appConfig.AddressHandler.SetDefaultAddress)
 }
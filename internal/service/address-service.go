@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+	"e-commerce.com/internal/utils"
+)
+
+var (
+	ErrAddressNotFound    = errors.New("address not found")
+	ErrInvalidAddressType = errors.New("address type must be shipping or billing")
+)
+
+// AddressService manages the address book of a user and picks the addresses of a checkout
+type AddressService interface {
+	ListAddresses(ctx context.Context, userId string) ([]models.Address, error)
+	CreateAddress(ctx context.Context, userId string, req *models.AddressRequest) (*models.Address, error)
+	UpdateAddress(ctx context.Context, userId, addressId string, req *models.AddressRequest) (*models.Address, error)
+	DeleteAddress(ctx context.Context, userId, addressId string) error
+	SetDefaultAddress(ctx context.Context, userId, addressId string) error
+	// CheckoutAddress returns the snapshot of the chosen address, or of the user's default address
+	// of the type when none was chosen. It is nil when there is neither
+	CheckoutAddress(ctx context.Context, userId, addressId string, addressType models.AddressType) (*models.AddressSnapshot, error)
+}
+
+type addressService struct {
+	repo repository.AddressRepo
+}
+
+func NewAddressService(repo repository.AddressRepo) AddressService {
+	return &addressService{repo: repo}
+}
+
+func (s *addressService) ListAddresses(ctx context.Context, userId string) ([]models.Address, error) {
+	return s.repo.GetAddresses(ctx, userId)
+}
+
+func (s *addressService) CreateAddress(ctx context.Context, userId string, req *models.AddressRequest) (*models.Address, error) {
+	address, err := newAddress(req)
+	if err != nil {
+		return nil, err
+	}
+	address.ID = utils.GenerateRandomUUID()
+	address.UserID = userId
+	address.CreatedAt = time.Now()
+	address.UpdatedAt = address.CreatedAt
+
+	// The first address of a type becomes the default
+	if !address.IsDefault {
+		current, err := s.repo.GetDefaultAddress(ctx, userId, address.Type)
+		if err != nil {
+			return nil, err
+		}
+		address.IsDefault = current == nil
+	}
+
+	if err := s.repo.CreateAddress(ctx, address); err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+func (s *addressService) UpdateAddress(ctx context.Context, userId, addressId string, req *models.AddressRequest) (*models.Address, error) {
+	current, err := s.repo.GetAddress(ctx, userId, addressId)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, ErrAddressNotFound
+	}
+
+	address, err := newAddress(req)
+	if err != nil {
+		return nil, err
+	}
+	address.ID = current.ID
+	address.UserID = userId
+	address.CreatedAt = current.CreatedAt
+	address.UpdatedAt = time.Now()
+	// Unsetting the flag does not take the default away, another address has to be made the default
+	address.IsDefault = address.IsDefault || (current.IsDefault && current.Type == address.Type)
+
+	updated, err := s.repo.UpdateAddress(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrAddressNotFound
+	}
+	return address, nil
+}
+
+func (s *addressService) DeleteAddress(ctx context.Context, userId, addressId string) error {
+	deleted, err := s.repo.DeleteAddress(ctx, userId, addressId)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrAddressNotFound
+	}
+	return nil
+}
+
+func (s *addressService) SetDefaultAddress(ctx context.Context, userId, addressId string) error {
+	updated, err := s.repo.SetDefaultAddress(ctx, userId, addressId)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrAddressNotFound
+	}
+	return nil
+}
+
+func (s *addressService) CheckoutAddress(ctx context.Context, userId, addressId string, addressType models.AddressType) (*models.AddressSnapshot, error) {
+	var address *models.Address
+	var err error
+	if addressId != "" {
+		address, err = s.repo.GetAddress(ctx, userId, addressId)
+		if err != nil {
+			return nil, err
+		}
+		if address == nil {
+			return nil, fmt.Errorf("%w: %s", ErrAddressNotFound, addressId)
+		}
+	} else {
+		address, err = s.repo.GetDefaultAddress(ctx, userId, addressType)
+		if err != nil {
+			return nil, err
+		}
+		if address == nil {
+			return nil, nil
+		}
+	}
+	return address.Snapshot(), nil
+}
+
+// newAddress validates an address request, addresses are shipping addresses unless told otherwise
+func newAddress(req *models.AddressRequest) (*models.Address, error) {
+	addressType := req.Type
+	if addressType == "" {
+		addressType = models.AddressShipping
+	}
+	if addressType != models.AddressShipping && addressType != models.AddressBilling {
+		return nil, ErrInvalidAddressType
+	}
+
+	return &models.Address{
+		Type:       addressType,
+		FullName:   req.FullName,
+		Phone:      req.Phone,
+		Line1:      req.Line1,
+		Line2:      req.Line2,
+		City:       req.City,
+		State:      req.State,
+		PostalCode: req.PostalCode,
+		Country:    req.Country,
+		IsDefault:  req.IsDefault,
+	}, nil
+}
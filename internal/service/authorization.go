@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"e-commerce.com/internal/models"
+)
+
+// ForbiddenError is returned when the caller acts on a resource it does not own
+type ForbiddenError struct {
+	Resource string
+	ID       string
+	UserID   string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("user %s is not allowed to access %s %s", e.UserID, e.Resource, e.ID)
+}
+
+// IsForbidden reports whether err is or wraps a ForbiddenError
+func IsForbidden(err error) bool {
+	var forbidden *ForbiddenError
+	return errors.As(err, &forbidden)
+}
+
+// authorizeProductSeller checks that the product belongs to the seller
+func authorizeProductSeller(product *models.Product, sellerId string) error {
+	if sellerId == "" || product.SellerID != sellerId {
+		return &ForbiddenError{Resource: "product", ID: product.ID, UserID: sellerId}
+	}
+	return nil
+}
+
+// authorizeOrderSeller checks that at least one line of the order is a product of the seller
+func authorizeOrderSeller(order *models.Order, sellerId string) error {
+	if sellerId != "" {
+		for _, item := range order.Products {
+			if item.SellerID == sellerId {
+				return nil
+			}
+		}
+	}
+	return &ForbiddenError{Resource: "order", ID: order.ID, UserID: sellerId}
+}
+
+// authorizeOrderOwner checks that every line of the order is a product of the seller,
+// for changes that would also affect other sellers' lines
+func authorizeOrderOwner(order *models.Order, sellerId string) error {
+	if sellerId == "" || len(order.Products) == 0 {
+		return &ForbiddenError{Resource: "order", ID: order.ID, UserID: sellerId}
+	}
+	for _, item := range order.Products {
+		if item.SellerID != sellerId {
+			return &ForbiddenError{Resource: "order", ID: order.ID, UserID: sellerId}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+)
+
+var (
+	ErrInvalidQuantity   = errors.New("quantity must be at least 1")
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInsufficientStock = errors.New("not enough stock available")
+	ErrCartItemNotFound  = errors.New("product is not in the cart")
+	ErrEmptyCart         = errors.New("cart is empty")
+)
+
+type CartService interface {
+	GetCart(ctx context.Context, userId string) (*models.Cart, error)
+	AddItem(ctx context.Context, userId, productId string, quantity int64) (*models.Cart, error)
+	UpdateItemQuantity(ctx context.Context, userId, productId string, quantity int64) (*models.Cart, error)
+	RemoveItem(ctx context.Context, userId, productId string) (*models.Cart, error)
+	ClearCart(ctx context.Context, userId string) error
+}
+
+type cartService struct {
+	cartRepo    repository.CartRepo
+	productRepo repository.ProductRepo
+}
+
+func NewCartService(cartRepo repository.CartRepo, productRepo repository.ProductRepo) CartService {
+	return &cartService{
+		cartRepo:    cartRepo,
+		productRepo: productRepo,
+	}
+}
+
+// GetCart resolves every stored line against the current product price and stock
+func (s *cartService) GetCart(ctx context.Context, userId string) (*models.Cart, error) {
+	items, err := s.cartRepo.GetCartItems(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	productIds := make([]string, 0, len(items))
+	for _, item := range items {
+		productIds = append(productIds, item.ProductID)
+	}
+
+	products, err := s.productRepo.GetProductsByIDs(ctx, productIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cart products: %v", err)
+	}
+
+	productsById := make(map[string]*models.Product, len(products))
+	for _, product := range products {
+		productsById[product.ID] = product
+	}
+
+	cart := &models.Cart{
+		UserID: userId,
+		Lines:  make([]models.CartLine, 0, len(items)),
+	}
+
+	for _, item := range items {
+		line := models.CartLine{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+		}
+
+		product, ok := productsById[item.ProductID]
+		if !ok {
+			line.Issue = "product is no longer available"
+		} else {
+			line.SellerID = product.SellerID
+			line.Name = product.Name
+			if len(product.Images) > 0 {
+				line.Image = product.Images[0]
+			}
+			line.UnitPrice = int64(product.Price)
+			line.Discount = clampPercent(product.Discount)
+			subtotal, discount := priceLine(line.UnitPrice, item.Quantity, product.Discount)
+			line.LineTotal = subtotal - discount
+			line.Stock = product.Stock
+
+			switch {
+			case product.Stock <= 0:
+				line.Issue = "out of stock"
+			case int64(product.Stock) < item.Quantity:
+				line.Issue = fmt.Sprintf("only %d left in stock", product.Stock)
+			default:
+				line.Available = true
+			}
+		}
+
+		if line.Available {
+			cart.ItemCount += line.Quantity
+			cart.Subtotal += line.LineTotal
+		} else {
+			cart.HasIssues = true
+		}
+		cart.Lines = append(cart.Lines, line)
+	}
+
+	// Redis hashes have no order, keep the response stable for the client
+	sort.Slice(cart.Lines, func(i, j int) bool {
+		return cart.Lines[i].ProductID < cart.Lines[j].ProductID
+	})
+
+	return cart, nil
+}
+
+func (s *cartService) AddItem(ctx context.Context, userId, productId string, quantity int64) (*models.Cart, error) {
+	if quantity < 1 {
+		return nil, ErrInvalidQuantity
+	}
+
+	// The increment is atomic so concurrent adds can not overwrite each other,
+	// the stock is checked against the total it produced
+	total, err := s.cartRepo.IncrementCartItem(ctx, userId, productId, quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkStock(ctx, productId, total); err != nil {
+		s.undoIncrement(ctx, userId, productId, quantity)
+		return nil, err
+	}
+
+	return s.GetCart(ctx, userId)
+}
+
+// undoIncrement takes back an increment that went over the stock, dropping the item when nothing is left
+func (s *cartService) undoIncrement(ctx context.Context, userId, productId string, quantity int64) {
+	remaining, err := s.cartRepo.IncrementCartItem(ctx, userId, productId, -quantity)
+	if err != nil {
+		fmt.Printf("WARNING: failed to undo cart increment of product %s for user %s: %v\n", productId, userId, err)
+		return
+	}
+	if remaining <= 0 {
+		if err := s.cartRepo.RemoveCartItem(ctx, userId, productId); err != nil {
+			fmt.Printf("WARNING: failed to remove cart item %s for user %s: %v\n", productId, userId, err)
+		}
+	}
+}
+
+func (s *cartService) UpdateItemQuantity(ctx context.Context, userId, productId string, quantity int64) (*models.Cart, error) {
+	if quantity < 1 {
+		return nil, ErrInvalidQuantity
+	}
+
+	current, err := s.cartRepo.GetCartItemQuantity(ctx, userId, productId)
+	if err != nil {
+		return nil, err
+	}
+	if current == 0 {
+		return nil, ErrCartItemNotFound
+	}
+
+	if err := s.checkStock(ctx, productId, quantity); err != nil {
+		return nil, err
+	}
+
+	if err := s.cartRepo.SetCartItemQuantity(ctx, userId, productId, quantity); err != nil {
+		return nil, err
+	}
+
+	return s.GetCart(ctx, userId)
+}
+
+func (s *cartService) RemoveItem(ctx context.Context, userId, productId string) (*models.Cart, error) {
+	if err := s.cartRepo.RemoveCartItem(ctx, userId, productId); err != nil {
+		return nil, err
+	}
+	return s.GetCart(ctx, userId)
+}
+
+func (s *cartService) ClearCart(ctx context.Context, userId string) error {
+	return s.cartRepo.ClearCart(ctx, userId)
+}
+
+// checkStock makes sure the product exists and has at least quantity units in stock
+func (s *cartService) checkStock(ctx context.Context, productId string, quantity int64) error {
+	products, err := s.productRepo.GetProductsByIDs(ctx, []string{productId})
+	if err != nil {
+		return fmt.Errorf("failed to load product: %v", err)
+	}
+	if len(products) == 0 {
+		return ErrProductNotFound
+	}
+
+	if int64(products[0].Stock) < quantity {
+		return fmt.Errorf("%w: only %d left", ErrInsufficientStock, products[0].Stock)
+	}
+	return nil
+}
@@ -2,46 +2,452 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"e-commerce.com/internal/models"
 	"e-commerce.com/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidRating       = errors.New("rating must be between 1 and 5")
+	ErrReviewNotVerified   = errors.New("only customers who received the product can review it")
+	ErrReviewNotFound      = errors.New("review not found")
+	ErrReplyNotFound       = errors.New("reply not found")
+	ErrEmptyReply          = errors.New("reply message can not be empty")
+	ErrInvalidReviewQuery  = errors.New("invalid review query")
+	ErrInvalidVote         = errors.New("vote must be helpful or unhelpful")
+	ErrInvalidReaction     = errors.New("unknown reaction")
+	ErrOwnReview           = errors.New("you can not vote on, react to or report your own review")
+	ErrInvalidReportReason = errors.New("report reason must be spam, abusive, offensive, fake or other")
+	ErrReportNotFound      = errors.New("open report not found")
+	ErrInvalidReportAction = errors.New("action must be remove or dismiss")
 )
 
 type CommentService interface {
-	CreateNewComment(ctx context.Context, userData *models.ProductReviewFromClient) error
+	// CreateNewComment stores the user's review of a product, replacing the user's earlier review of it.
+	// It reports whether the review is new
+	CreateNewComment(ctx context.Context, userId, userName string, userData *models.ProductReviewFromClient) (*models.ProductReview, bool, error)
+	// ListReviews returns a page of the reviews of a product, query.Cursor continues after an earlier page
+	ListReviews(ctx context.Context, productId string, query models.ReviewQuery) (*models.ReviewPage, error)
+	// UpdateReview and DeleteReview only change reviews of the user
+	UpdateReview(ctx context.Context, userId, reviewId string, req *models.UpdateReviewRequest) (*models.ProductReview, error)
+	DeleteReview(ctx context.Context, userId, reviewId string) error
+	// VoteReview and ReactToReview set the user's vote or reaction on another user's review, one of
+	// each per user per review. They return the review with its new counts
+	VoteReview(ctx context.Context, userId, reviewId string, vote models.ReviewVote) (*models.ProductReview, error)
+	ReactToReview(ctx context.Context, userId, reviewId string, reaction models.ReviewReaction) (*models.ProductReview, error)
+	// ReportReview puts the review in the moderation queue
+	ReportReview(ctx context.Context, userId, reviewId string, req *models.ReportReviewRequest) (*models.ReviewReport, error)
+	GetReviewReports(ctx context.Context, status string, page, limit int) ([]*models.ReviewReport, int64, error)
+	// ResolveReviewReport removes the reported review, resolving all its open reports, or dismisses the report
+	ResolveReviewReport(ctx context.Context, moderatorId, reportId string, action models.ReviewReportAction) (*models.ReviewReport, error)
+	// AddReply replies to a review. The reply of the product's seller is flagged as the official response
+	AddReply(ctx context.Context, userId, userName, reviewId, message string) (*models.ProductReviewReply, error)
+	// UpdateReply and DeleteReply only change replies of the user
+	UpdateReply(ctx context.Context, userId, reviewId, replyId, message string) error
+	DeleteReply(ctx context.Context, userId, reviewId, replyId string) error
 }
 
 type commentService struct {
 	commentRepo repository.CommentRepo
+	orderRepo   repository.OrderRepo
+	productRepo repository.ProductRepo
+	// requireVerified rejects reviews without a delivered order of the product
+	requireVerified bool
 }
 
-func (s *commentService) CreateNewComment(ctx context.Context, userData *models.ProductReviewFromClient) error {
+func (s *commentService) CreateNewComment(ctx context.Context, userId, userName string, userData *models.ProductReviewFromClient) (*models.ProductReview, bool, error) {
+	if userData.Rating < 1 || userData.Rating > 5 {
+		return nil, false, ErrInvalidRating
+	}
+
+	products, err := s.productRepo.GetProductsByIDs(ctx, []string{userData.ProductId})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load product: %v", err)
+	}
+	if len(products) == 0 {
+		return nil, false, ErrProductNotFound
+	}
+
+	verified, err := s.orderRepo.HasDeliveredProduct(ctx, userId, userData.ProductId)
+	if err != nil {
+		return nil, false, err
+	}
+	if !verified && s.requireVerified {
+		return nil, false, ErrReviewNotVerified
+	}
+
 	time := time.Now()
 	newData := models.ProductReview{
-		ProductId: userData.ProductId,
-		UserId:    userData.UserId,
-		UserName:  userData.UserName,
-		Rating:    userData.Rating,
-		Comment:   userData.Comment,
-		CreatedAt: time,
-		UpdatedAt: time,
-		Replies:   nil,
+		ProductId:        userData.ProductId,
+		UserId:           userId,
+		UserName:         userName,
+		Rating:           userData.Rating,
+		Comment:          userData.Comment,
+		VerifiedPurchase: verified,
+		CreatedAt:        time,
+		UpdatedAt:        time,
+		Replies:          nil,
 	}
 
-	fmt.Println("this is new data for comment : ", newData.ProductId)
-
-	err := s.commentRepo.CreateComment(ctx, &newData)
+	created, err := s.commentRepo.UpsertReview(ctx, &newData)
 	if err != nil {
 		fmt.Println("failed to create in service section ", err)
-		return fmt.Errorf("failed to create comment")
+		return nil, false, fmt.Errorf("failed to create comment")
+	}
+	return &newData, created, nil
+}
+
+const (
+	defaultReviewPageSize = 10
+	maxReviewPageSize     = 50
+)
+
+func (s *commentService) ListReviews(ctx context.Context, productId string, query models.ReviewQuery) (*models.ReviewPage, error) {
+	if query.Sort == "" {
+		query.Sort = models.ReviewSortNewest
+	}
+	switch query.Sort {
+	case models.ReviewSortNewest, models.ReviewSortHighest, models.ReviewSortLowest, models.ReviewSortHelpful:
+	default:
+		return nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidReviewQuery, query.Sort)
+	}
+	if query.Rating < 0 || query.Rating > 5 {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidReviewQuery, ErrInvalidRating)
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultReviewPageSize
+	}
+	if query.Limit > maxReviewPageSize {
+		query.Limit = maxReviewPageSize
+	}
+
+	var after *models.ReviewCursor
+	if query.Cursor != "" {
+		cursor, err := decodeReviewCursor(query.Cursor)
+		if err != nil || cursor.Sort != query.Sort {
+			return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidReviewQuery)
+		}
+		after = cursor
+	}
+
+	// One review more than the page tells whether there is a next page
+	reviews, err := s.commentRepo.ListReviews(ctx, productId, query, after, query.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.ReviewPage{Reviews: reviews}
+	if len(reviews) > query.Limit {
+		page.Reviews = reviews[:query.Limit]
+		page.HasMore = true
+
+		last := page.Reviews[len(page.Reviews)-1]
+		page.NextCursor, err = encodeReviewCursor(&models.ReviewCursor{
+			Sort:         query.Sort,
+			ID:           last.ID,
+			CreatedAt:    last.CreatedAt,
+			Rating:       last.Rating,
+			HelpfulCount: last.HelpfulCount,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// encodeReviewCursor makes the cursor an opaque URL safe string
+func encodeReviewCursor(cursor *models.ReviewCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeReviewCursor(value string) (*models.ReviewCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor models.ReviewCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+func (s *commentService) UpdateReview(ctx context.Context, userId, reviewId string, req *models.UpdateReviewRequest) (*models.ProductReview, error) {
+	if req.Rating < 1 || req.Rating > 5 {
+		return nil, ErrInvalidRating
+	}
+	id, err := primitive.ObjectIDFromHex(reviewId)
+	if err != nil {
+		return nil, ErrReviewNotFound
+	}
+
+	review, err := s.commentRepo.UpdateReview(ctx, id, userId, req.Rating, req.Comment, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if review == nil {
+		return nil, ErrReviewNotFound
+	}
+	return review, nil
+}
+
+func (s *commentService) DeleteReview(ctx context.Context, userId, reviewId string) error {
+	id, err := primitive.ObjectIDFromHex(reviewId)
+	if err != nil {
+		return ErrReviewNotFound
+	}
+
+	deleted, err := s.commentRepo.DeleteReview(ctx, id, userId)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrReviewNotFound
 	}
 	return nil
 }
 
-func NewCommnetService(commentRepo repository.CommentRepo) CommentService {
+func (s *commentService) VoteReview(ctx context.Context, userId, reviewId string, vote models.ReviewVote) (*models.ProductReview, error) {
+	if vote != "" && vote != models.ReviewVoteHelpful && vote != models.ReviewVoteUnhelpful {
+		return nil, ErrInvalidVote
+	}
+	review, err := s.othersReview(ctx, userId, reviewId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.commentRepo.SetVote(ctx, review.ID, userId, vote); err != nil {
+		return nil, err
+	}
+	return s.commentRepo.GetReview(ctx, review.ID)
+}
+
+func (s *commentService) ReactToReview(ctx context.Context, userId, reviewId string, reaction models.ReviewReaction) (*models.ProductReview, error) {
+	if _, ok := models.ReviewReactionEmojis[reaction]; reaction != "" && !ok {
+		return nil, ErrInvalidReaction
+	}
+	review, err := s.othersReview(ctx, userId, reviewId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.commentRepo.SetReaction(ctx, review.ID, userId, reaction); err != nil {
+		return nil, err
+	}
+	return s.commentRepo.GetReview(ctx, review.ID)
+}
+
+func (s *commentService) ReportReview(ctx context.Context, userId, reviewId string, req *models.ReportReviewRequest) (*models.ReviewReport, error) {
+	switch req.Reason {
+	case models.ReviewReportSpam, models.ReviewReportAbusive, models.ReviewReportOffensive, models.ReviewReportFake, models.ReviewReportOther:
+	default:
+		return nil, ErrInvalidReportReason
+	}
+	review, err := s.othersReview(ctx, userId, reviewId)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.ReviewReport{
+		ID:         primitive.NewObjectID(),
+		ReviewID:   review.ID,
+		ProductId:  review.ProductId,
+		ReporterId: userId,
+		Reason:     req.Reason,
+		Details:    strings.TrimSpace(req.Details),
+		Status:     models.ReviewReportOpen,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.commentRepo.CreateReport(ctx, report); err != nil {
+		return nil, err
+	}
+	log.Printf("🚩 Review %s reported as %s", review.ID.Hex(), req.Reason)
+	return report, nil
+}
+
+func (s *commentService) GetReviewReports(ctx context.Context, status string, page, limit int) ([]*models.ReviewReport, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	reports, total, err := s.commentRepo.GetReports(ctx, status, (page-1)*limit, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get review reports: %v", err)
+	}
+	return reports, total, nil
+}
+
+func (s *commentService) ResolveReviewReport(ctx context.Context, moderatorId, reportId string, action models.ReviewReportAction) (*models.ReviewReport, error) {
+	status := models.ReviewReportDismissed
+	switch action {
+	case models.ReviewReportActionDismiss:
+	case models.ReviewReportActionRemove:
+		status = models.ReviewReportRemoved
+	default:
+		return nil, ErrInvalidReportAction
+	}
+	id, err := primitive.ObjectIDFromHex(reportId)
+	if err != nil {
+		return nil, ErrReportNotFound
+	}
+
+	now := time.Now()
+	report, err := s.commentRepo.ResolveReport(ctx, id, status, moderatorId, now)
+	if err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, ErrReportNotFound
+	}
+	if action != models.ReviewReportActionRemove {
+		return report, nil
+	}
+
+	// The review may be gone already when the user deleted it, its reports are still resolved
+	if _, err := s.commentRepo.RemoveReview(ctx, report.ReviewID); err != nil {
+		return nil, err
+	}
+	if err := s.commentRepo.ResolveReviewReports(ctx, report.ReviewID, status, moderatorId, now); err != nil {
+		return nil, err
+	}
+	// Who removed it is stored on the resolved reports
+	log.Printf("🛡️ Review %s removed after report %s", report.ReviewID.Hex(), report.ID.Hex())
+	return report, nil
+}
+
+// othersReview loads a review the user is about to vote on, react to or report, which can not be their own
+func (s *commentService) othersReview(ctx context.Context, userId, reviewId string) (*models.ProductReview, error) {
+	id, err := primitive.ObjectIDFromHex(reviewId)
+	if err != nil {
+		return nil, ErrReviewNotFound
+	}
+	review, err := s.commentRepo.GetReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if review == nil {
+		return nil, ErrReviewNotFound
+	}
+	if review.UserId == userId {
+		return nil, ErrOwnReview
+	}
+	return review, nil
+}
+
+func (s *commentService) AddReply(ctx context.Context, userId, userName, reviewId, message string) (*models.ProductReviewReply, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil, ErrEmptyReply
+	}
+	id, err := primitive.ObjectIDFromHex(reviewId)
+	if err != nil {
+		return nil, ErrReviewNotFound
+	}
+
+	review, err := s.commentRepo.GetReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if review == nil {
+		return nil, ErrReviewNotFound
+	}
+
+	products, err := s.productRepo.GetProductsByIDs(ctx, []string{review.ProductId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load product: %v", err)
+	}
+
+	now := time.Now()
+	reply := &models.ProductReviewReply{
+		ReplyID:   primitive.NewObjectID(),
+		Message:   message,
+		UserId:    userId,
+		UserName:  userName,
+		Official:  len(products) > 0 && products[0].SellerID == userId,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	added, err := s.commentRepo.AddReply(ctx, id, reply)
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ErrReviewNotFound
+	}
+	return reply, nil
+}
+
+func (s *commentService) UpdateReply(ctx context.Context, userId, reviewId, replyId, message string) error {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return ErrEmptyReply
+	}
+	reviewObjectId, replyObjectId, err := replyIDs(reviewId, replyId)
+	if err != nil {
+		return err
+	}
+
+	updated, err := s.commentRepo.UpdateReply(ctx, reviewObjectId, replyObjectId, userId, message, time.Now())
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrReplyNotFound
+	}
+	return nil
+}
+
+func (s *commentService) DeleteReply(ctx context.Context, userId, reviewId, replyId string) error {
+	reviewObjectId, replyObjectId, err := replyIDs(reviewId, replyId)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.commentRepo.DeleteReply(ctx, reviewObjectId, replyObjectId, userId)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrReplyNotFound
+	}
+	return nil
+}
+
+// replyIDs parses the ids of a reply path, an id that is not an ObjectID can not match any reply
+func replyIDs(reviewId, replyId string) (primitive.ObjectID, primitive.ObjectID, error) {
+	reviewObjectId, err := primitive.ObjectIDFromHex(reviewId)
+	if err != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, ErrReplyNotFound
+	}
+	replyObjectId, err := primitive.ObjectIDFromHex(replyId)
+	if err != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, ErrReplyNotFound
+	}
+	return reviewObjectId, replyObjectId, nil
+}
+
+func NewCommentService(commentRepo repository.CommentRepo, orderRepo repository.OrderRepo, productRepo repository.ProductRepo, requireVerified bool) CommentService {
 	return &commentService{
-		commentRepo: commentRepo,
+		commentRepo:     commentRepo,
+		orderRepo:       orderRepo,
+		productRepo:     productRepo,
+		requireVerified: requireVerified,
 	}
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"e-commerce.com/internal/models"
 	"e-commerce.com/internal/repository"
@@ -13,9 +14,10 @@ type OrderService interface {
 	GetUserOrders(ctx context.Context, userId string) ([]models.OrderWithProductDetails, int64, error)
 	GetUserOrderDetails(ctx context.Context, userId, orderId string) (*models.Order, error)
 	CancelUserOrder(ctx context.Context, userId, orderId string) error
+	ReturnUserOrder(ctx context.Context, userId, orderId, reason string) error
 	GetSellerOrders(ctx context.Context, sellerId string, page, limit int, status string) ([]*models.Order, int64, error)
 	GetSellerOrderDetails(ctx context.Context, sellerId, orderId string) (*models.Order, error)
-	UpdateOrderStatus(ctx context.Context, sellerId, orderId, status string) error
+	UpdateOrderStatus(ctx context.Context, sellerId, orderId, status, reason string, shipment *models.Shipment) error
 	GetSellerProducts(ctx context.Context, sellerId string, page, limit int) ([]*models.Product, int64, error)
 	UpdateProductStock(ctx context.Context, sellerId, productId string, stock int) error
 	GetSellerOrdersWithDetails(ctx context.Context, sellerId string) ([]models.OrderWithProductDetails, int64, error)
@@ -24,22 +26,35 @@ type OrderService interface {
 }
 
 type orderService struct {
-	orderRepo   repository.OrderRepo
-	productRepo repository.ProductRepo
+	orderRepo     repository.OrderRepo
+	productRepo   repository.ProductRepo
+	refundService RefundService
+	reservations  ReservationService
+	notifier      OrderNotifier
 }
 
-func NewOrderService(orderRepo repository.OrderRepo, productRepo repository.ProductRepo) OrderService {
+func NewOrderService(orderRepo repository.OrderRepo, productRepo repository.ProductRepo, refundService RefundService, reservations ReservationService, notifier OrderNotifier) OrderService {
+	if notifier == nil {
+		notifier = NewLogOrderNotifier()
+	}
 	return &orderService{
-		orderRepo:   orderRepo,
-		productRepo: productRepo,
+		orderRepo:     orderRepo,
+		productRepo:   productRepo,
+		refundService: refundService,
+		reservations:  reservations,
+		notifier:      notifier,
 	}
 }
 
 func (s *orderService) OrderFinished(ctx context.Context, sellerId, orderId string) error {
-	// Update order status to paid and processing
-	err := s.orderRepo.UpdateOrderStatus(ctx, orderId, string(models.OrderStatusDelivered))
+	order, err := s.sellerOrder(ctx, sellerId, orderId)
 	if err != nil {
-		return fmt.Errorf("failed to accept order: %v", err)
+		return err
+	}
+
+	err = s.transition(ctx, order, sellerId, models.OrderStatusDelivered, models.OrderActorSeller, sellerId, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to finish order: %w", err)
 	}
 
 	return nil
@@ -66,33 +81,150 @@ func (s *orderService) GetUserOrderDetails(ctx context.Context, userId, orderId
 }
 
 func (s *orderService) CancelUserOrder(ctx context.Context, userId, orderId string) error {
-	// Check if order can be cancelled (only pending orders)
 	order, err := s.orderRepo.GetUserOrderDetails(ctx, userId, orderId)
 	if err != nil {
 		return fmt.Errorf("failed to get order details: %v", err)
 	}
 
-	fmt.Println("this is order in cancel user order service ; ", order)
+	// Stock is restored and the order refunded by the transition
+	err = s.transitionAll(ctx, order, models.OrderStatusCancelled, models.OrderActorCustomer, userId, "order cancelled by customer")
+	if err != nil {
+		return fmt.Errorf("failed to cancel order: %w", err)
+	}
+
+	return nil
+}
+
+// ReturnUserOrder takes back a delivered order and refunds it
+func (s *orderService) ReturnUserOrder(ctx context.Context, userId, orderId, reason string) error {
+	order, err := s.orderRepo.GetUserOrderDetails(ctx, userId, orderId)
+	if err != nil {
+		return fmt.Errorf("failed to get order details: %v", err)
+	}
+
+	if reason == "" {
+		reason = "order returned by customer"
+	}
+	err = s.transitionAll(ctx, order, models.OrderStatusReturned, models.OrderActorCustomer, userId, reason)
+	if err != nil {
+		return fmt.Errorf("failed to return order: %w", err)
+	}
+
+	return nil
+}
 
-	if order.Status != models.OrderStatusCreated {
-		return fmt.Errorf("order cannot be cancelled in current status: %s", order.Status)
+// transitionAll moves every sub-order the actor may still move to the new status, for changes
+// a customer makes to the whole order. It only fails when none of them could be moved
+func (s *orderService) transitionAll(ctx context.Context, order *models.Order, to models.OrderStatus, actor models.OrderActor, actorId, reason string) error {
+	if err := ensureSellerOrders(ctx, s.orderRepo, order); err != nil {
+		return err
 	}
 
-	// Update order status to cancelled
-	err = s.orderRepo.UpdateOrderStatus(ctx, orderId, string(models.OrderStatusCancelled))
+	sellerIds := make([]string, len(order.SellerOrders))
+	for i, sub := range order.SellerOrders {
+		sellerIds[i] = sub.SellerID
+	}
+
+	var firstErr error
+	moved := 0
+	for _, sellerId := range sellerIds {
+		if err := s.transition(ctx, order, sellerId, to, actor, actorId, reason, nil); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		moved++
+	}
+	if moved == 0 {
+		return firstErr
+	}
+	return nil
+}
+
+// transition moves the seller's sub-order to a new status if the state machine allows it for the
+// actor, records the change in the sub-order's history, runs the side effects of the change and
+// then updates the status of the whole order. shipment carries the carrier and tracking number
+// when the sub-order is shipped
+func (s *orderService) transition(ctx context.Context, order *models.Order, sellerId string, to models.OrderStatus, actor models.OrderActor, actorId, reason string, shipment *models.Shipment) error {
+	if err := ensureSellerOrders(ctx, s.orderRepo, order); err != nil {
+		return err
+	}
+	sub := order.SellerOrder(sellerId)
+	if sub == nil {
+		return &ForbiddenError{Resource: "order", ID: order.ID, UserID: sellerId}
+	}
+
+	rule, err := orderTransitionRule(sub.Status, to, actor)
 	if err != nil {
-		return fmt.Errorf("failed to cancel order: %v", err)
+		return err
+	}
+
+	change := models.OrderStatusChange{
+		From:     sub.Status,
+		To:       to,
+		Actor:    actor,
+		ActorID:  actorId,
+		SellerID: sellerId,
+		Reason:   reason,
+		At:       time.Now(),
+	}
+	shipment = nextShipment(sub.Shipment, shipment, to, change.At)
+	moved, err := s.orderRepo.TransitionSellerOrderStatus(ctx, order.ID, change, shipment)
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return fmt.Errorf("%w: order %s of seller %s is no longer %s", ErrOrderStatusConflict, order.ID, sellerId, sub.Status)
 	}
+	sub.Status = to
+	sub.StatusHistory = append(sub.StatusHistory, change)
+	if shipment != nil {
+		sub.Shipment = shipment
+	}
+
+	if rule.restock {
+		if err := s.reservations.RestoreStock(ctx, sub.Products); err != nil {
+			fmt.Printf("WARNING: Failed to restore product stock: %v\n", err)
+		}
+	}
+	if rule.refund {
+		s.refundSellerOrder(ctx, order, sub, reason)
+	}
+	s.notifier.OrderStatusChanged(ctx, order, change)
 
-	// Restore product stock
-	err = s.restoreProductStock(ctx, order.Products)
+	synced, orderChange, err := syncOrderStatus(ctx, s.orderRepo, order.ID)
 	if err != nil {
-		fmt.Printf("WARNING: Failed to restore product stock: %v\n", err)
+		fmt.Printf("WARNING: Failed to update status of order %s: %v\n", order.ID, err)
+		return nil
+	}
+	*order = *synced
+	if orderChange != nil {
+		s.notifier.OrderStatusChanged(ctx, order, *orderChange)
 	}
 
 	return nil
 }
 
+// refundSellerOrder refunds whatever is left of the seller's sub-order. The last sub-order to
+// close also gives back tax and delivery. A failed refund does not undo the status change,
+// it stays in the refunds collection as failed for an admin to retry
+func (s *orderService) refundSellerOrder(ctx context.Context, order *models.Order, sub *models.SellerOrder, reason string) {
+	req := models.CreateRefundRequest{Reason: reason, SellerID: sub.SellerID}
+	if sellerOrdersClosed(order) {
+		if order.RefundedAmount >= order.Amount {
+			return
+		}
+		req.SellerID = ""
+	} else if sub.RefundedAmount >= sub.Amount {
+		return
+	}
+
+	if _, err := s.refundService.RefundOrder(ctx, order.ID, req, models.RefundInitiatorSystem, ""); err != nil {
+		fmt.Printf("WARNING: Failed to refund order %s of seller %s: %v\n", order.ID, sub.SellerID, err)
+	}
+}
+
 func (s *orderService) GetSellerOrders(ctx context.Context, sellerId string, page, limit int, status string) ([]*models.Order, int64, error) {
 	// Get all seller orders without pagination
 	orders, total, err := s.orderRepo.GetSellerOrders(ctx, sellerId, 0, 0, status)
@@ -104,41 +236,36 @@ func (s *orderService) GetSellerOrders(ctx context.Context, sellerId string, pag
 }
 
 func (s *orderService) GetSellerOrderDetails(ctx context.Context, sellerId, orderId string) (*models.Order, error) {
-	// Get order details for seller
-	order, err := s.orderRepo.GetSellerOrderDetails(ctx, sellerId, orderId)
+	// Get order details for seller, narrowed down to the seller's sub-order
+	order, err := s.sellerOrder(ctx, sellerId, orderId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get order details: %v", err)
+		return nil, err
 	}
-
-	return order, nil
+	return order.ForSeller(sellerId), nil
 }
 
-func (s *orderService) UpdateOrderStatus(ctx context.Context, sellerId, orderId, status string) error {
-	// Validate status
-	validStatuses := []string{
-		string(models.OrderStatusCreated),
-		string(models.OrderStatusPaidAndProcessing),
-		string(models.OrderStatusShipping),
-		string(models.OrderStatusDelivered),
-		string(models.OrderStatusCancelled),
+// sellerOrder loads an order and checks that it contains products of the seller
+func (s *orderService) sellerOrder(ctx context.Context, sellerId, orderId string) (*models.Order, error) {
+	order, err := s.orderRepo.GetOrderByID(ctx, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order details: %v", err)
 	}
-
-	isValidStatus := false
-	for _, validStatus := range validStatuses {
-		if status == validStatus {
-			isValidStatus = true
-			break
-		}
+	if err := authorizeOrderSeller(order, sellerId); err != nil {
+		return nil, err
 	}
+	return order, nil
+}
 
-	if !isValidStatus {
-		return fmt.Errorf("invalid order status: %s", status)
+func (s *orderService) UpdateOrderStatus(ctx context.Context, sellerId, orderId, status, reason string, shipment *models.Shipment) error {
+	order, err := s.sellerOrder(ctx, sellerId, orderId)
+	if err != nil {
+		return err
 	}
 
-	// Update order status
-	err := s.orderRepo.UpdateOrderStatus(ctx, orderId, status)
+	// The state machine decides whether the seller may make this change to its sub-order
+	err = s.transition(ctx, order, sellerId, models.OrderStatus(status), models.OrderActorSeller, sellerId, reason, shipment)
 	if err != nil {
-		return fmt.Errorf("failed to update order status: %v", err)
+		return fmt.Errorf("failed to update order status: %w", err)
 	}
 
 	return nil
@@ -175,41 +302,46 @@ func (s *orderService) GetSellerOrdersWithDetails(ctx context.Context, sellerId
 }
 
 func (s *orderService) AcceptOrder(ctx context.Context, sellerId, orderId string) error {
-	// Update order status to paid and processing
-	err := s.orderRepo.UpdateOrderStatus(ctx, orderId, string(models.OrderStatusPaidAndProcessing))
+	order, err := s.sellerOrder(ctx, sellerId, orderId)
+	if err != nil {
+		return err
+	}
+
+	err = s.transition(ctx, order, sellerId, models.OrderStatusPaidAndProcessing, models.OrderActorSeller, sellerId, "", nil)
 	if err != nil {
-		return fmt.Errorf("failed to accept order: %v", err)
+		return fmt.Errorf("failed to accept order: %w", err)
 	}
 
 	return nil
 }
 
 func (s *orderService) DeleteOrder(ctx context.Context, sellerId, orderId string) error {
-	// Delete the order
-	err := s.orderRepo.DeleteOrder(ctx, orderId)
+	order, err := s.orderRepo.GetOrderByID(ctx, orderId)
 	if err != nil {
-		return fmt.Errorf("failed to delete order: %v", err)
+		return fmt.Errorf("failed to get order details: %v", err)
 	}
 
-	return nil
-}
+	// Deleting removes every line, so the seller has to own all of them
+	if err := authorizeOrderOwner(order, sellerId); err != nil {
+		return err
+	}
 
-// restoreProductStock restores product stock when order is cancelled
-func (s *orderService) restoreProductStock(ctx context.Context, products []models.ProductItem) error {
-	for _, item := range products {
-		// Get current product stock
-		product, _, err := s.productRepo.GetProductByID(ctx, item.ProductID)
-		if err != nil {
-			fmt.Printf("WARNING: Failed to get product %s: %v\n", item.ProductID, err)
-			continue
-		}
+	// Deleting skips the state machine, so only orders it already closed and paid back may go.
+	// Open orders have to be cancelled first, which refunds and restocks them
+	if err := ensureSellerOrders(ctx, s.orderRepo, order); err != nil {
+		return err
+	}
+	if !sellerOrdersClosed(order) || order.RefundedAmount < order.Amount {
+		return fmt.Errorf("%w: order %s is %s with %d of %d refunded, only closed and fully refunded orders can be deleted",
+			ErrInvalidOrderTransition, order.ID, order.Status, order.RefundedAmount, order.Amount)
+	}
 
-		// Restore stock
-		newStock := product.Stock + int(item.Quantity)
-		err = s.productRepo.UpdateProductStock(ctx, product.SellerID, item.ProductID, newStock)
-		if err != nil {
-			fmt.Printf("WARNING: Failed to restore stock for product %s: %v\n", item.ProductID, err)
-		}
+	deleted, err := s.orderRepo.DeleteOrder(ctx, orderId)
+	if err != nil {
+		return fmt.Errorf("failed to delete order: %v", err)
+	}
+	if !deleted {
+		return fmt.Errorf("%w: order %s changed while it was deleted", ErrOrderStatusConflict, order.ID)
 	}
 
 	return nil
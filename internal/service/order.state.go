@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"e-commerce.com/internal/models"
+)
+
+var (
+	ErrInvalidOrderTransition   = errors.New("invalid order status transition")
+	ErrOrderTransitionForbidden = errors.New("not allowed to make this order status change")
+	// ErrOrderStatusConflict is returned when the order changed status while the transition was running
+	ErrOrderStatusConflict = errors.New("order status was changed by another request")
+)
+
+// orderTransition describes one legal status change, who may make it and what has to happen after it
+type orderTransition struct {
+	actors  []models.OrderActor
+	restock bool
+	refund  bool
+}
+
+func (t orderTransition) allows(actor models.OrderActor) bool {
+	for _, allowed := range t.actors {
+		if allowed == actor {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	anyActor         = []models.OrderActor{models.OrderActorCustomer, models.OrderActorSeller, models.OrderActorSystem}
+	sellerOrSystem   = []models.OrderActor{models.OrderActorSeller, models.OrderActorSystem}
+	systemOnly       = []models.OrderActor{models.OrderActorSystem}
+	customerOrSeller = []models.OrderActor{models.OrderActorCustomer, models.OrderActorSeller}
+)
+
+// orderTransitions lists every legal status change, anything not listed is rejected.
+// Orders are only created for paid payments, so cancelling or returning one always refunds it.
+// Cancelled, returned and refunded orders are final
+var orderTransitions = map[models.OrderStatus]map[models.OrderStatus]orderTransition{
+	models.OrderStatusCreated: {
+		models.OrderStatusPaidAndProcessing: {actors: sellerOrSystem},
+		models.OrderStatusCancelled:         {actors: anyActor, restock: true, refund: true},
+		models.OrderStatusRefunded:          {actors: systemOnly},
+	},
+	models.OrderStatusPaidAndProcessing: {
+		models.OrderStatusShipping:  {actors: []models.OrderActor{models.OrderActorSeller}},
+		models.OrderStatusCancelled: {actors: sellerOrSystem, restock: true, refund: true},
+		models.OrderStatusRefunded:  {actors: systemOnly},
+	},
+	models.OrderStatusShipping: {
+		models.OrderStatusDelivered: {actors: sellerOrSystem},
+		models.OrderStatusRefunded:  {actors: systemOnly},
+	},
+	models.OrderStatusDelivered: {
+		models.OrderStatusReturned: {actors: customerOrSeller, restock: true, refund: true},
+		models.OrderStatusRefunded: {actors: systemOnly},
+	},
+}
+
+// orderTransitionRule returns the rule for moving an order from one status to another by actor
+func orderTransitionRule(from, to models.OrderStatus, actor models.OrderActor) (orderTransition, error) {
+	transition, ok := orderTransitions[from][to]
+	if !ok {
+		return orderTransition{}, fmt.Errorf("%w: %s to %s", ErrInvalidOrderTransition, from, to)
+	}
+	if !transition.allows(actor) {
+		return orderTransition{}, fmt.Errorf("%w: %s can not move an order from %s to %s", ErrOrderTransitionForbidden, actor, from, to)
+	}
+	return transition, nil
+}
+
+// OrderNotifier is told about every status change after it was stored
+type OrderNotifier interface {
+	OrderStatusChanged(ctx context.Context, order *models.Order, change models.OrderStatusChange)
+}
+
+type logOrderNotifier struct{}
+
+// NewLogOrderNotifier returns a notifier that writes status changes to the log
+func NewLogOrderNotifier() OrderNotifier {
+	return logOrderNotifier{}
+}
+
+func (logOrderNotifier) OrderStatusChanged(ctx context.Context, order *models.Order, change models.OrderStatusChange) {
+	fmt.Printf("NOTIFY: order %s of user %s moved from %s to %s by %s %s: %s\n",
+		order.ID, order.User, change.From, change.To, change.Actor, change.ActorID, change.Reason)
+}
@@ -1,23 +1,25 @@
 package service
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"strconv"
+	"log"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
-	"e-commerce.com/internal/config"
+	"e-commerce.com/internal/gateway"
 	"e-commerce.com/internal/middleware"
 	"e-commerce.com/internal/models"
 	"e-commerce.com/internal/repository"
 	"e-commerce.com/internal/utils"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // CartItem represents an item in the cart with seller information
@@ -29,130 +31,251 @@ type CartItem struct {
 	Name     string `json:"name"`
 }
 
-type PaymentData struct {
-	Amount                string `json:"amount"`
-	TaxAmount             string `json:"tax_amount"`
-	ProductServiceCharge  string `json:"product_service_charge"`
-	ProductDeliveryCharge string `json:"product_delivery_charge"`
-	TotalAmount           string `json:"total_amount"`
-	TransactionUUID       string `json:"transaction_uuid"`
-	ProductCode           string `json:"product_code"`
-	SuccessURL            string `json:"success_url"`
-	FailureURL            string `json:"failure_url"`
-	SignedFieldNames      string `json:"signed_field_names"`
+// CheckoutOptions are the per request choices made when a payment is started
+type CheckoutOptions struct {
+	// Provider selects the payment gateway, empty uses the default one
+	Provider string
+	// IdempotencyKey makes a retried request return the payment of the first one
+	IdempotencyKey string
+	// ShippingAddressID and BillingAddressID pick addresses of the user's address book,
+	// empty ones use the user's default address of the type
+	ShippingAddressID string
+	BillingAddressID  string
 }
 
-type SignatureData struct {
-	TotalAmount     string
-	TransactionUUID string
-	ProductCode     string
-	SecretKey       string
+// ProcessPaymentRequest is what the client forwards from the provider's success redirect
+type ProcessPaymentRequest struct {
+	Provider string
+	Params   map[string]string
+	// IdempotencyKey makes a retried request return the order of the first one
+	IdempotencyKey string
 }
 
 type PaymentService interface {
-	InitiatePayment(ctx context.Context, cartItems []CartItem) (string, error)
-	CheckPaymentStatus(ctx context.Context, transactionUUID, productCode, totalAmount string) (*PaymentStatusResponse, error)
+	InitiatePayment(ctx context.Context, cartItems []CartItem, opts CheckoutOptions) (*gateway.InitiateResponse, *models.PriceQuote, error)
+	CheckoutCart(ctx context.Context, userId string, opts CheckoutOptions) (*gateway.InitiateResponse, *models.PriceQuote, error)
+	QuoteCart(ctx context.Context, userId string) (*models.PriceQuote, error)
+	CheckPaymentStatus(ctx context.Context, transactionUUID string) (*gateway.StatusResult, error)
 	CreateOrderFromPayment(ctx context.Context, payment *models.Payment) (*models.Order, error)
-	ProcessSuccessfulPayment(ctx context.Context, transactionUUID string) (*models.Order, error)
+	ProcessSuccessfulPayment(ctx context.Context, userId string, req ProcessPaymentRequest) (*models.Order, error)
+	RefundPayment(ctx context.Context, transactionUUID string, amount int64, reason string) (*gateway.RefundResult, error)
+	// ReconcilePayment settles a payment the customer never returned from, using the provider's status.
+	// Payments older than ttl that are still not paid are expired
+	ReconcilePayment(ctx context.Context, payment *models.Payment, ttl time.Duration) *models.ReconciliationLog
 }
 
 type paymentService struct {
-	repo        repository.PaymentRepo
-	orderRepo   repository.OrderRepo
-	productRepo repository.ProductRepo
+	repo         repository.PaymentRepo
+	idempotency  repository.IdempotencyRepo
+	orderRepo    repository.OrderRepo
+	productRepo  repository.ProductRepo
+	cartService  CartService
+	pricing      PricingService
+	reservations ReservationService
+	addresses    AddressService
+	gateways     *gateway.Registry
 }
 
-func NewPaymentService(repo repository.PaymentRepo) PaymentService {
-	if repo == nil {
-		repo = repository.NewPaymentRepository()
-	}
-	orderRepo := repository.NewOrderRepository()
-	productRepo := repository.NewProductRepository()
+func NewPaymentService(repo repository.PaymentRepo, orderRepo repository.OrderRepo, productRepo repository.ProductRepo, idempotency repository.IdempotencyRepo, cartService CartService, pricing PricingService, reservations ReservationService, addresses AddressService, gateways *gateway.Registry) PaymentService {
 	return &paymentService{
-		repo:        repo,
-		orderRepo:   orderRepo,
-		productRepo: productRepo,
+		repo:         repo,
+		idempotency:  idempotency,
+		orderRepo:    orderRepo,
+		productRepo:  productRepo,
+		cartService:  cartService,
+		pricing:      pricing,
+		reservations: reservations,
+		addresses:    addresses,
+		gateways:     gateways,
+	}
+}
+
+// CheckoutCart starts a payment for the cart stored on the server for the user
+func (s *paymentService) CheckoutCart(ctx context.Context, userId string, opts CheckoutOptions) (*gateway.InitiateResponse, *models.PriceQuote, error) {
+	// The key is checked before the cart is read, the cart is already empty when a finished checkout is retried
+	request := newCheckoutRequest(nil, opts)
+	result, err := withIdempotency(ctx, s.idempotency, "checkout", userId, opts.IdempotencyKey, request, func() (*checkoutResult, error) {
+		cartItems, err := s.cartItemsFromCart(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		return s.initiatePayment(ctx, userId, cartItems, opts)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return result.Payment, result.Quote, nil
 }
 
-func (s *paymentService) InitiatePayment(ctx context.Context, cartItems []CartItem) (string, error) {
-	// Extract product IDs for availability check
+// QuoteCart prices the stored cart without starting a payment
+func (s *paymentService) QuoteCart(ctx context.Context, userId string) (*models.PriceQuote, error) {
+	cartItems, err := s.cartItemsFromCart(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return s.quote(ctx, cartItems)
+}
+
+func (s *paymentService) cartItemsFromCart(ctx context.Context, userId string) ([]CartItem, error) {
+	cart, err := s.cartService.GetCart(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(cart.Lines) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	cartItems := make([]CartItem, 0, len(cart.Lines))
+	for _, line := range cart.Lines {
+		if !line.Available {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInsufficientStock, line.ProductID, line.Issue)
+		}
+		cartItems = append(cartItems, CartItem{
+			ID:       line.ProductID,
+			SellerID: line.SellerID,
+			Quantity: line.Quantity,
+			Name:     line.Name,
+		})
+	}
+	return cartItems, nil
+}
+
+// quote loads the products of the cart and prices them with the pricing engine
+func (s *paymentService) quote(ctx context.Context, cartItems []CartItem) (*models.PriceQuote, error) {
+	if len(cartItems) == 0 {
+		return nil, ErrEmptyCart
+	}
+
 	productIds := make([]string, 0, len(cartItems))
 	for _, item := range cartItems {
 		productIds = append(productIds, item.ID)
 	}
 
-	_, available, err := s.repo.CheckProductAvailability(ctx, productIds)
+	products, available, err := s.repo.CheckProductAvailability(ctx, productIds)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if !available {
-		return "", errors.New("some products are not available")
+		return nil, errors.New("some products are not available")
 	}
 
-	var amount int64
-	for _, item := range cartItems {
-		// Calculate total amount for this item (price * quantity)
-		itemTotal := item.Price * item.Quantity
-		amount += itemTotal
-	}
-
-	// Calculate tax and charges (you can modify these based on your business logic)
-	taxAmount := int64(0)
-	serviceCharge := int64(0)
-	deliveryCharge := int64(0)
-	totalAmount := amount + taxAmount + serviceCharge + deliveryCharge
-
-	// Ensure amount is at least 1 (eSewa requirement)
-	if totalAmount < 1 {
-		totalAmount = 1
-	}
-
-	paymentData := PaymentData{
-		Amount:                strconv.FormatInt(amount, 10),
-		TaxAmount:             strconv.FormatInt(taxAmount, 10),
-		ProductServiceCharge:  strconv.FormatInt(serviceCharge, 10),
-		ProductDeliveryCharge: strconv.FormatInt(deliveryCharge, 10),
-		TotalAmount:           strconv.FormatInt(totalAmount, 10),
-		TransactionUUID:       utils.GenerateEsewaTransactionUUID(),
-		ProductCode:           config.AppConfig.EsewaMerchantCode,
-		SuccessURL:            config.AppConfig.ESewaSuccessURL,
-		FailureURL:            config.AppConfig.ESewaFailedURL,
-		SignedFieldNames:      "total_amount,transaction_uuid,product_code",
-	}
-
-	// Generate signature - order matters: total_amount,transaction_uuid,product_code
-	signature := utils.GenerateEsewaSignature(paymentData.TotalAmount, paymentData.TransactionUUID, paymentData.ProductCode, config.AppConfig.EsewaSecretKey)
-	if signature == "" {
-		return "", errors.New("failed to generate signature")
-	}
-
-	// Debug: Print payment data for troubleshooting (remove in production)
-	fmt.Printf("DEBUG: Payment Data:\n")
-	fmt.Printf("  Amount: %s\n", paymentData.Amount)
-	fmt.Printf("  Tax Amount: %s\n", paymentData.TaxAmount)
-	fmt.Printf("  Service Charge: %s\n", paymentData.ProductServiceCharge)
-	fmt.Printf("  Delivery Charge: %s\n", paymentData.ProductDeliveryCharge)
-	fmt.Printf("  Total Amount: %s\n", paymentData.TotalAmount)
-	fmt.Printf("  Transaction UUID: %s\n", paymentData.TransactionUUID)
-	fmt.Printf("  Product Code: %s\n", paymentData.ProductCode)
-	fmt.Printf("  Success URL: %s\n", paymentData.SuccessURL)
-	fmt.Printf("  Failure URL: %s\n", paymentData.FailureURL)
-	fmt.Printf("  Signed Field Names: %s\n", paymentData.SignedFieldNames)
-	fmt.Printf("  Signature: %s\n", signature)
+	return s.pricing.Quote(cartItems, products)
+}
 
-	// Create payment record first
+func (s *paymentService) InitiatePayment(ctx context.Context, cartItems []CartItem, opts CheckoutOptions) (*gateway.InitiateResponse, *models.PriceQuote, error) {
 	userId, _, _, ok := middleware.GetUserFromContext(ctx.(*gin.Context))
 	if !ok {
-		return "", errors.New("user not found")
+		return nil, nil, errors.New("user not found")
+	}
+
+	request := newCheckoutRequest(cartItems, opts)
+	result, err := withIdempotency(ctx, s.idempotency, "checkout", userId, opts.IdempotencyKey, request, func() (*checkoutResult, error) {
+		return s.initiatePayment(ctx, userId, cartItems, opts)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Payment, result.Quote, nil
+}
+
+// checkoutRequest is the normalized form of a checkout, a reused idempotency key must come with the same one.
+// Items is empty for a checkout of the stored cart
+type checkoutRequest struct {
+	Provider          string             `json:"provider"`
+	Items             []checkoutItemSpec `json:"items,omitempty"`
+	ShippingAddressID string             `json:"shippingAddressId"`
+	BillingAddressID  string             `json:"billingAddressId"`
+}
+
+type checkoutItemSpec struct {
+	ID       string `json:"id"`
+	Quantity int64  `json:"quantity"`
+}
+
+// newCheckoutRequest keeps only what is priced, client prices and names are ignored by the quote anyway
+func newCheckoutRequest(cartItems []CartItem, opts CheckoutOptions) checkoutRequest {
+	request := checkoutRequest{
+		Provider:          strings.ToLower(strings.TrimSpace(opts.Provider)),
+		ShippingAddressID: opts.ShippingAddressID,
+		BillingAddressID:  opts.BillingAddressID,
+	}
+	for _, item := range cartItems {
+		request.Items = append(request.Items, checkoutItemSpec{ID: item.ID, Quantity: item.Quantity})
+	}
+	sort.Slice(request.Items, func(i, j int) bool {
+		if request.Items[i].ID != request.Items[j].ID {
+			return request.Items[i].ID < request.Items[j].ID
+		}
+		return request.Items[i].Quantity < request.Items[j].Quantity
+	})
+	return request
+}
+
+// checkoutResult is what a started payment returns, and what a retry with the same idempotency key gets back
+type checkoutResult struct {
+	Payment *gateway.InitiateResponse `json:"payment"`
+	Quote   *models.PriceQuote        `json:"quote"`
+}
+
+func (s *paymentService) initiatePayment(ctx context.Context, userId string, cartItems []CartItem, opts CheckoutOptions) (*checkoutResult, error) {
+	paymentGateway, err := s.gateways.Get(opts.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prices and quantities are recomputed from the product data, client prices are ignored
+	quote, err := s.quote(ctx, cartItems)
+	if err != nil {
+		return nil, err
+	}
+
+	// The addresses are copied now, the order is shipped where the customer chose at checkout
+	shippingAddress, err := s.addresses.CheckoutAddress(ctx, userId, opts.ShippingAddressID, models.AddressShipping)
+	if err != nil {
+		return nil, err
+	}
+	billingAddress, err := s.addresses.CheckoutAddress(ctx, userId, opts.BillingAddressID, models.AddressBilling)
+	if err != nil {
+		return nil, err
+	}
+	if billingAddress == nil {
+		billingAddress = shippingAddress
 	}
 
+	productIds := make([]string, 0, len(quote.Lines))
+	paymentItems := make([]models.PaymentItem, 0, len(quote.Lines))
+	for _, line := range quote.Lines {
+		productIds = append(productIds, line.ProductID)
+		paymentItems = append(paymentItems, models.PaymentItem{
+			ProductID:       line.ProductID,
+			SellerID:        line.SellerID,
+			Name:            line.Name,
+			Quantity:        line.Quantity,
+			UnitPrice:       line.UnitPrice,
+			DiscountPercent: line.DiscountPercent,
+			Discount:        line.Discount,
+			Total:           line.Total,
+		})
+	}
+
+	// Hold the stock back before the customer is sent to pay,
+	// it is released again when the payment does not complete in time
+	transactionUuid := utils.GenerateEsewaTransactionUUID()
+	if _, err := s.reservations.Reserve(ctx, userId, transactionUuid, quote.Lines); err != nil {
+		return nil, err
+	}
+
+	// Create payment record first
 	paymentRecord := models.Payment{
 		ID:              utils.GenerateRandomUUID(),
-		Amount:          totalAmount,
+		Amount:          quote.Total,
 		UserId:          userId,
-		TransactionUuid: paymentData.TransactionUUID,
+		TransactionUuid: transactionUuid,
 		ProductIDs:      productIds,
+		Items:           paymentItems,
+		ShippingAddress: shippingAddress,
+		BillingAddress:  billingAddress,
+		Provider:        paymentGateway.Name(),
 		Status:          models.PaymentStatusPending,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
@@ -160,191 +283,265 @@ func (s *paymentService) InitiatePayment(ctx context.Context, cartItems []CartIt
 
 	err = s.repo.CreatePayment(ctx, &paymentRecord)
 	if err != nil {
-		return "", err
+		s.releaseReservation(ctx, &paymentRecord, models.ReservationReleased, "payment could not be created")
+		return nil, err
+	}
+
+	response, err := paymentGateway.Initiate(ctx, &gateway.InitiateRequest{
+		TransactionUUID: paymentRecord.TransactionUuid,
+		UserID:          userId,
+		Amount:          quote.Amount(),
+		TaxAmount:       quote.Tax,
+		ServiceCharge:   quote.ServiceCharge,
+		DeliveryCharge:  quote.Delivery,
+		TotalAmount:     quote.Total,
+		Items:           paymentItems,
+	})
+	if err != nil {
+		if _, failErr := s.repo.MarkPaymentFailed(ctx, paymentRecord.ID, fmt.Sprintf("initiation failed: %v", err)); failErr != nil {
+			fmt.Printf("WARNING: failed to mark payment %s as failed: %v\n", paymentRecord.ID, failErr)
+		}
+		s.releaseReservation(ctx, &paymentRecord, models.ReservationReleased, "payment initiation failed")
+		return nil, err
 	}
 
-	// For eSewa, we need to submit a form to their endpoint
-	// The response will be a redirect, so we need to handle it properly
-	// Order matters for eSewa - follow their exact specification
-	form := url.Values{}
-
-	// Add parameters in the exact order specified by eSewa
-	form.Set("amount", paymentData.Amount)
-	form.Set("tax_amount", paymentData.TaxAmount)
-	form.Set("product_service_charge", paymentData.ProductServiceCharge)
-	form.Set("product_delivery_charge", paymentData.ProductDeliveryCharge)
-	form.Set("total_amount", paymentData.TotalAmount)
-	form.Set("transaction_uuid", paymentData.TransactionUUID)
-	form.Set("product_code", paymentData.ProductCode)
-	form.Set("success_url", paymentData.SuccessURL)
-	form.Set("failure_url", paymentData.FailureURL)
-	form.Set("signed_field_names", paymentData.SignedFieldNames)
-	form.Set("signature", signature)
-
-	// Debug: Print the form data being sent (remove in production)
-	fmt.Printf("DEBUG: Form data being sent:\n")
-	for key, values := range form {
-		fmt.Printf("  %s: %v\n", key, values)
+	if response.SessionID != "" {
+		if err := s.repo.SetPaymentSessionID(ctx, paymentRecord.ID, response.SessionID); err != nil {
+			return nil, fmt.Errorf("failed to store payment session: %v", err)
+		}
 	}
 
-	// Make POST request to eSewa
-	response, err := http.Post(config.AppConfig.ESewaPaymentURL, "application/x-www-form-urlencoded", bytes.NewBufferString(form.Encode()))
-	fmt.Printf("DEBUG: Response: %v\n", response)
-	fmt.Printf("DEBUG: Error: %v\n", err)
+	log.Printf("💳 Initiated %s payment %s for %d", paymentGateway.Name(), paymentRecord.TransactionUuid, quote.Total)
+	return &checkoutResult{Payment: response, Quote: quote}, nil
+}
+
+// CheckPaymentStatus asks the provider of the payment for its current state
+func (s *paymentService) CheckPaymentStatus(ctx context.Context, transactionUUID string) (*gateway.StatusResult, error) {
+	payment, err := s.repo.GetPaymentByTransactionUUID(ctx, transactionUUID)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to get payment: %v", err)
 	}
-	defer response.Body.Close()
+	return s.checkGatewayStatus(ctx, payment)
+}
 
-	// Read response body to check for any error messages
-	body, err := io.ReadAll(response.Body)
+func (s *paymentService) checkGatewayStatus(ctx context.Context, payment *models.Payment) (*gateway.StatusResult, error) {
+	paymentGateway, err := s.paymentGateway(payment)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
+		return nil, err
 	}
 
-	// Check if response contains error
-	if response.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("eSewa returned status %d: %s", response.StatusCode, string(body))
-	}
+	return paymentGateway.CheckStatus(ctx, &gateway.StatusRequest{
+		TransactionUUID: payment.TransactionUuid,
+		SessionID:       payment.GatewaySessionID,
+		TotalAmount:     payment.Amount,
+	})
+}
 
-	// For eSewa, the response is typically a redirect or HTML form
-	// We need to extract the payment URL from the response
-	// If it's a redirect, get the Location header
-	if location := response.Header.Get("Location"); location != "" {
-		return location, nil
+// storedSession returns the provider session id a payment was initiated with
+func (s *paymentService) storedSession(ctx context.Context, transactionUUID string) (string, error) {
+	payment, err := s.repo.GetPaymentByTransactionUUID(ctx, transactionUUID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get payment: %v", err)
 	}
+	return payment.GatewaySessionID, nil
+}
 
-	// If no redirect, check if response contains a payment URL
-	// This is a fallback - eSewa usually redirects
-	if response.Request != nil && response.Request.URL != nil {
-		return response.Request.URL.String(), nil
+// paymentGateway returns the gateway a payment was started with,
+// payments from before providers were stored all went through eSewa
+func (s *paymentService) paymentGateway(payment *models.Payment) (gateway.PaymentGateway, error) {
+	provider := payment.Provider
+	if provider == "" {
+		provider = "esewa"
 	}
-
-	// If we can't get a direct URL, return the eSewa payment URL with parameters
-	// The frontend can use this to submit the form
-	paymentURL := fmt.Sprintf("%s?amount=%s&tax_amount=%s&product_service_charge=%s&product_delivery_charge=%s&total_amount=%s&transaction_uuid=%s&product_code=%s&success_url=%s&failure_url=%s&signed_field_names=%s&signature=%s",
-		config.AppConfig.ESewaPaymentURL,
-		paymentData.Amount,
-		paymentData.TaxAmount,
-		paymentData.ProductServiceCharge,
-		paymentData.ProductDeliveryCharge,
-		paymentData.TotalAmount,
-		paymentData.TransactionUUID,
-		paymentData.ProductCode,
-		paymentData.SuccessURL,
-		paymentData.FailureURL,
-		paymentData.SignedFieldNames,
-		signature,
-	)
-
-	return paymentURL, nil
+	return s.gateways.Get(provider)
 }
 
-// CheckPaymentStatus checks the status of a payment using eSewa's status check API
-func (s *paymentService) CheckPaymentStatus(ctx context.Context, transactionUUID, productCode, totalAmount string) (*PaymentStatusResponse, error) {
-	// Build status check URL
-	statusURL := fmt.Sprintf("%s?product_code=%s&total_amount=%s&transaction_uuid=%s",
-		config.AppConfig.EsewaPaymentStatusCheckURL,
-		productCode,
-		totalAmount,
-		transactionUUID,
-	)
-
-	// Make GET request to status check API
-	response, err := http.Get(statusURL)
+// CreateOrderFromPayment creates an order from a successful payment
+func (s *paymentService) CreateOrderFromPayment(ctx context.Context, payment *models.Payment) (*models.Order, error) {
+	orderItems, err := s.orderItemsFromPayment(ctx, payment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check payment status: %v", err)
+		return nil, err
 	}
-	defer response.Body.Close()
 
-	// Read response body
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+	// The stock reserved at checkout is sold now, before the order exists so a
+	// retry after a failed order insert can not lose it to the expiry job
+	if err := s.reservations.Commit(ctx, payment.TransactionUuid, orderItems); err != nil {
+		if errors.Is(err, ErrInsufficientStock) {
+			s.refundUnfulfillable(ctx, payment, err)
+		}
+		return nil, err
+	}
+
+	// Create the order, split into one sub-order per seller
+	created := models.OrderStatusChange{
+		To:     models.OrderStatusCreated,
+		Actor:  models.OrderActorSystem,
+		Reason: fmt.Sprintf("payment %s verified", payment.TransactionUuid),
+		At:     time.Now(),
+	}
+	order := &models.Order{
+		ID:              utils.GenerateRandomUUID(),
+		User:            payment.UserId,
+		Amount:          payment.Amount,
+		Products:        orderItems,
+		TransactionID:   payment.TransactionUuid,
+		Status:          models.OrderStatusCreated,
+		StatusHistory:   []models.OrderStatusChange{created},
+		ShippingAddress: payment.ShippingAddress,
+		BillingAddress:  payment.BillingAddress,
+		SellerOrders:    models.NewSellerOrders(orderItems, created),
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
 	}
 
-	// Parse JSON response
-	var statusResponse PaymentStatusResponse
-	if err := json.Unmarshal(body, &statusResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse status response: %v", err)
+	// Save the order to database, the unique transactionId index makes this happen at most once per payment
+	err = s.orderRepo.CreateOrder(ctx, order)
+	if errors.Is(err, repository.ErrOrderExists) {
+		return s.orderRepo.GetOrderByTransactionID(ctx, payment.TransactionUuid)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %v", err)
 	}
 
-	return &statusResponse, nil
+	fmt.Printf("DEBUG: Order created successfully with ID: %s\n", order.ID)
+	return order, nil
 }
 
-// CreateOrderFromPayment creates an order from a successful payment
-func (s *paymentService) CreateOrderFromPayment(ctx context.Context, payment *models.Payment) (*models.Order, error) {
-	// Validate payment data
-	if payment.ProductIDs == nil || len(payment.ProductIDs) == 0 {
+// orderItemsFromPayment builds the order lines from the items snapshotted on the payment
+func (s *paymentService) orderItemsFromPayment(ctx context.Context, payment *models.Payment) ([]models.ProductItem, error) {
+	if len(payment.Items) > 0 {
+		orderItems := make([]models.ProductItem, 0, len(payment.Items))
+		for _, item := range payment.Items {
+			orderItems = append(orderItems, models.ProductItem{
+				ProductID:       item.ProductID,
+				SellerID:        item.SellerID,
+				Name:            item.Name,
+				Quantity:        item.Quantity,
+				Price:           item.UnitPrice,
+				DiscountPercent: item.DiscountPercent,
+				Discount:        item.Discount,
+				Total:           item.Total,
+			})
+		}
+		return orderItems, nil
+	}
+
+	// Payments created before line items were stored only know the product IDs
+	if len(payment.ProductIDs) == 0 {
 		return nil, fmt.Errorf("payment has no product IDs")
 	}
 
-	// Get product details for each product ID
 	orderItems := make([]models.ProductItem, 0, len(payment.ProductIDs))
 	for _, productID := range payment.ProductIDs {
-		product, _, err := s.productRepo.GetProductByID(ctx, productID)
+		product, err := s.productRepo.GetProductByID(ctx, productID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get product details: %v", err)
 		}
 
-		orderItem := models.ProductItem{
+		orderItems = append(orderItems, models.ProductItem{
 			ProductID: product.ID,
-			SellerID:  product.SellerID, // Include seller ID in order item
-			Quantity:  1,                // Default quantity, you can modify this based on your cart logic
+			SellerID:  product.SellerID,
+			Name:      product.Name,
+			Quantity:  1,
 			Price:     int64(product.Price),
-		}
-		orderItems = append(orderItems, orderItem)
+			Total:     int64(product.Price),
+		})
 	}
+	return orderItems, nil
+}
 
-	// Create the order
-	order := &models.Order{
-		ID:            utils.GenerateRandomUUID(),
-		User:          payment.UserId,
-		Amount:        payment.Amount,
-		Products:      orderItems,
-		TransactionID: payment.TransactionUuid,
-		Status:        models.OrderStatusCreated,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
-	}
+// ProcessSuccessfulPayment verifies the data the provider sent to the success url and
+// only then marks the payment as successful and creates the order.
+// Retrying it for an already processed payment returns the original order
+func (s *paymentService) ProcessSuccessfulPayment(ctx context.Context, userId string, req ProcessPaymentRequest) (*models.Order, error) {
+	request := ProcessPaymentRequest{Provider: strings.ToLower(strings.TrimSpace(req.Provider)), Params: req.Params}
+	return withIdempotency(ctx, s.idempotency, "process-payment", userId, req.IdempotencyKey, request, func() (*models.Order, error) {
+		return s.processSuccessfulPayment(ctx, userId, req)
+	})
+}
 
-	// Save the order to database
-	err := s.orderRepo.CreateOrder(ctx, order)
+func (s *paymentService) processSuccessfulPayment(ctx context.Context, userId string, req ProcessPaymentRequest) (*models.Order, error) {
+	paymentGateway, err := s.gateways.Get(req.Provider)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create order: %v", err)
+		return nil, err
 	}
 
-	// Decrease product stock for each product in the order
-	err = s.decreaseProductStock(ctx, orderItems)
+	// A payload that fails verification can not be trusted, so it is rejected without touching the payment
+	callback, err := paymentGateway.VerifyCallback(ctx, req.Params, s.storedSession)
 	if err != nil {
-		fmt.Printf("WARNING: Failed to decrease product stock: %v\n", err)
-		// Don't fail the order creation if stock update fails
-	} else {
-		fmt.Printf("DEBUG: Successfully decreased product stock for order\n")
+		if errors.Is(err, gateway.ErrInvalidCallback) {
+			fmt.Printf("WARNING: rejected %s callback: %v\n", paymentGateway.Name(), err)
+			return nil, fmt.Errorf("%w: %v", ErrPaymentVerificationFailed, err)
+		}
+		return nil, err
 	}
 
-	fmt.Printf("DEBUG: Order created successfully with ID: %s\n", order.ID)
-	return order, nil
-}
-
-// ProcessSuccessfulPayment handles successful payment by updating payment status and creating order
-func (s *paymentService) ProcessSuccessfulPayment(ctx context.Context, transactionUUID string) (*models.Order, error) {
 	// Get the payment record
-	payment, err := s.repo.GetPaymentByTransactionUUID(ctx, transactionUUID)
+	payment, err := s.repo.GetPaymentByTransactionUUID(ctx, callback.TransactionUUID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get payment: %v", err)
 	}
+	if payment.UserId != userId {
+		return nil, fmt.Errorf("%w: payment belongs to another user", ErrPaymentVerificationFailed)
+	}
+	if expected, err := s.paymentGateway(payment); err != nil || expected.Name() != paymentGateway.Name() {
+		return nil, fmt.Errorf("%w: payment was not made with %s", ErrPaymentVerificationFailed, paymentGateway.Name())
+	}
+
+	// A payment that was already settled is never verified or charged twice
+	if payment.Status != models.PaymentStatusPending {
+		return s.settledPaymentOrder(ctx, payment)
+	}
+
+	if callback.Status != gateway.StatusComplete {
+		return nil, s.failPayment(ctx, payment, fmt.Sprintf("%s reported status %s", paymentGateway.Name(), callback.RawStatus))
+	}
+	if !amountMatches(callback.TotalAmount, payment.Amount) {
+		return nil, s.failPayment(ctx, payment, fmt.Sprintf("callback amount %.2f does not match payment amount %d", callback.TotalAmount, payment.Amount))
+	}
 
-	// Update payment status to success
-	err = s.repo.UpdatePaymentStatus(ctx, payment.ID, models.PaymentStatusSuccess)
+	// Confirm with the provider itself, the callback alone is not enough
+	statusResult, err := s.checkGatewayStatus(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	switch statusResult.Status {
+	case gateway.StatusComplete:
+	case gateway.StatusPending:
+		return nil, fmt.Errorf("payment %s is still %s at %s", payment.TransactionUuid, statusResult.RawStatus, paymentGateway.Name())
+	default:
+		return nil, s.failPayment(ctx, payment, fmt.Sprintf("%s status check returned %s", paymentGateway.Name(), statusResult.RawStatus))
+	}
+	if !amountMatches(statusResult.TotalAmount, payment.Amount) {
+		return nil, s.failPayment(ctx, payment, fmt.Sprintf("%s amount %.2f does not match payment amount %d", paymentGateway.Name(), statusResult.TotalAmount, payment.Amount))
+	}
+
+	gatewayRef := callback.GatewayRef
+	if gatewayRef == "" {
+		gatewayRef = statusResult.GatewayRef
+	}
+	return s.completePayment(ctx, payment, gatewayRef)
+}
+
+// completePayment marks a verified payment as successful and creates its order
+func (s *paymentService) completePayment(ctx context.Context, payment *models.Payment, gatewayRef string) (*models.Order, error) {
+	// Update payment status to success, only one concurrent request can move it out of pending
+	transitioned, err := s.repo.MarkPaymentSuccessful(ctx, payment.ID, gatewayRef)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update payment status: %v", err)
 	}
+	if !transitioned {
+		current, err := s.repo.GetPaymentByTransactionUUID(ctx, payment.TransactionUuid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get payment: %v", err)
+		}
+		return s.settledPaymentOrder(ctx, current)
+	}
 
 	// Create order from successful payment
 	order, err := s.CreateOrderFromPayment(ctx, payment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create order: %v", err)
+		return nil, fmt.Errorf("failed to create order: %w", err)
 	}
 
 	// Clear the user's cart after successful order creation
@@ -360,63 +557,243 @@ func (s *paymentService) ProcessSuccessfulPayment(ctx context.Context, transacti
 	return order, nil
 }
 
-// PaymentStatusResponse represents the response from eSewa status check API
-type PaymentStatusResponse struct {
-	ProductCode     string  `json:"product_code"`
-	TransactionUUID string  `json:"transaction_uuid"`
-	TotalAmount     float64 `json:"total_amount"`
-	Status          string  `json:"status"`
-	RefID           *string `json:"ref_id"`
+// refundUnfulfillable gives back a payment whose stock was sold while its reservation had run out
+func (s *paymentService) refundUnfulfillable(ctx context.Context, payment *models.Payment, cause error) {
+	amount := payment.Amount - payment.RefundedAmount
+	if amount < 1 {
+		return
+	}
+	if _, err := s.RefundPayment(ctx, payment.TransactionUuid, amount, cause.Error()); err != nil && !errors.Is(err, gateway.ErrRefundNotSupported) {
+		fmt.Printf("WARNING: failed to refund payment %s without stock: %v\n", payment.TransactionUuid, err)
+	}
 }
 
-// restoreProductStock restores product stock when order is cancelled
-func (s *paymentService) restoreProductStock(ctx context.Context, products []models.ProductItem) error {
-	for _, item := range products {
-		// Get current product stock
-		product, _, err := s.productRepo.GetProductByID(ctx, item.ProductID)
-		if err != nil {
-			fmt.Printf("WARNING: Failed to get product %s: %v\n", item.ProductID, err)
-			continue
-		}
+// RefundPayment gives amount back through the provider of the payment and records it on the payment.
+// Providers without a refund api return gateway.ErrRefundNotSupported, the amount then stays
+// recorded as refunded and has to be paid out from the provider's merchant portal
+func (s *paymentService) RefundPayment(ctx context.Context, transactionUUID string, amount int64, reason string) (*gateway.RefundResult, error) {
+	if amount < 1 {
+		return nil, fmt.Errorf("%w: refund amount must be at least 1", ErrRefundNotAllowed)
+	}
 
-		// Restore stock
-		newStock := product.Stock + int(item.Quantity)
-		err = s.productRepo.UpdateProductStock(ctx, product.SellerID, item.ProductID, newStock)
-		if err != nil {
-			fmt.Printf("WARNING: Failed to restore stock for product %s: %v\n", item.ProductID, err)
+	payment, err := s.repo.GetPaymentByTransactionUUID(ctx, transactionUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %v", err)
+	}
+	paymentGateway, err := s.paymentGateway(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reserve the amount first so concurrent refunds can never exceed what was paid
+	reserved, err := s.repo.AdjustRefundedAmount(ctx, payment.ID, amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record refund: %v", err)
+	}
+	if !reserved {
+		return nil, fmt.Errorf("%w: payment %s is %s with %d of %d refunded", ErrRefundNotAllowed, payment.TransactionUuid, payment.Status, payment.RefundedAmount, payment.Amount)
+	}
+
+	result, err := paymentGateway.Refund(ctx, &gateway.RefundRequest{
+		TransactionUUID: payment.TransactionUuid,
+		SessionID:       payment.GatewaySessionID,
+		GatewayRef:      payment.GatewayRef,
+		Amount:          amount,
+		TotalAmount:     payment.Amount,
+		Reason:          reason,
+	})
+	if err != nil && !errors.Is(err, gateway.ErrRefundNotSupported) {
+		// Nothing was paid out, give the reserved amount back
+		if _, releaseErr := s.repo.AdjustRefundedAmount(ctx, payment.ID, -amount); releaseErr != nil {
+			fmt.Printf("WARNING: failed to release refund of %d on payment %s: %v\n", amount, payment.ID, releaseErr)
 		}
+		return nil, fmt.Errorf("%s refund failed: %w", paymentGateway.Name(), err)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	log.Printf("💸 Refunded %d of payment %s through %s", amount, payment.TransactionUuid, paymentGateway.Name())
+	return result, nil
 }
 
-// decreaseProductStock decreases product stock when order is created
-func (s *paymentService) decreaseProductStock(ctx context.Context, orderItems []models.ProductItem) error {
-	for _, item := range orderItems {
-		// Get current product stock
-		product, _, err := s.productRepo.GetProductByID(ctx, item.ProductID)
+func (s *paymentService) ReconcilePayment(ctx context.Context, payment *models.Payment, ttl time.Duration) *models.ReconciliationLog {
+	age := time.Since(payment.CreatedAt)
+	entry := &models.ReconciliationLog{
+		ID:              utils.GenerateRandomUUID(),
+		PaymentID:       payment.ID,
+		TransactionUuid: payment.TransactionUuid,
+		UserID:          payment.UserId,
+		Provider:        payment.Provider,
+		Amount:          payment.Amount,
+		PaymentAge:      age.Round(time.Second).String(),
+		CreatedAt:       time.Now(),
+	}
+	expired := ttl > 0 && age > ttl
+
+	statusResult, err := s.checkGatewayStatus(ctx, payment)
+	if err != nil {
+		entry.Decision = models.ReconciliationError
+		entry.Message = fmt.Sprintf("status check failed: %v", err)
+		return entry
+	}
+	entry.GatewayStatus = statusResult.RawStatus
+	if entry.GatewayStatus == "" {
+		entry.GatewayStatus = string(statusResult.Status)
+	}
+	entry.GatewayAmount = statusResult.TotalAmount
+
+	switch statusResult.Status {
+	case gateway.StatusComplete:
+		if !amountMatches(statusResult.TotalAmount, payment.Amount) {
+			entry.Decision = models.ReconciliationFailed
+			entry.Message = fmt.Sprintf("provider amount %.2f does not match payment amount %d", statusResult.TotalAmount, payment.Amount)
+			s.recordFailure(ctx, payment, entry)
+			return entry
+		}
+
+		order, err := s.completePayment(ctx, payment, statusResult.GatewayRef)
 		if err != nil {
-			fmt.Printf("WARNING: Failed to get product %s: %v\n", item.ProductID, err)
-			continue
+			entry.Decision = models.ReconciliationError
+			entry.Message = fmt.Sprintf("failed to complete payment: %v", err)
+			return entry
+		}
+		entry.Decision = models.ReconciliationCompleted
+		entry.OrderID = order.ID
+		entry.Message = "paid at the provider, order created"
+
+	case gateway.StatusPending, gateway.StatusNotFound:
+		// The customer may still be on the provider's page until the TTL runs out
+		if !expired {
+			entry.Decision = models.ReconciliationPending
+			entry.Message = fmt.Sprintf("still %s at the provider, checking again later", statusResult.Status)
+			return entry
 		}
 
-		// Check if enough stock is available
-		if product.Stock < int(item.Quantity) {
-			fmt.Printf("WARNING: Insufficient stock for product %s. Available: %d, Required: %d\n",
-				item.ProductID, product.Stock, item.Quantity)
-			continue
+		entry.Decision = models.ReconciliationExpired
+		entry.Message = fmt.Sprintf("%s at the provider after %s", statusResult.Status, ttl)
+		if _, err := s.repo.MarkPaymentExpired(ctx, payment.ID, entry.Message); err != nil {
+			entry.Decision = models.ReconciliationError
+			entry.Message = fmt.Sprintf("failed to expire payment: %v", err)
+			return entry
 		}
+		s.releaseReservation(ctx, payment, models.ReservationExpired, entry.Message)
 
-		// Decrease stock
-		newStock := product.Stock - int(item.Quantity)
-		err = s.productRepo.UpdateProductStock(ctx, product.SellerID, item.ProductID, newStock)
-		if err != nil {
-			fmt.Printf("WARNING: Failed to decrease stock for product %s: %v\n", item.ProductID, err)
-		} else {
-			fmt.Printf("DEBUG: Decreased stock for product %s from %d to %d\n",
-				item.ProductID, product.Stock, newStock)
+	default:
+		entry.Decision = models.ReconciliationFailed
+		entry.Message = fmt.Sprintf("provider reported %s", entry.GatewayStatus)
+		s.recordFailure(ctx, payment, entry)
+	}
+
+	return entry
+}
+
+// recordFailure marks a reconciled payment as failed, turning the log entry into an error when that fails
+func (s *paymentService) recordFailure(ctx context.Context, payment *models.Payment, entry *models.ReconciliationLog) {
+	if _, err := s.repo.MarkPaymentFailed(ctx, payment.ID, entry.Message); err != nil {
+		entry.Decision = models.ReconciliationError
+		entry.Message = fmt.Sprintf("failed to mark payment as failed: %v", err)
+		return
+	}
+	s.releaseReservation(ctx, payment, models.ReservationReleased, entry.Message)
+}
+
+// settledPaymentOrder returns the order of a payment an earlier request already settled.
+// The order is created here when that request stopped between the payment and the order update
+func (s *paymentService) settledPaymentOrder(ctx context.Context, payment *models.Payment) (*models.Order, error) {
+	if payment.Status == models.PaymentStatusFailed {
+		return nil, fmt.Errorf("%w: payment already failed: %s", ErrPaymentVerificationFailed, payment.FailureReason)
+	}
+
+	order, err := s.orderRepo.GetOrderByTransactionID(ctx, payment.TransactionUuid)
+	if err == nil {
+		return order, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to get order: %v", err)
+	}
+
+	if payment.Status != models.PaymentStatusSuccess {
+		return nil, fmt.Errorf("payment %s is %s and has no order", payment.TransactionUuid, payment.Status)
+	}
+	return s.CreateOrderFromPayment(ctx, payment)
+}
+
+// failPayment records why a payment was rejected and returns the matching verification error
+func (s *paymentService) failPayment(ctx context.Context, payment *models.Payment, reason string) error {
+	fmt.Printf("WARNING: payment %s failed verification: %s\n", payment.TransactionUuid, reason)
+	if _, err := s.repo.MarkPaymentFailed(ctx, payment.ID, reason); err != nil {
+		return fmt.Errorf("failed to mark payment as failed: %v", err)
+	}
+	s.releaseReservation(ctx, payment, models.ReservationReleased, reason)
+	return fmt.Errorf("%w: %s", ErrPaymentVerificationFailed, reason)
+}
+
+// releaseReservation gives back the stock of a payment that will not complete
+func (s *paymentService) releaseReservation(ctx context.Context, payment *models.Payment, status models.ReservationStatus, reason string) {
+	if err := s.reservations.Release(ctx, payment.TransactionUuid, status, reason); err != nil {
+		fmt.Printf("WARNING: failed to release stock of payment %s: %v\n", payment.TransactionUuid, err)
+	}
+}
+
+// amountMatches compares an amount reported by a provider with a stored amount in rupees
+func amountMatches(reported float64, amount int64) bool {
+	return math.Abs(reported-float64(amount)) <= 0.005
+}
+
+var ErrPaymentVerificationFailed = errors.New("payment verification failed")
+
+var ErrRefundNotAllowed = errors.New("refund not allowed")
+
+// withIdempotency runs fn once per idempotency key and user, retries get the stored result back.
+// request is the normalized request, a key sent again with a different one is rejected.
+// Requests without a key always run fn
+func withIdempotency[T any](ctx context.Context, store repository.IdempotencyRepo, scope, userId, key string, request any, fn func() (*T, error)) (*T, error) {
+	if key == "" {
+		return fn()
+	}
+
+	requestHash, err := hashRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	stored, reserved, err := store.Reserve(ctx, scope, userId, key, requestHash)
+	if err != nil {
+		return nil, err
+	}
+	if !reserved {
+		var result T
+		if err := json.Unmarshal([]byte(stored), &result); err != nil {
+			return nil, fmt.Errorf("failed to decode stored result: %v", err)
+		}
+		return &result, nil
+	}
+
+	result, err := fn()
+	if err != nil {
+		// Failed requests may be retried with the same key
+		if releaseErr := store.Release(ctx, scope, userId, key); releaseErr != nil {
+			fmt.Printf("WARNING: %v\n", releaseErr)
 		}
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode result: %v", err)
+	}
+	if err := store.Save(ctx, scope, userId, key, requestHash, string(encoded)); err != nil {
+		fmt.Printf("WARNING: %v\n", err)
 	}
+	return result, nil
+}
 
-	return nil
+// hashRequest fingerprints a normalized request, json sorts map keys so equal requests hash equally
+func hashRequest(request any) (string, error) {
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %v", err)
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
 }
@@ -0,0 +1,427 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"e-commerce.com/internal/gateway"
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakePaymentRepo keeps payments in memory, methods the checkout does not use panic through the nil interface
+type fakePaymentRepo struct {
+	repository.PaymentRepo
+
+	mu       sync.Mutex
+	products []*models.Product
+	payments map[string]*models.Payment
+}
+
+func newFakePaymentRepo(products ...*models.Product) *fakePaymentRepo {
+	return &fakePaymentRepo{products: products, payments: make(map[string]*models.Payment)}
+}
+
+func (r *fakePaymentRepo) CreatePayment(ctx context.Context, payment *models.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *payment
+	r.payments[payment.TransactionUuid] = &stored
+	return nil
+}
+
+func (r *fakePaymentRepo) CheckProductAvailability(ctx context.Context, productIds []string) ([]*models.Product, bool, error) {
+	var found []*models.Product
+	for _, id := range productIds {
+		for _, product := range r.products {
+			if product.ID == id {
+				found = append(found, product)
+			}
+		}
+	}
+	return found, len(found) == len(productIds), nil
+}
+
+func (r *fakePaymentRepo) GetPaymentByTransactionUUID(ctx context.Context, transactionUUID string) (*models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payment, ok := r.payments[transactionUUID]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	copied := *payment
+	return &copied, nil
+}
+
+func (r *fakePaymentRepo) byID(paymentID string) *models.Payment {
+	for _, payment := range r.payments {
+		if payment.ID == paymentID {
+			return payment
+		}
+	}
+	return nil
+}
+
+func (r *fakePaymentRepo) SetPaymentSessionID(ctx context.Context, paymentID, sessionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byID(paymentID).GatewaySessionID = sessionID
+	return nil
+}
+
+func (r *fakePaymentRepo) markPending(paymentID string, status models.PaymentStatus, apply func(*models.Payment)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payment := r.byID(paymentID)
+	if payment == nil || payment.Status != models.PaymentStatusPending {
+		return false
+	}
+	payment.Status = status
+	apply(payment)
+	return true
+}
+
+func (r *fakePaymentRepo) MarkPaymentSuccessful(ctx context.Context, paymentID, gatewayRef string) (bool, error) {
+	return r.markPending(paymentID, models.PaymentStatusSuccess, func(p *models.Payment) { p.GatewayRef = gatewayRef }), nil
+}
+
+func (r *fakePaymentRepo) MarkPaymentFailed(ctx context.Context, paymentID, reason string) (bool, error) {
+	return r.markPending(paymentID, models.PaymentStatusFailed, func(p *models.Payment) { p.FailureReason = reason }), nil
+}
+
+func (r *fakePaymentRepo) ClearUserCart(ctx context.Context, userID string) error {
+	return nil
+}
+
+func (r *fakePaymentRepo) AdjustRefundedAmount(ctx context.Context, paymentID string, delta int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payment := r.byID(paymentID)
+	switch payment.Status {
+	case models.PaymentStatusSuccess, models.PaymentStatusPartiallyRefunded, models.PaymentStatusRefunded:
+	default:
+		return false, nil
+	}
+	refunded := payment.RefundedAmount + delta
+	if refunded < 0 || refunded > payment.Amount {
+		return false, nil
+	}
+	payment.RefundedAmount = refunded
+	switch {
+	case refunded == payment.Amount:
+		payment.Status = models.PaymentStatusRefunded
+	case refunded > 0:
+		payment.Status = models.PaymentStatusPartiallyRefunded
+	default:
+		payment.Status = models.PaymentStatusSuccess
+	}
+	return true, nil
+}
+
+type fakeOrderRepo struct {
+	repository.OrderRepo
+
+	mu     sync.Mutex
+	orders map[string]*models.Order
+}
+
+func newFakeOrderRepo(orders ...*models.Order) *fakeOrderRepo {
+	repo := &fakeOrderRepo{orders: make(map[string]*models.Order)}
+	for _, order := range orders {
+		repo.orders[order.ID] = order
+	}
+	return repo
+}
+
+func (r *fakeOrderRepo) CreateOrder(ctx context.Context, order *models.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.orders {
+		if existing.TransactionID == order.TransactionID {
+			return repository.ErrOrderExists
+		}
+	}
+	r.orders[order.ID] = order
+	return nil
+}
+
+func (r *fakeOrderRepo) GetOrderByTransactionID(ctx context.Context, transactionID string) (*models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, order := range r.orders {
+		if order.TransactionID == transactionID {
+			return order, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// fakeReservations records what happened to the stock of each payment
+type fakeReservations struct {
+	ReservationService
+
+	mu       sync.Mutex
+	statuses map[string]models.ReservationStatus
+	// soldOut makes Commit fail as if the stock ran out after the reservation expired
+	soldOut bool
+}
+
+func (r *fakeReservations) set(transactionUUID string, status models.ReservationStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.statuses == nil {
+		r.statuses = make(map[string]models.ReservationStatus)
+	}
+	r.statuses[transactionUUID] = status
+}
+
+func (r *fakeReservations) status(transactionUUID string) models.ReservationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.statuses[transactionUUID]
+}
+
+func (r *fakeReservations) Reserve(ctx context.Context, userId, transactionUUID string, lines []models.QuoteLine) (*models.StockReservation, error) {
+	r.set(transactionUUID, models.ReservationActive)
+	return &models.StockReservation{TransactionUuid: transactionUUID, UserID: userId, Status: models.ReservationActive}, nil
+}
+
+func (r *fakeReservations) Commit(ctx context.Context, transactionUUID string, items []models.ProductItem) error {
+	if r.soldOut {
+		return fmt.Errorf("%w: product-1", ErrInsufficientStock)
+	}
+	r.set(transactionUUID, models.ReservationCommitted)
+	return nil
+}
+
+func (r *fakeReservations) Release(ctx context.Context, transactionUUID string, status models.ReservationStatus, reason string) error {
+	r.set(transactionUUID, status)
+	return nil
+}
+
+type fakeAddresses struct {
+	AddressService
+}
+
+func (fakeAddresses) CheckoutAddress(ctx context.Context, userId, addressId string, addressType models.AddressType) (*models.AddressSnapshot, error) {
+	return nil, nil
+}
+
+type mockCheckout struct {
+	service      PaymentService
+	payments     *fakePaymentRepo
+	orders       *fakeOrderRepo
+	reservations *fakeReservations
+	mock         *gateway.MockGateway
+	ctx          *gin.Context
+}
+
+const mockCheckoutUser = "user-1"
+
+func newMockCheckout(t *testing.T) *mockCheckout {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	checkout := &mockCheckout{
+		payments: newFakePaymentRepo(&models.Product{
+			ID: "product-1", SellerID: "seller-1", Name: "Kettle", Price: 100, Discount: 10, Stock: 10,
+		}),
+		orders:       newFakeOrderRepo(),
+		reservations: &fakeReservations{},
+		mock:         gateway.NewMockGateway("http://shop.test/payment/success"),
+	}
+	checkout.service = NewPaymentService(
+		checkout.payments,
+		checkout.orders,
+		nil,
+		nil,
+		nil,
+		NewPricingService(0, 0, 0),
+		checkout.reservations,
+		fakeAddresses{},
+		gateway.NewRegistry("mock", checkout.mock),
+	)
+
+	checkout.ctx, _ = gin.CreateTestContext(httptest.NewRecorder())
+	checkout.ctx.Request = httptest.NewRequest("POST", "/payment", nil)
+	checkout.ctx.Set("userId", mockCheckoutUser)
+	checkout.ctx.Set("userEmail", "user@example.com")
+	checkout.ctx.Set("userFullName", "Test User")
+	return checkout
+}
+
+// initiate starts a payment for two kettles and returns the transaction and the success url params
+func (m *mockCheckout) initiate(t *testing.T) (string, map[string]string) {
+	t.Helper()
+
+	response, quote, err := m.service.InitiatePayment(m.ctx, []CartItem{{ID: "product-1", Quantity: 2, Price: 1}}, CheckoutOptions{Provider: "mock"})
+	if err != nil {
+		t.Fatalf("InitiatePayment: %v", err)
+	}
+	// 2 x 100 with the 10% product discount, the client price is ignored
+	if quote.Total != 180 {
+		t.Fatalf("quote total = %d, want 180", quote.Total)
+	}
+
+	redirect, err := url.Parse(response.PaymentURL)
+	if err != nil {
+		t.Fatalf("invalid payment url %q: %v", response.PaymentURL, err)
+	}
+	params := map[string]string{}
+	for key := range redirect.Query() {
+		params[key] = redirect.Query().Get(key)
+	}
+
+	if len(m.payments.payments) != 1 {
+		t.Fatalf("got %d payments, want 1", len(m.payments.payments))
+	}
+	for transactionUUID, payment := range m.payments.payments {
+		if payment.Status != models.PaymentStatusPending || payment.Provider != "mock" {
+			t.Fatalf("payment is %s with %s, want pending with mock", payment.Status, payment.Provider)
+		}
+		return transactionUUID, params
+	}
+	return "", nil
+}
+
+func (m *mockCheckout) process(params map[string]string) (*models.Order, error) {
+	return m.service.ProcessSuccessfulPayment(m.ctx, mockCheckoutUser, ProcessPaymentRequest{Provider: "mock", Params: params})
+}
+
+func TestMockCheckoutCreatesOrder(t *testing.T) {
+	checkout := newMockCheckout(t)
+	transactionUUID, params := checkout.initiate(t)
+
+	order, err := checkout.process(params)
+	if err != nil {
+		t.Fatalf("ProcessSuccessfulPayment: %v", err)
+	}
+	if order.TransactionID != transactionUUID || order.User != mockCheckoutUser || order.Amount != 180 {
+		t.Fatalf("order = %+v, want 180 for %s", order, transactionUUID)
+	}
+	if len(order.Products) != 1 || order.Products[0].Quantity != 2 || order.Products[0].Total != 180 {
+		t.Fatalf("order lines = %+v, want 2 kettles for 180", order.Products)
+	}
+	if len(order.SellerOrders) != 1 || order.SellerOrders[0].SellerID != "seller-1" {
+		t.Fatalf("seller orders = %+v, want one for seller-1", order.SellerOrders)
+	}
+
+	payment, _ := checkout.payments.GetPaymentByTransactionUUID(context.Background(), transactionUUID)
+	if payment.Status != models.PaymentStatusSuccess || payment.GatewayRef == "" {
+		t.Fatalf("payment is %s with ref %q, want success with a ref", payment.Status, payment.GatewayRef)
+	}
+	if status := checkout.reservations.status(transactionUUID); status != models.ReservationCommitted {
+		t.Fatalf("reservation is %s, want committed", status)
+	}
+
+	// A retried callback returns the same order instead of creating another one
+	again, err := checkout.process(params)
+	if err != nil || again.ID != order.ID {
+		t.Fatalf("retry returned %v, %v, want order %s", again, err, order.ID)
+	}
+	if len(checkout.orders.orders) != 1 {
+		t.Fatalf("got %d orders, want 1", len(checkout.orders.orders))
+	}
+}
+
+func TestMockCheckoutFailedPayment(t *testing.T) {
+	checkout := newMockCheckout(t)
+	transactionUUID, params := checkout.initiate(t)
+	checkout.mock.SetStatus(transactionUUID, gateway.StatusFailed)
+
+	_, err := checkout.process(params)
+	if !errors.Is(err, ErrPaymentVerificationFailed) {
+		t.Fatalf("err = %v, want ErrPaymentVerificationFailed", err)
+	}
+
+	payment, _ := checkout.payments.GetPaymentByTransactionUUID(context.Background(), transactionUUID)
+	if payment.Status != models.PaymentStatusFailed {
+		t.Fatalf("payment is %s, want failed", payment.Status)
+	}
+	if status := checkout.reservations.status(transactionUUID); status != models.ReservationReleased {
+		t.Fatalf("reservation is %s, want released", status)
+	}
+	if len(checkout.orders.orders) != 0 {
+		t.Fatalf("got %d orders for a failed payment", len(checkout.orders.orders))
+	}
+}
+
+func TestMockCheckoutPendingPayment(t *testing.T) {
+	checkout := newMockCheckout(t)
+	transactionUUID, params := checkout.initiate(t)
+	checkout.mock.SetStatus(transactionUUID, gateway.StatusPending)
+
+	if _, err := checkout.process(params); err == nil {
+		t.Fatal("a pending payment created an order")
+	}
+
+	// The payment stays open, it completes once the provider does
+	payment, _ := checkout.payments.GetPaymentByTransactionUUID(context.Background(), transactionUUID)
+	if payment.Status != models.PaymentStatusPending {
+		t.Fatalf("payment is %s, want pending", payment.Status)
+	}
+	if status := checkout.reservations.status(transactionUUID); status != models.ReservationActive {
+		t.Fatalf("reservation is %s, want active", status)
+	}
+
+	checkout.mock.SetStatus(transactionUUID, gateway.StatusComplete)
+	if _, err := checkout.process(params); err != nil {
+		t.Fatalf("ProcessSuccessfulPayment after completion: %v", err)
+	}
+}
+
+func TestMockCheckoutRefund(t *testing.T) {
+	checkout := newMockCheckout(t)
+	transactionUUID, params := checkout.initiate(t)
+	if _, err := checkout.process(params); err != nil {
+		t.Fatalf("ProcessSuccessfulPayment: %v", err)
+	}
+
+	result, err := checkout.service.RefundPayment(checkout.ctx, transactionUUID, 80, "damaged")
+	if err != nil {
+		t.Fatalf("RefundPayment: %v", err)
+	}
+	if result.Status != gateway.StatusRefunded || result.RefundRef == "" {
+		t.Fatalf("refund result = %+v", result)
+	}
+	payment, _ := checkout.payments.GetPaymentByTransactionUUID(context.Background(), transactionUUID)
+	if payment.Status != models.PaymentStatusPartiallyRefunded || payment.RefundedAmount != 80 {
+		t.Fatalf("payment is %s with %d refunded, want partially_refunded with 80", payment.Status, payment.RefundedAmount)
+	}
+
+	// Only the remaining 100 can be given back
+	if _, err := checkout.service.RefundPayment(checkout.ctx, transactionUUID, 101, "too much"); !errors.Is(err, ErrRefundNotAllowed) {
+		t.Fatalf("err = %v, want ErrRefundNotAllowed", err)
+	}
+	if _, err := checkout.service.RefundPayment(checkout.ctx, transactionUUID, 100, "rest"); err != nil {
+		t.Fatalf("RefundPayment of the rest: %v", err)
+	}
+	payment, _ = checkout.payments.GetPaymentByTransactionUUID(context.Background(), transactionUUID)
+	if payment.Status != models.PaymentStatusRefunded || payment.RefundedAmount != 180 {
+		t.Fatalf("payment is %s with %d refunded, want refunded with 180", payment.Status, payment.RefundedAmount)
+	}
+}
+
+func TestMockCheckoutSoldOutRefunds(t *testing.T) {
+	checkout := newMockCheckout(t)
+	transactionUUID, params := checkout.initiate(t)
+	checkout.reservations.soldOut = true
+
+	if _, err := checkout.process(params); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("err = %v, want ErrInsufficientStock", err)
+	}
+	if len(checkout.orders.orders) != 0 {
+		t.Fatalf("got %d orders without stock", len(checkout.orders.orders))
+	}
+	payment, _ := checkout.payments.GetPaymentByTransactionUUID(context.Background(), transactionUUID)
+	if payment.Status != models.PaymentStatusRefunded || payment.RefundedAmount != 180 {
+		t.Fatalf("payment is %s with %d refunded, want refunded with 180", payment.Status, payment.RefundedAmount)
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+
+	"e-commerce.com/internal/models"
+)
+
+// PricingService prices checkout lines from the stored product data only,
+// the price sent by the client is never used
+type PricingService interface {
+	Quote(cartItems []CartItem, products []*models.Product) (*models.PriceQuote, error)
+}
+
+type pricingService struct {
+	taxRatePercent        int
+	deliveryCharge        int64
+	freeDeliveryThreshold int64
+}
+
+func NewPricingService(taxRatePercent int, deliveryCharge, freeDeliveryThreshold int64) PricingService {
+	return &pricingService{
+		taxRatePercent:        taxRatePercent,
+		deliveryCharge:        deliveryCharge,
+		freeDeliveryThreshold: freeDeliveryThreshold,
+	}
+}
+
+func (s *pricingService) Quote(cartItems []CartItem, products []*models.Product) (*models.PriceQuote, error) {
+	if len(cartItems) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	productsById := make(map[string]*models.Product, len(products))
+	for _, product := range products {
+		productsById[product.ID] = product
+	}
+
+	// Merge repeated products so the stock check sees the full quantity
+	quantities := make(map[string]int64, len(cartItems))
+	order := make([]string, 0, len(cartItems))
+	for _, item := range cartItems {
+		if item.Quantity < 1 {
+			return nil, fmt.Errorf("%w: product %s", ErrInvalidQuantity, item.ID)
+		}
+		if _, seen := quantities[item.ID]; !seen {
+			order = append(order, item.ID)
+		}
+		quantities[item.ID] += item.Quantity
+	}
+
+	quote := &models.PriceQuote{
+		Lines: make([]models.QuoteLine, 0, len(order)),
+	}
+
+	for _, productId := range order {
+		product, ok := productsById[productId]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrProductNotFound, productId)
+		}
+
+		quantity := quantities[productId]
+		if int64(product.Stock) < quantity {
+			return nil, fmt.Errorf("%w: %s has %d left, %d requested", ErrInsufficientStock, product.Name, product.Stock, quantity)
+		}
+
+		line := models.QuoteLine{
+			ProductID:       product.ID,
+			SellerID:        product.SellerID,
+			Name:            product.Name,
+			Quantity:        quantity,
+			UnitPrice:       int64(product.Price),
+			DiscountPercent: clampPercent(product.Discount),
+		}
+		line.Subtotal, line.Discount = priceLine(line.UnitPrice, quantity, product.Discount)
+		line.Total = line.Subtotal - line.Discount
+
+		quote.Subtotal += line.Subtotal
+		quote.Discount += line.Discount
+		quote.Lines = append(quote.Lines, line)
+	}
+
+	quote.Tax = quote.Amount() * int64(clampPercent(s.taxRatePercent)) / 100
+	quote.Delivery = s.deliveryCharge
+	if s.freeDeliveryThreshold > 0 && quote.Amount() >= s.freeDeliveryThreshold {
+		quote.Delivery = 0
+	}
+	quote.Total = quote.Amount() + quote.Tax + quote.ServiceCharge + quote.Delivery
+
+	// eSewa rejects payments below 1 rupee
+	if quote.Total < 1 {
+		return nil, fmt.Errorf("order total must be at least 1, got %d", quote.Total)
+	}
+
+	return quote, nil
+}
+
+// priceLine returns the undiscounted subtotal of a line and the product discount taken off it.
+// The cart prices its lines with it too, so its totals match the checkout quote
+func priceLine(unitPrice, quantity int64, discountPercent int) (subtotal, discount int64) {
+	subtotal = unitPrice * quantity
+	discount = subtotal * int64(clampPercent(discountPercent)) / 100
+	return subtotal, discount
+}
+
+// clampPercent keeps discount and tax rates within 0-100
+func clampPercent(percent int) int {
+	if percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"e-commerce.com/internal/models"
@@ -12,27 +13,55 @@ import (
 type ProductService interface {
 	CreateProduct(ctx context.Context, product *models.CreateProductRequest, sellerId string) error
 	GetSellerProducts(ctx context.Context, sellerId string, page int, limit int) ([]*models.Product, int64, bool, error)
-	UpdateProduct(ctx context.Context, productId string, product *models.UpdateProductRequest) error
-	DeleteProduct(ctx context.Context, productId string) error
-	GetProductById(ctx context.Context, productId string) (*models.Product, []models.ProductReview, error)
+	UpdateProduct(ctx context.Context, sellerId, productId string, product *models.UpdateProductRequest) error
+	DeleteProduct(ctx context.Context, sellerId, productId string) error
+	// GetProductById returns the product with the first page of its newest reviews
+	GetProductById(ctx context.Context, productId string) (*models.Product, *models.ReviewPage, error)
 	GetAllProducts(ctx context.Context, search *string, limit, offset int) (*models.ProductResponse, error)
 }
 
 type productService struct {
-	repo repository.ProductRepo
+	repo     repository.ProductRepo
+	comments CommentService
 }
 
-func (s *productService) GetProductById(ctx context.Context, productId string) (*models.Product, []models.ProductReview, error) {
-	return s.repo.GetProductByID(ctx, productId)
+func (s *productService) GetProductById(ctx context.Context, productId string) (*models.Product, *models.ReviewPage, error) {
+	product, err := s.repo.GetProductByID(ctx, productId)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	reviews, err := s.comments.ListReviews(ctx, productId, models.ReviewQuery{})
+	if err != nil {
+		return product, nil, err
+	}
+	return product, reviews, nil
+}
+
+func (s *productService) UpdateProduct(ctx context.Context, sellerId, productId string, product *models.UpdateProductRequest) error {
+	if err := s.authorizeSeller(ctx, sellerId, productId); err != nil {
+		return err
+	}
+	return s.repo.UpdateProduct(ctx, sellerId, productId, product)
 }
 
-func (s *productService) UpdateProduct(ctx context.Context, productId string, product *models.UpdateProductRequest) error {
-	return s.repo.UpdateProduct(ctx, productId, product)
+func (s *productService) DeleteProduct(ctx context.Context, sellerId, productId string) error {
+	if err := s.authorizeSeller(ctx, sellerId, productId); err != nil {
+		return err
+	}
+	return s.repo.DeleteProduct(ctx, sellerId, productId)
 }
 
-func (s *productService) DeleteProduct(ctx context.Context, productId string) error {
-	return s.repo.DeleteProduct(ctx, productId)
+// authorizeSeller loads the product and checks that it belongs to the seller
+func (s *productService) authorizeSeller(ctx context.Context, sellerId, productId string) error {
+	products, err := s.repo.GetProductsByIDs(ctx, []string{productId})
+	if err != nil {
+		return err
+	}
+	if len(products) == 0 {
+		return fmt.Errorf("%w: %s", ErrProductNotFound, productId)
+	}
+	return authorizeProductSeller(products[0], sellerId)
 }
 
 func (s *productService) GetSellerProducts(ctx context.Context, sellerId string, page int, limit int) ([]*models.Product, int64, bool, error) {
@@ -66,6 +95,6 @@ func (s *productService) GetAllProducts(ctx context.Context, search *string, lim
 	return s.repo.GetAllProducts(ctx, search, limit, offset)
 }
 
-func NewProductService(repo repository.ProductRepo) ProductService {
-	return &productService{repo: repo}
+func NewProductService(repo repository.ProductRepo, comments CommentService) ProductService {
+	return &productService{repo: repo, comments: comments}
 }
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+)
+
+// ReconciliationService settles payments that stayed pending because the customer never
+// came back from the provider, and keeps a log of every decision for finance
+type ReconciliationService interface {
+	ReconcileStalePayments(ctx context.Context) ([]*models.ReconciliationLog, error)
+	GetLogs(ctx context.Context, decision, transactionUUID string, page, limit int) ([]*models.ReconciliationLog, int64, error)
+}
+
+type reconciliationService struct {
+	paymentRepo    repository.PaymentRepo
+	logRepo        repository.ReconciliationRepo
+	paymentService PaymentService
+	minAge         time.Duration
+	ttl            time.Duration
+	batchSize      int
+}
+
+// NewReconciliationService checks payments pending for longer than minAge and expires
+// the ones still unpaid after ttl, batchSize payments per run
+func NewReconciliationService(paymentRepo repository.PaymentRepo, logRepo repository.ReconciliationRepo, paymentService PaymentService, minAge, ttl time.Duration, batchSize int) ReconciliationService {
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	return &reconciliationService{
+		paymentRepo:    paymentRepo,
+		logRepo:        logRepo,
+		paymentService: paymentService,
+		minAge:         minAge,
+		ttl:            ttl,
+		batchSize:      batchSize,
+	}
+}
+
+func (s *reconciliationService) ReconcileStalePayments(ctx context.Context) ([]*models.ReconciliationLog, error) {
+	payments, err := s.paymentRepo.GetStalePendingPayments(ctx, time.Now().Add(-s.minAge), s.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending payments: %v", err)
+	}
+
+	logs := make([]*models.ReconciliationLog, 0, len(payments))
+	for _, payment := range payments {
+		if ctx.Err() != nil {
+			return logs, ctx.Err()
+		}
+
+		entry := s.paymentService.ReconcilePayment(ctx, payment, s.ttl)
+		if err := s.logRepo.CreateLog(ctx, entry); err != nil {
+			fmt.Printf("WARNING: failed to write reconciliation log for payment %s: %v\n", payment.ID, err)
+		}
+		logs = append(logs, entry)
+	}
+	return logs, nil
+}
+
+func (s *reconciliationService) GetLogs(ctx context.Context, decision, transactionUUID string, page, limit int) ([]*models.ReconciliationLog, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 50
+	}
+
+	logs, total, err := s.logRepo.GetLogs(ctx, decision, transactionUUID, (page-1)*limit, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get reconciliation logs: %v", err)
+	}
+	return logs, total, nil
+}
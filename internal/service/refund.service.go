@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"e-commerce.com/internal/gateway"
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+	"e-commerce.com/internal/utils"
+)
+
+var (
+	ErrRefundLineNotFound = errors.New("order line not found")
+	ErrRefundExceedsOrder = errors.New("refund exceeds what is left on the order")
+)
+
+// RefundService returns money for whole orders or single order lines.
+// The money itself moves through PaymentService.RefundPayment
+type RefundService interface {
+	// RefundOrder refunds the requested lines of an order, or everything that is left when none are given
+	RefundOrder(ctx context.Context, orderId string, req models.CreateRefundRequest, initiator models.RefundInitiator, initiatorId string) (*models.Refund, error)
+	// SellerRefundOrder refunds lines of the seller's own products only
+	SellerRefundOrder(ctx context.Context, sellerId, orderId string, req models.CreateRefundRequest) (*models.Refund, error)
+	GetOrderRefunds(ctx context.Context, orderId string) ([]*models.Refund, error)
+	GetUserOrderRefunds(ctx context.Context, userId, orderId string) ([]*models.Refund, error)
+	GetRefunds(ctx context.Context, status string, page, limit int) ([]*models.Refund, int64, error)
+}
+
+type refundService struct {
+	refundRepo     repository.RefundRepo
+	orderRepo      repository.OrderRepo
+	paymentRepo    repository.PaymentRepo
+	paymentService PaymentService
+}
+
+func NewRefundService(refundRepo repository.RefundRepo, orderRepo repository.OrderRepo, paymentRepo repository.PaymentRepo, paymentService PaymentService) RefundService {
+	return &refundService{
+		refundRepo:     refundRepo,
+		orderRepo:      orderRepo,
+		paymentRepo:    paymentRepo,
+		paymentService: paymentService,
+	}
+}
+
+func (s *refundService) RefundOrder(ctx context.Context, orderId string, req models.CreateRefundRequest, initiator models.RefundInitiator, initiatorId string) (*models.Refund, error) {
+	order, err := s.orderRepo.GetOrderByID(ctx, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %v", err)
+	}
+	return s.refund(ctx, order, req.SellerID, req, initiator, initiatorId)
+}
+
+func (s *refundService) SellerRefundOrder(ctx context.Context, sellerId, orderId string, req models.CreateRefundRequest) (*models.Refund, error) {
+	order, err := s.orderRepo.GetOrderByID(ctx, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %v", err)
+	}
+	if err := authorizeOrderSeller(order, sellerId); err != nil {
+		return nil, err
+	}
+	return s.refund(ctx, order, sellerId, req, models.RefundInitiatorSeller, sellerId)
+}
+
+func (s *refundService) GetOrderRefunds(ctx context.Context, orderId string) ([]*models.Refund, error) {
+	refunds, err := s.refundRepo.GetRefundsByOrderID(ctx, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refunds: %v", err)
+	}
+	return refunds, nil
+}
+
+func (s *refundService) GetUserOrderRefunds(ctx context.Context, userId, orderId string) ([]*models.Refund, error) {
+	// Only the customer who placed the order may see its refunds
+	if _, err := s.orderRepo.GetUserOrderDetails(ctx, userId, orderId); err != nil {
+		return nil, fmt.Errorf("failed to get order details: %v", err)
+	}
+	return s.GetOrderRefunds(ctx, orderId)
+}
+
+func (s *refundService) GetRefunds(ctx context.Context, status string, page, limit int) ([]*models.Refund, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	refunds, total, err := s.refundRepo.GetRefunds(ctx, status, (page-1)*limit, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get refunds: %v", err)
+	}
+	return refunds, total, nil
+}
+
+// refund works out what is owed, records the refund and pays it out.
+// sellerId limits the refund to that seller's lines, empty allows every line
+func (s *refundService) refund(ctx context.Context, order *models.Order, sellerId string, req models.CreateRefundRequest, initiator models.RefundInitiator, initiatorId string) (*models.Refund, error) {
+	// Refunded amounts are also kept per sub-order
+	if err := ensureSellerOrders(ctx, s.orderRepo, order); err != nil {
+		return nil, err
+	}
+
+	payment, err := s.paymentRepo.GetPaymentByTransactionUUID(ctx, order.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %v", err)
+	}
+
+	previous, err := s.refundRepo.GetRefundsByOrderID(ctx, order.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refunds: %v", err)
+	}
+
+	items, amount, err := refundItems(order, previous, sellerId, req.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refunding the rest of an order also gives back tax and delivery
+	if sellerId == "" && len(req.Items) == 0 {
+		amount = payment.Amount - payment.RefundedAmount
+	}
+	if amount < 1 {
+		return nil, fmt.Errorf("%w: nothing left to refund", ErrRefundExceedsOrder)
+	}
+
+	// The refunds read above may be stale when two refunds run at once, claiming the units on the
+	// order lines in one conditional update lets only one of them through before any money moves
+	quantities := make(map[string]int64, len(items))
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	claimed, err := s.orderRepo.ClaimRefundQuantities(ctx, order.ID, quantities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim refund quantities: %v", err)
+	}
+	if !claimed {
+		return nil, fmt.Errorf("%w: the lines were refunded meanwhile", ErrRefundExceedsOrder)
+	}
+
+	refund := &models.Refund{
+		ID:              utils.GenerateRandomUUID(),
+		OrderID:         order.ID,
+		PaymentID:       payment.ID,
+		TransactionUuid: payment.TransactionUuid,
+		UserID:          order.User,
+		Provider:        payment.Provider,
+		Items:           items,
+		Amount:          amount,
+		Reason:          req.Reason,
+		InitiatedBy:     initiator,
+		InitiatorID:     initiatorId,
+		Status:          models.RefundStatusPending,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	if err := s.refundRepo.CreateRefund(ctx, refund); err != nil {
+		s.releaseRefundQuantities(ctx, order.ID, quantities)
+		return nil, fmt.Errorf("failed to create refund: %v", err)
+	}
+
+	result, err := s.paymentService.RefundPayment(ctx, payment.TransactionUuid, amount, req.Reason)
+	switch {
+	case err == nil:
+		refund.Status = models.RefundStatusSucceeded
+		refund.GatewayRef = result.RefundRef
+	case errors.Is(err, gateway.ErrRefundNotSupported):
+		refund.Status = models.RefundStatusManual
+	default:
+		refund.Status = models.RefundStatusFailed
+		refund.FailureReason = err.Error()
+	}
+
+	if updateErr := s.refundRepo.UpdateRefundStatus(ctx, refund.ID, refund.Status, refund.GatewayRef, refund.FailureReason); updateErr != nil {
+		fmt.Printf("WARNING: failed to update refund %s: %v\n", refund.ID, updateErr)
+	}
+	if refund.Status == models.RefundStatusFailed {
+		s.releaseRefundQuantities(ctx, order.ID, quantities)
+		return refund, err
+	}
+
+	sellerAmounts := make(map[string]int64)
+	for _, item := range items {
+		sellerAmounts[item.SellerID] += item.Amount
+	}
+	if err := s.orderRepo.AddRefundedAmount(ctx, order.ID, amount, sellerAmounts); err != nil {
+		fmt.Printf("WARNING: failed to record refund on order %s: %v\n", order.ID, err)
+	}
+
+	s.closeRefundedSellerOrders(ctx, order.ID, refund)
+
+	log.Printf("💸 Refund %s of %d for order %s is %s", refund.ID, amount, order.ID, refund.Status)
+	return refund, nil
+}
+
+// releaseRefundQuantities frees the units of a refund that did not go through so they can be refunded again
+func (s *refundService) releaseRefundQuantities(ctx context.Context, orderId string, quantities map[string]int64) {
+	if err := s.orderRepo.ReleaseRefundQuantities(ctx, orderId, quantities); err != nil {
+		fmt.Printf("WARNING: failed to release refund quantities of order %s: %v\n", orderId, err)
+	}
+}
+
+// closeRefundedSellerOrders moves sub-orders that were paid back in full to refunded when the
+// state machine allows it, cancelled and returned sub-orders keep their status
+func (s *refundService) closeRefundedSellerOrders(ctx context.Context, orderId string, refund *models.Refund) {
+	order, err := s.orderRepo.GetOrderByID(ctx, orderId)
+	if err != nil {
+		fmt.Printf("WARNING: failed to get order %s: %v\n", orderId, err)
+		return
+	}
+
+	for _, sub := range order.SellerOrders {
+		if sub.Amount < 1 || sub.RefundedAmount < sub.Amount {
+			continue
+		}
+		if _, err := orderTransitionRule(sub.Status, models.OrderStatusRefunded, models.OrderActorSystem); err != nil {
+			continue
+		}
+
+		change := models.OrderStatusChange{
+			From:     sub.Status,
+			To:       models.OrderStatusRefunded,
+			Actor:    models.OrderActorSystem,
+			ActorID:  refund.InitiatorID,
+			SellerID: sub.SellerID,
+			Reason:   fmt.Sprintf("refund %s paid back the order in full", refund.ID),
+			At:       time.Now(),
+		}
+		if _, err := s.orderRepo.TransitionSellerOrderStatus(ctx, order.ID, change, nil); err != nil {
+			fmt.Printf("WARNING: failed to mark order %s of seller %s as refunded: %v\n", order.ID, sub.SellerID, err)
+		}
+	}
+
+	if _, _, err := syncOrderStatus(ctx, s.orderRepo, order.ID); err != nil {
+		fmt.Printf("WARNING: failed to update status of order %s: %v\n", order.ID, err)
+	}
+}
+
+// refundItems resolves the requested lines against the order and the refunds made so far.
+// Without requested lines every line with quantity left is refunded
+func refundItems(order *models.Order, previous []*models.Refund, sellerId string, requested []models.RefundLineRequest) ([]models.RefundItem, int64, error) {
+	refundedQuantity := make(map[string]int64)
+	refundedAmount := make(map[string]int64)
+	for _, refund := range previous {
+		if refund.Status == models.RefundStatusFailed {
+			continue
+		}
+		for _, item := range refund.Items {
+			refundedQuantity[item.ProductID] += item.Quantity
+			refundedAmount[item.ProductID] += item.Amount
+		}
+	}
+
+	lines := make(map[string]models.ProductItem, len(order.Products))
+	for _, line := range order.Products {
+		lines[line.ProductID] = line
+	}
+
+	if len(requested) == 0 {
+		for _, line := range order.Products {
+			if sellerId != "" && line.SellerID != sellerId {
+				continue
+			}
+			if left := line.Quantity - refundedQuantity[line.ProductID]; left > 0 {
+				requested = append(requested, models.RefundLineRequest{ProductID: line.ProductID, Quantity: left})
+			}
+		}
+	}
+
+	items := make([]models.RefundItem, 0, len(requested))
+	var amount int64
+	for _, req := range requested {
+		line, ok := lines[req.ProductID]
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: %s", ErrRefundLineNotFound, req.ProductID)
+		}
+		if sellerId != "" && line.SellerID != sellerId {
+			return nil, 0, &ForbiddenError{Resource: "order line", ID: order.ID + "/" + req.ProductID, UserID: sellerId}
+		}
+
+		left := line.Quantity - refundedQuantity[line.ProductID]
+		if req.Quantity < 1 || req.Quantity > left {
+			return nil, 0, fmt.Errorf("%w: %d of %s requested, %d left", ErrRefundExceedsOrder, req.Quantity, line.ProductID, left)
+		}
+
+		// The last units take whatever is left so rounding never loses or adds money
+		lineTotal := line.LineTotal()
+		itemAmount := lineTotal * req.Quantity / line.Quantity
+		if req.Quantity == left {
+			itemAmount = lineTotal - refundedAmount[line.ProductID]
+		}
+
+		refundedQuantity[line.ProductID] += req.Quantity
+		refundedAmount[line.ProductID] += itemAmount
+		amount += itemAmount
+		items = append(items, models.RefundItem{
+			ProductID: line.ProductID,
+			SellerID:  line.SellerID,
+			Quantity:  req.Quantity,
+			Amount:    itemAmount,
+		})
+	}
+	return items, amount, nil
+}
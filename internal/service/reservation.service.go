@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+	"e-commerce.com/internal/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ReservationService holds stock back for a payment from the moment it is started,
+// so two buyers can never pay for the same last unit
+type ReservationService interface {
+	// Reserve takes the quoted quantities off the stock, all of them or none
+	Reserve(ctx context.Context, userId, transactionUUID string, lines []models.QuoteLine) (*models.StockReservation, error)
+	// Commit turns the reservation of a paid payment into sold stock. items are taken off the
+	// stock directly for payments that have no reservation or whose reservation already ran out,
+	// ErrInsufficientStock is returned when they are not all left
+	Commit(ctx context.Context, transactionUUID string, items []models.ProductItem) error
+	// Release gives the reserved stock back when the payment failed or expired
+	Release(ctx context.Context, transactionUUID string, status models.ReservationStatus, reason string) error
+	// ExpireReservations releases reservations that ran out and whose payment failed, expired or was
+	// never stored. Reservations of payments that are still pending are kept for another ttl
+	ExpireReservations(ctx context.Context) (int, error)
+	// RestoreStock puts the stock of cancelled or returned order lines back
+	RestoreStock(ctx context.Context, items []models.ProductItem) error
+}
+
+type reservationService struct {
+	repo        repository.ReservationRepo
+	productRepo repository.ProductRepo
+	paymentRepo repository.PaymentRepo
+	ttl         time.Duration
+	batchSize   int
+}
+
+// NewReservationService keeps reservations for ttl, batchSize expired ones are released per run
+func NewReservationService(repo repository.ReservationRepo, productRepo repository.ProductRepo, paymentRepo repository.PaymentRepo, ttl time.Duration, batchSize int) ReservationService {
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	return &reservationService{
+		repo:        repo,
+		productRepo: productRepo,
+		paymentRepo: paymentRepo,
+		ttl:         ttl,
+		batchSize:   batchSize,
+	}
+}
+
+func (s *reservationService) Reserve(ctx context.Context, userId, transactionUUID string, lines []models.QuoteLine) (*models.StockReservation, error) {
+	items := make([]models.ReservedItem, 0, len(lines))
+	for _, line := range lines {
+		reserved, err := s.productRepo.ReserveStock(ctx, line.ProductID, line.Quantity)
+		if err != nil || !reserved {
+			// Give back what this checkout already took
+			s.returnStock(ctx, items)
+			if err != nil {
+				return nil, fmt.Errorf("failed to reserve stock: %v", err)
+			}
+			return nil, fmt.Errorf("%w: %s", ErrInsufficientStock, line.Name)
+		}
+		items = append(items, models.ReservedItem{ProductID: line.ProductID, Quantity: line.Quantity})
+	}
+
+	// The reservation is stored after the stock was taken, a crash in between
+	// can only leave stock held back, never sell the same unit twice
+	reservation := &models.StockReservation{
+		ID:              utils.GenerateRandomUUID(),
+		TransactionUuid: transactionUUID,
+		UserID:          userId,
+		Items:           items,
+		Status:          models.ReservationActive,
+		ExpiresAt:       time.Now().Add(s.ttl),
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	if err := s.repo.CreateReservation(ctx, reservation); err != nil {
+		s.returnStock(ctx, items)
+		return nil, fmt.Errorf("failed to create reservation: %v", err)
+	}
+
+	return reservation, nil
+}
+
+func (s *reservationService) Commit(ctx context.Context, transactionUUID string, items []models.ProductItem) error {
+	reservation, err := s.repo.GetReservationByTransactionUUID(ctx, transactionUUID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Payments started before reservations existed still have to take their stock
+		return s.takeStock(ctx, transactionUUID, items)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get reservation: %v", err)
+	}
+
+	switch reservation.Status {
+	case models.ReservationCommitted:
+		return nil
+	case models.ReservationActive:
+		committed, err := s.repo.TransitionReservation(ctx, reservation.ID, models.ReservationActive, models.ReservationCommitted, "payment completed")
+		if err != nil {
+			return fmt.Errorf("failed to commit reservation: %v", err)
+		}
+		if committed {
+			return nil
+		}
+		// Released or expired in the meantime, fall back to taking the stock again
+		return s.Commit(ctx, transactionUUID, items)
+	default:
+		// The payment completed after the reservation ran out and its stock went back,
+		// only the first request to claim it takes the stock again
+		claimed, err := s.repo.TransitionReservation(ctx, reservation.ID, reservation.Status, models.ReservationCommitted, "payment completed after the reservation was "+string(reservation.Status))
+		if err != nil {
+			return fmt.Errorf("failed to commit reservation: %v", err)
+		}
+		if !claimed {
+			return nil
+		}
+		if err := s.takeStock(ctx, transactionUUID, items); err != nil {
+			// Hand the reservation back so the stock is not counted as sold
+			if _, revertErr := s.repo.TransitionReservation(ctx, reservation.ID, models.ReservationCommitted, reservation.Status, err.Error()); revertErr != nil {
+				fmt.Printf("WARNING: failed to revert reservation %s: %v\n", reservation.ID, revertErr)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+func (s *reservationService) Release(ctx context.Context, transactionUUID string, status models.ReservationStatus, reason string) error {
+	reservation, err := s.repo.GetReservationByTransactionUUID(ctx, transactionUUID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get reservation: %v", err)
+	}
+	return s.release(ctx, reservation, status, reason)
+}
+
+// release gives the stock back once, only the request that moves the reservation out of active does it
+func (s *reservationService) release(ctx context.Context, reservation *models.StockReservation, status models.ReservationStatus, reason string) error {
+	released, err := s.repo.TransitionReservation(ctx, reservation.ID, models.ReservationActive, status, reason)
+	if err != nil {
+		return fmt.Errorf("failed to release reservation: %v", err)
+	}
+	if !released {
+		return nil
+	}
+
+	s.returnStock(ctx, reservation.Items)
+	log.Printf("📦 Reservation for payment %s is %s: %s", reservation.TransactionUuid, status, reason)
+	return nil
+}
+
+func (s *reservationService) ExpireReservations(ctx context.Context) (int, error) {
+	reservations, err := s.repo.GetExpiredReservations(ctx, time.Now(), s.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expired reservations: %v", err)
+	}
+
+	expired := 0
+	for _, reservation := range reservations {
+		if ctx.Err() != nil {
+			return expired, ctx.Err()
+		}
+
+		// A payment that did complete keeps its stock even if the order was never created,
+		// one that can still settle keeps it until reconciliation expires the payment
+		payment, err := s.paymentRepo.GetPaymentByTransactionUUID(ctx, reservation.TransactionUuid)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			fmt.Printf("WARNING: failed to get payment of reservation %s: %v\n", reservation.ID, err)
+			continue
+		}
+		if err == nil {
+			switch payment.Status {
+			case models.PaymentStatusPending:
+				// Checked again after another ttl so it does not hold up the batch
+				if err := s.repo.ExtendReservation(ctx, reservation.ID, time.Now().Add(s.ttl)); err != nil {
+					fmt.Printf("WARNING: failed to extend reservation %s: %v\n", reservation.ID, err)
+				}
+				continue
+			case models.PaymentStatusSuccess, models.PaymentStatusPartiallyRefunded, models.PaymentStatusRefunded:
+				if _, err := s.repo.TransitionReservation(ctx, reservation.ID, models.ReservationActive, models.ReservationCommitted, "payment completed"); err != nil {
+					fmt.Printf("WARNING: failed to commit reservation %s: %v\n", reservation.ID, err)
+				}
+				continue
+			}
+		}
+
+		if err := s.release(ctx, reservation, models.ReservationExpired, fmt.Sprintf("payment not completed within %s", s.ttl)); err != nil {
+			fmt.Printf("WARNING: %v\n", err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+func (s *reservationService) RestoreStock(ctx context.Context, items []models.ProductItem) error {
+	for _, item := range items {
+		if err := s.productRepo.ReleaseStock(ctx, item.ProductID, item.Quantity); err != nil {
+			fmt.Printf("WARNING: Failed to restore stock for product %s: %v\n", item.ProductID, err)
+		}
+	}
+	return nil
+}
+
+// takeStock takes the stock of a paid order without a reservation, all of it or none
+func (s *reservationService) takeStock(ctx context.Context, transactionUUID string, items []models.ProductItem) error {
+	taken := make([]models.ReservedItem, 0, len(items))
+	for _, item := range items {
+		ok, err := s.productRepo.ReserveStock(ctx, item.ProductID, item.Quantity)
+		if err != nil || !ok {
+			s.returnStock(ctx, taken)
+			if err != nil {
+				return fmt.Errorf("failed to decrease stock: %v", err)
+			}
+			return fmt.Errorf("%w: product %s, payment %s needs %d", ErrInsufficientStock, item.ProductID, transactionUUID, item.Quantity)
+		}
+		taken = append(taken, models.ReservedItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	return nil
+}
+
+// returnStock puts reserved quantities back on the stock
+func (s *reservationService) returnStock(ctx context.Context, items []models.ReservedItem) {
+	for _, item := range items {
+		if err := s.productRepo.ReleaseStock(ctx, item.ProductID, item.Quantity); err != nil {
+			fmt.Printf("WARNING: Failed to release stock for product %s: %v\n", item.ProductID, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+)
+
+// maxOrderStatusSyncAttempts bounds how often syncOrderStatus retries after another seller
+// changed its sub-order in between reading and writing the order
+const maxOrderStatusSyncAttempts = 3
+
+// orderStatusProgress ranks the statuses of sub-orders that are still being fulfilled
+var orderStatusProgress = map[models.OrderStatus]int{
+	models.OrderStatusCreated:           0,
+	models.OrderStatusPaidAndProcessing: 1,
+	models.OrderStatusShipping:          2,
+	models.OrderStatusDelivered:         3,
+}
+
+// deriveOrderStatus works out the status of an order from its sub-orders.
+// Open sub-orders hold the order at the least advanced of them, cancelled, returned and
+// refunded ones are ignored while any is open. When every sub-order is closed the order takes
+// their shared status, or refunded when they differ since all of them were paid back
+func deriveOrderStatus(sellerOrders []models.SellerOrder) models.OrderStatus {
+	var derived models.OrderStatus
+	open := false
+	for _, sub := range sellerOrders {
+		progress, ok := orderStatusProgress[sub.Status]
+		if !ok {
+			continue
+		}
+		if !open || progress < orderStatusProgress[derived] {
+			derived = sub.Status
+			open = true
+		}
+	}
+	if open {
+		return derived
+	}
+
+	for i, sub := range sellerOrders {
+		if i == 0 {
+			derived = sub.Status
+		} else if sub.Status != derived {
+			return models.OrderStatusRefunded
+		}
+	}
+	return derived
+}
+
+// sellerOrdersClosed reports whether no sub-order of the order is still being fulfilled
+func sellerOrdersClosed(order *models.Order) bool {
+	for _, sub := range order.SellerOrders {
+		if _, open := orderStatusProgress[sub.Status]; open {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureSellerOrders splits an order that was created before sub-orders existed and stores the split
+func ensureSellerOrders(ctx context.Context, orderRepo repository.OrderRepo, order *models.Order) error {
+	if len(order.SellerOrders) > 0 {
+		return nil
+	}
+
+	sellerOrders := models.NewSellerOrders(order.Products, models.OrderStatusChange{
+		To:     order.Status,
+		Actor:  models.OrderActorSystem,
+		Reason: "split from order",
+		At:     time.Now(),
+	})
+	if err := orderRepo.SetSellerOrders(ctx, order.ID, sellerOrders); err != nil {
+		return fmt.Errorf("failed to split order %s by seller: %v", order.ID, err)
+	}
+
+	// Another request may have split it first, what is stored wins
+	stored, err := orderRepo.GetOrderByID(ctx, order.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get order details: %v", err)
+	}
+	*order = *stored
+	return nil
+}
+
+// syncOrderStatus stores the status derived from the order's sub-orders when it changed and
+// returns the change, or nil when there was none. The write only applies while the sub-orders
+// still have the statuses it was derived from, so sellers changing their sub-orders at the same
+// time are retried instead of overwriting each other
+func syncOrderStatus(ctx context.Context, orderRepo repository.OrderRepo, orderId string) (*models.Order, *models.OrderStatusChange, error) {
+	for attempt := 0; attempt < maxOrderStatusSyncAttempts; attempt++ {
+		order, err := orderRepo.GetOrderByID(ctx, orderId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get order details: %v", err)
+		}
+		if len(order.SellerOrders) == 0 {
+			return order, nil, nil
+		}
+
+		status := deriveOrderStatus(order.SellerOrders)
+		if status == order.Status {
+			return order, nil, nil
+		}
+
+		sellerStatuses := make([]models.OrderStatus, len(order.SellerOrders))
+		for i, sub := range order.SellerOrders {
+			sellerStatuses[i] = sub.Status
+		}
+		change := models.OrderStatusChange{
+			From:   order.Status,
+			To:     status,
+			Actor:  models.OrderActorSystem,
+			Reason: "derived from the seller orders",
+			At:     time.Now(),
+		}
+
+		updated, err := orderRepo.SetDerivedOrderStatus(ctx, order.ID, sellerStatuses, change)
+		if err != nil {
+			return nil, nil, err
+		}
+		if updated {
+			order.Status = status
+			order.StatusHistory = append(order.StatusHistory, change)
+			return order, &change, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: could not update the status of order %s", ErrOrderStatusConflict, orderId)
+}
+
+// nextShipment returns the shipment to store with a sub-order moving to status, or nil to keep
+// the stored one. Shipping records the carrier and tracking number, delivering the delivery time
+func nextShipment(current, details *models.Shipment, status models.OrderStatus, at time.Time) *models.Shipment {
+	switch status {
+	case models.OrderStatusShipping:
+		shipment := &models.Shipment{ShippedAt: &at}
+		if details != nil {
+			shipment.Carrier = details.Carrier
+			shipment.TrackingNumber = details.TrackingNumber
+		}
+		return shipment
+	case models.OrderStatusDelivered:
+		shipment := &models.Shipment{}
+		if current != nil {
+			*shipment = *current
+		}
+		shipment.DeliveredAt = &at
+		return shipment
+	default:
+		return nil
+	}
+}
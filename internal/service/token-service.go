@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"e-commerce.com/internal/models"
+	"e-commerce.com/internal/repository"
+	"e-commerce.com/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenService issues short lived access tokens together with rotating refresh tokens
+type TokenService interface {
+	// IssueTokens starts a new refresh token family for a login
+	IssueTokens(ctx context.Context, user *models.User) (*models.TokenPair, error)
+	// Refresh exchanges a refresh token for a new pair of the same family
+	Refresh(ctx context.Context, refreshToken string) (*models.TokenPair, error)
+	// RevokeTokens logs out the access token of claims and every refresh token of its family,
+	// for tokens without a jti every token of the user is revoked
+	RevokeTokens(ctx context.Context, claims *utils.Claims) error
+}
+
+type tokenService struct {
+	userRepo repository.UserRepo
+	// redisClient holds the refresh token families and the denied access tokens
+	redisClient *redis.Client
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+}
+
+func NewTokenService(userRepo repository.UserRepo, redisClient *redis.Client, accessTTL, refreshTTL time.Duration) TokenService {
+	return &tokenService{
+		userRepo:    userRepo,
+		redisClient: redisClient,
+		accessTTL:   accessTTL,
+		refreshTTL:  refreshTTL,
+	}
+}
+
+func (s *tokenService) IssueTokens(ctx context.Context, user *models.User) (*models.TokenPair, error) {
+	familyId, err := utils.StartTokenFamily(ctx, s.redisClient, user.ID, s.refreshTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start token family: %v", err)
+	}
+	return s.issue(ctx, user, familyId)
+}
+
+func (s *tokenService) Refresh(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	record, err := utils.RotateRefreshToken(ctx, s.redisClient, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// The user is loaded again so role changes reach the new access token
+	user, err := s.userRepo.GetUserByEmail(record.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil || user.ID != record.UserID {
+		return nil, utils.ErrInvalidRefreshToken
+	}
+
+	return s.issue(ctx, user, record.FamilyID)
+}
+
+func (s *tokenService) RevokeTokens(ctx context.Context, claims *utils.Claims) error {
+	// Tokens issued before they carried a jti can not be denied one by one,
+	// every token of the user issued until now is revoked instead
+	if claims.ID == "" {
+		if err := utils.RevokeUserTokens(ctx, s.redisClient, claims.UserId); err != nil {
+			return fmt.Errorf("failed to revoke user tokens: %v", err)
+		}
+		return nil
+	}
+	if err := utils.DenyToken(ctx, s.redisClient, claims); err != nil {
+		return fmt.Errorf("failed to deny token: %v", err)
+	}
+	if claims.FamilyID != "" {
+		if err := utils.RevokeTokenFamily(ctx, s.redisClient, claims.FamilyID); err != nil {
+			return fmt.Errorf("failed to revoke token family: %v", err)
+		}
+	}
+	return nil
+}
+
+// issue creates an access token and a refresh token of the family
+func (s *tokenService) issue(ctx context.Context, user *models.User, familyId string) (*models.TokenPair, error) {
+	accessToken, err := utils.GenerateJWT(utils.JwtDataType{
+		UserId:   user.ID,
+		FullName: user.Username,
+		Email:    user.Email,
+		Role:     user.Role,
+		FamilyID: familyId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+
+	refreshToken, err := utils.SaveRefreshToken(ctx, s.redisClient, utils.RefreshTokenRecord{
+		UserID:   user.ID,
+		Email:    user.Email,
+		FamilyID: familyId,
+		IssuedAt: time.Now(),
+	}, s.refreshTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %v", err)
+	}
+
+	return &models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+	}, nil
+}
@@ -4,13 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"e-commerce.com/internal/config"
-	"e-commerce.com/internal/db"
 	"e-commerce.com/internal/models"
 	"e-commerce.com/internal/repository"
 	"e-commerce.com/internal/utils"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -27,18 +28,45 @@ type UserService interface {
 	//post
 	Register(ctx context.Context, user *models.User) (string, error)
 	Login(ctx context.Context, userLogin *models.UserLogin) (*models.User, error)
+	RequestPasswordReset(ctx context.Context, email string) error
+
+	GetProfile(ctx context.Context, userId string) (*models.User, error)
 
 	// update
+	ResetPassword(ctx context.Context, token, password string) error
+	UpdateProfile(ctx context.Context, userId string, profile *models.UpdateProfileRequest) (*models.User, error)
+	// ChangePassword sets a new password after checking the current one and revokes every token issued before
+	ChangePassword(ctx context.Context, userId, currentPassword, newPassword string) (*models.User, error)
+	// RequestEmailChange sends a confirmation link to the new email, the email only changes once it is confirmed
+	RequestEmailChange(ctx context.Context, userId, newEmail, password string) error
+	ConfirmEmailChange(ctx context.Context, token string) (*models.User, error)
 
 	// delete
 }
 
+// ErrInvalidRole is returned when a registration asks for a role users can not choose
+var ErrInvalidRole = errors.New("role must be customer or seller")
+
+// ErrWeakPassword is returned for new passwords shorter than minPasswordLength
+var ErrWeakPassword = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+
+const minPasswordLength = 8
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	// ErrIncorrectPassword is returned when the current password given to confirm a change is wrong
+	ErrIncorrectPassword = errors.New("current password is incorrect")
+	ErrInvalidProfile    = errors.New("user name can not be empty")
+)
+
 type userService struct {
 	repo repository.UserRepo
+	// redisClient holds the password reset, email change and token revocation records
+	redisClient *redis.Client
 }
 
-func NewUserService(repo repository.UserRepo) UserService {
-	return &userService{repo: repo}
+func NewUserService(repo repository.UserRepo, redisClient *redis.Client) UserService {
+	return &userService{repo: repo, redisClient: redisClient}
 }
 
 func (s *userService) Login(ctx context.Context, userLogin *models.UserLogin) (*models.User, error) {
@@ -74,10 +102,11 @@ func (s *userService) VerifyUserToken(token string, ctx context.Context) (*model
 }
 
 func (s *userService) Register(ctx context.Context, user *models.User) (string, error) {
-	redisClient, err := db.GetRedisClient()
-	if err != nil {
-		return "", fmt.Errorf("failed to connect database")
+	// Admins are never created through registration
+	if user.Role != "" && user.Role != models.RoleCustomer && user.Role != models.RoleSeller {
+		return "", ErrInvalidRole
 	}
+
 	existingUser, err := s.repo.GetUserByEmail(user.Email)
 
 	if err != nil {
@@ -90,7 +119,7 @@ func (s *userService) Register(ctx context.Context, user *models.User) (string,
 
 	if existingUser != nil && !existingUser.IsVerified {
 		//send email
-		token, err := utils.SaveVerificationToken(ctx, redisClient, existingUser.Email)
+		token, err := utils.SaveVerificationToken(ctx, s.redisClient, existingUser.Email)
 		if err != nil {
 			return "", fmt.Errorf("failed to create token : %v", err)
 		}
@@ -121,7 +150,7 @@ func (s *userService) Register(ctx context.Context, user *models.User) (string,
 		return "", fmt.Errorf("failed to create user : %w", err)
 	}
 
-	token, err := utils.SaveVerificationToken(ctx, redisClient, user.Email)
+	token, err := utils.SaveVerificationToken(ctx, s.redisClient, user.Email)
 	if err != nil {
 		return "", fmt.Errorf("failed to create token : %v", err)
 	}
@@ -143,3 +172,185 @@ func (s *userService) GetUserByEmail(email string) (*models.User, error) {
 	}
 	return user, nil
 }
+
+// RequestPasswordReset emails a reset link to the user. Unknown and unverified emails
+// are ignored without an error, so the endpoint does not reveal which accounts exist
+func (s *userService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.repo.GetUserByEmail(email)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	// Unknown emails get the same answer as known ones, so the endpoint can not be used to find accounts
+	if user == nil || !user.IsVerified {
+		return nil
+	}
+
+	token, err := utils.SavePasswordResetToken(ctx, s.redisClient, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create token : %v", err)
+	}
+
+	resetLink := fmt.Sprintf("%s/reset-password?token=%s", config.AppConfig.FrontEndUrl, token)
+	if err := utils.SendPasswordResetEmail(user.Email, resetLink); err != nil {
+		return fmt.Errorf("failed to send password reset message : %v", err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user of a reset token, then ends the user's
+// session and revokes every token issued before the reset
+func (s *userService) ResetPassword(ctx context.Context, token, password string) error {
+	if len(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+
+	userId, err := utils.ConsumePasswordResetToken(ctx, s.redisClient, token)
+	if err != nil {
+		return err
+	}
+
+	hashPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdatePassword(ctx, userId, hashPassword); err != nil {
+		return err
+	}
+
+	if err := s.repo.DeleteUserSessionFromRedis(ctx, fmt.Sprintf("userId:usersession:%s", userId)); err != nil {
+		fmt.Printf("WARNING: failed to delete session of user %s: %v\n", userId, err)
+	}
+	if err := utils.RevokeUserTokens(ctx, s.redisClient, userId); err != nil {
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (s *userService) GetProfile(ctx context.Context, userId string) (*models.User, error) {
+	user, err := s.repo.GetUserByID(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *userService) UpdateProfile(ctx context.Context, userId string, profile *models.UpdateProfileRequest) (*models.User, error) {
+	if profile.Username != nil {
+		name := strings.TrimSpace(*profile.Username)
+		if name == "" {
+			return nil, ErrInvalidProfile
+		}
+		profile.Username = &name
+	}
+
+	user, err := s.repo.UpdateProfile(ctx, userId, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cached session still holds the old profile
+	if err := s.repo.DeleteUserSessionFromRedis(ctx, fmt.Sprintf("userId:usersession:%s", userId)); err != nil {
+		fmt.Printf("WARNING: failed to delete session of user %s: %v\n", userId, err)
+	}
+	return user, nil
+}
+
+func (s *userService) ChangePassword(ctx context.Context, userId, currentPassword, newPassword string) (*models.User, error) {
+	if len(newPassword) < minPasswordLength {
+		return nil, ErrWeakPassword
+	}
+
+	user, err := s.reauthenticate(ctx, userId, currentPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	hashPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdatePassword(ctx, userId, hashPassword); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.DeleteUserSessionFromRedis(ctx, fmt.Sprintf("userId:usersession:%s", userId)); err != nil {
+		fmt.Printf("WARNING: failed to delete session of user %s: %v\n", userId, err)
+	}
+	if err := utils.RevokeUserTokens(ctx, s.redisClient, userId); err != nil {
+		return nil, fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *userService) RequestEmailChange(ctx context.Context, userId, newEmail, password string) error {
+	newEmail = strings.TrimSpace(newEmail)
+
+	user, err := s.reauthenticate(ctx, userId, password)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(user.Email, newEmail) {
+		return fmt.Errorf("%w: the new email is the current one", ErrInvalidProfile)
+	}
+
+	existingUser, err := s.repo.GetUserByEmail(newEmail)
+	if err != nil {
+		return fmt.Errorf("failed to check existing user : %w", err)
+	}
+	if existingUser != nil {
+		return repository.ErrEmailExists
+	}
+
+	token, err := utils.SaveEmailChangeToken(ctx, s.redisClient, utils.EmailChange{UserID: userId, NewEmail: newEmail})
+	if err != nil {
+		return fmt.Errorf("failed to create token : %v", err)
+	}
+
+	confirmLink := fmt.Sprintf("%s/confirm-email-change?token=%s", config.AppConfig.FrontEndUrl, token)
+	if err := utils.SendEmailChangeEmail(newEmail, confirmLink); err != nil {
+		return fmt.Errorf("failed to send email change message : %v", err)
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange applies a confirmed email change. Tokens carry the email, so the ones
+// issued before the change are revoked and the user logs in again with the new email
+func (s *userService) ConfirmEmailChange(ctx context.Context, token string) (*models.User, error) {
+	change, err := utils.ConsumeEmailChangeToken(ctx, s.redisClient, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// The unique email column settles a race with another user taking the email meanwhile
+	if err := s.repo.UpdateEmail(ctx, change.UserID, change.NewEmail); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.DeleteUserSessionFromRedis(ctx, fmt.Sprintf("userId:usersession:%s", change.UserID)); err != nil {
+		fmt.Printf("WARNING: failed to delete session of user %s: %v\n", change.UserID, err)
+	}
+	if err := utils.RevokeUserTokens(ctx, s.redisClient, change.UserID); err != nil {
+		return nil, fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+
+	return s.GetProfile(ctx, change.UserID)
+}
+
+// reauthenticate checks the password of a signed in user before a sensitive change
+func (s *userService) reauthenticate(ctx context.Context, userId, password string) (*models.User, error) {
+	user, err := s.GetProfile(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, ErrIncorrectPassword
+	}
+	return user, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -42,7 +43,74 @@ func generateToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// ErrInvalidResetToken is returned for password reset tokens that are unknown, used or expired
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
 func SaveVerificationToken(ctx context.Context, rdb *redis.Client, userId string) (string, error) {
+	return saveToken(ctx, rdb, "verifyToken:", userId, 8*time.Minute)
+}
+
+// SavePasswordResetToken stores a single use token that lets the user set a new password
+func SavePasswordResetToken(ctx context.Context, rdb *redis.Client, userId string) (string, error) {
+	return saveToken(ctx, rdb, "resetToken:", userId, 15*time.Minute)
+}
+
+// ConsumePasswordResetToken returns the user of a reset token and deletes the token in the same step,
+// so it can only ever be used once
+func ConsumePasswordResetToken(ctx context.Context, rdb *redis.Client, token string) (string, error) {
+	if token == "" {
+		return "", ErrInvalidResetToken
+	}
+	userId, err := rdb.GetDel(ctx, "resetToken:"+token).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return userId, nil
+}
+
+// ErrInvalidEmailChangeToken is returned for email change tokens that are unknown, used or expired
+var ErrInvalidEmailChangeToken = errors.New("invalid or expired email change token")
+
+// EmailChange is a requested change of a user's email, waiting for the new address to be confirmed
+type EmailChange struct {
+	UserID   string `json:"userId"`
+	NewEmail string `json:"newEmail"`
+}
+
+// SaveEmailChangeToken stores a single use token that confirms the new email of a user
+func SaveEmailChangeToken(ctx context.Context, rdb *redis.Client, change EmailChange) (string, error) {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return "", err
+	}
+	return saveToken(ctx, rdb, "emailChangeToken:", string(data), 30*time.Minute)
+}
+
+// ConsumeEmailChangeToken returns the change of a token and deletes the token in the same step
+func ConsumeEmailChangeToken(ctx context.Context, rdb *redis.Client, token string) (*EmailChange, error) {
+	if token == "" {
+		return nil, ErrInvalidEmailChangeToken
+	}
+	data, err := rdb.GetDel(ctx, "emailChangeToken:"+token).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidEmailChangeToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var change EmailChange
+	if err := json.Unmarshal([]byte(data), &change); err != nil {
+		return nil, fmt.Errorf("failed to decode email change token: %v", err)
+	}
+	return &change, nil
+}
+
+// saveToken stores value under a new random token that does not exist yet
+func saveToken(ctx context.Context, rdb *redis.Client, prefix, value string, ttl time.Duration) (string, error) {
 	const maxAttempts = 5
 
 	for i := 0; i < maxAttempts; i++ {
@@ -50,9 +118,9 @@ func SaveVerificationToken(ctx context.Context, rdb *redis.Client, userId string
 		if err != nil {
 			return "", err
 		}
-		key := "verifyToken:" + token
+		key := prefix + token
 
-		success, err := rdb.SetNX(ctx, key, userId, 8*time.Minute).Result()
+		success, err := rdb.SetNX(ctx, key, value, ttl).Result()
 		if err != nil {
 			return "", err
 		}
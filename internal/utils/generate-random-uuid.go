@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -60,6 +61,38 @@ func GenerateEsewaSignature(
 	return signature
 }
 
+// GenerateEsewaSignatureForFields signs the fields listed in signedFieldNames in that order,
+// this is how eSewa signs the data it sends back to the success url
+func GenerateEsewaSignatureForFields(signedFieldNames string, fields map[string]string, secretKey string) (string, error) {
+	if secretKey == "" {
+		return "", fmt.Errorf("ESEWA_SECRET_KEY is required")
+	}
+
+	names := strings.Split(signedFieldNames, ",")
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		value, ok := fields[name]
+		if !ok {
+			return "", fmt.Errorf("signed field %s is missing", name)
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	hash := hmac.New(sha256.New, []byte(secretKey))
+	hash.Write([]byte(strings.Join(parts, ",")))
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// VerifyEsewaSignature checks the signature eSewa attached to a callback payload
+func VerifyEsewaSignature(signedFieldNames string, fields map[string]string, signature string, secretKey string) bool {
+	expected, err := GenerateEsewaSignatureForFields(signedFieldNames, fields, secretKey)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
 // Alternative signature generation method - try this if the above doesn't work
 func GenerateEsewaSignatureAlternative(
 	totalAmount string,
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Tokens are signed with one private key and verified with any key of the key directory.
+// Each key is a PEM file named <kid>.pem, RSA keys are used with RS256 and Ed25519 keys with EdDSA.
+//
+// Rotating the signing key:
+//  1. Add the new private key to the key directory, e.g. with `go run ./cmd/jwtkey`, and restart.
+//     It is published in /.well-known/jwks.json but nothing is signed with it yet
+//  2. Once other services picked up the new JWKS, point JWT_SIGNING_KEY_ID at the new kid and restart
+//  3. After ACCESS_TOKEN_TTL passed no valid token uses the old key anymore, delete its file and restart
+//
+// Without a signing key id tokens are signed with HS256 and JWT_SECRET as before. HS256 tokens are
+// accepted while JWT_ACCEPT_HS256 is true, so tokens issued before the switch stay valid until they expire.
+
+const minRSAKeyBits = 2048
+
+type jwtKey struct {
+	id     string
+	method jwt.SigningMethod
+	// private is nil for keys that only verify tokens
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+type jwtKeySet struct {
+	signing     *jwtKey
+	keys        map[string]*jwtKey
+	acceptHS256 bool
+}
+
+// jwtKeys is nil until InitJWTKeys ran, tokens are then signed and verified with HS256 only
+var jwtKeys *jwtKeySet
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv and X are set for Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// InitJWTKeys loads the keys of dir and selects the signing key. An empty dir keeps HS256 signing,
+// which like accepting HS256 tokens needs secret to be set
+func InitJWTKeys(dir, signingKeyID, secret string, acceptHS256 bool) error {
+	keySet := &jwtKeySet{keys: make(map[string]*jwtKey), acceptHS256: acceptHS256}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return fmt.Errorf("failed to list jwt keys: %v", err)
+		}
+		for _, file := range files {
+			key, err := loadJWTKey(file)
+			if err != nil {
+				return err
+			}
+			keySet.keys[key.id] = key
+		}
+	}
+
+	if signingKeyID != "" {
+		key, ok := keySet.keys[signingKeyID]
+		if !ok {
+			return fmt.Errorf("jwt signing key %s not found in %q", signingKeyID, dir)
+		}
+		if key.private == nil {
+			return fmt.Errorf("jwt signing key %s is a public key", signingKeyID)
+		}
+		keySet.signing = key
+	} else if !acceptHS256 {
+		return fmt.Errorf("JWT_ACCEPT_HS256 can only be disabled with a signing key")
+	}
+	if acceptHS256 && secret == "" {
+		return fmt.Errorf("JWT_SECRET is required while JWT_ACCEPT_HS256 is enabled")
+	}
+
+	jwtKeys = keySet
+	log.Printf("🔑 Loaded %d jwt keys, signing with %q", len(keySet.keys), signingKeyID)
+	return nil
+}
+
+func loadJWTKey(file string) (*jwtKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwt key %s: %v", file, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt key %s is not PEM encoded", file)
+	}
+
+	key := &jwtKey{id: strings.TrimSuffix(filepath.Base(file), ".pem")}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwt key %s: %v", file, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("jwt key %s can not sign", file)
+		}
+		key.private = signer
+		key.public = signer.Public()
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwt key %s: %v", file, err)
+		}
+		key.private = parsed
+		key.public = parsed.Public()
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwt key %s: %v", file, err)
+		}
+		key.public = parsed
+	default:
+		return nil, fmt.Errorf("jwt key %s has unsupported PEM type %s", file, block.Type)
+	}
+
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("jwt key %s must have at least %d bits", file, minRSAKeyBits)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("jwt key %s must be an RSA or Ed25519 key", file)
+	}
+	return key, nil
+}
+
+// signToken signs claims with the signing key, or with HS256 and secret when there is none
+func signToken(claims jwt.Claims, secret string) (string, error) {
+	if jwtKeys == nil || jwtKeys.signing == nil {
+		if secret == "" {
+			return "", fmt.Errorf("invalid jwt secret")
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	}
+
+	token := jwt.NewWithClaims(jwtKeys.signing.method, claims)
+	token.Header["kid"] = jwtKeys.signing.id
+	return token.SignedString(jwtKeys.signing.private)
+}
+
+// verificationKey returns the key a token has to be signed with, chosen by its alg and kid headers
+func verificationKey(token *jwt.Token, secret string) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		// An empty secret would let anyone sign tokens
+		if secret == "" {
+			return nil, fmt.Errorf("invalid jwt secret")
+		}
+		signingWithSecret := jwtKeys == nil || jwtKeys.signing == nil
+		if !signingWithSecret && !jwtKeys.acceptHS256 {
+			return nil, fmt.Errorf("HS256 tokens are no longer accepted")
+		}
+		return []byte(secret), nil
+	}
+
+	if jwtKeys == nil {
+		return nil, fmt.Errorf("unexpected signing method : %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := jwtKeys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown jwt key id : %q", kid)
+	}
+	if key.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("jwt key %s does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// PublicJWKS returns the public part of every loaded key for other services to verify tokens with
+func PublicJWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	if jwtKeys == nil {
+		return set
+	}
+
+	for _, key := range jwtKeys.keys {
+		jwk := JWK{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -1,17 +1,25 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"e-commerce.com/internal/config"
+	"e-commerce.com/internal/models"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
 )
 
 type Claims struct {
-	UserId   string `json:"userId"`
-	FullName string `json:"fullName"`
-	Email    string `json:"email"`
+	UserId   string      `json:"userId"`
+	FullName string      `json:"fullName"`
+	Email    string      `json:"email"`
+	Role     models.Role `json:"role"`
+	// FamilyID is the refresh token family the token was issued for
+	FamilyID string `json:"fid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -19,6 +27,8 @@ type JwtDataType struct {
 	UserId   string
 	FullName string
 	Email    string
+	Role     models.Role
+	FamilyID string
 }
 
 func GenerateJWT(jwtData JwtDataType) (string, error) {
@@ -26,25 +36,21 @@ func GenerateJWT(jwtData JwtDataType) (string, error) {
 		return "", fmt.Errorf("invalid user data")
 	}
 
-	jwtSecret := config.AppConfig.JWTSecret
-
-	if jwtSecret == "" {
-		return "", fmt.Errorf("invalid jwt secret")
-	}
-
 	claims := &Claims{
 		UserId:   jwtData.UserId,
 		FullName: jwtData.FullName,
 		Email:    jwtData.Email,
+		Role:     jwtData.Role,
+		FamilyID: jwtData.FamilyID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
+			ID:        GenerateRandomUUID(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.AppConfig.AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "golang-backend",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	signedToken, err := token.SignedString([]byte(jwtSecret))
+	signedToken, err := signToken(claims, config.AppConfig.JWTSecret)
 
 	if err != nil {
 		return "", err
@@ -58,14 +64,97 @@ func ParseJwt(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("no valid token string")
 	}
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexprected signing method : %v", token.Header["alg"])
-		}
-		return []byte(config.AppConfig.JWTSecret), nil
-	})
+		return verificationKey(token, config.AppConfig.JWTSecret)
+	}, jwt.WithValidMethods([]string{
+		jwt.SigningMethodHS256.Alg(),
+		jwt.SigningMethodRS256.Alg(),
+		jwt.SigningMethodEdDSA.Alg(),
+	}))
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
 		return claims, nil
 	}
 	return nil, err
 }
+
+func tokensRevokedAtKey(userId string) string {
+	return fmt.Sprintf("userId:tokensRevokedAt:%s", userId)
+}
+
+func deniedTokenKey(jti string) string {
+	return fmt.Sprintf("jwt:denylist:%s", jti)
+}
+
+// RevokeUserTokens makes every access and refresh token issued to the user until now invalid.
+// The marker only has to outlive the tokens it revokes
+func RevokeUserTokens(ctx context.Context, rdb *redis.Client, userId string) error {
+	return rdb.Set(ctx, tokensRevokedAtKey(userId), time.Now().Unix(), config.AppConfig.RefreshTokenTTL).Err()
+}
+
+// tokensRevokedAt returns when the user's tokens were last revoked, zero when they never were
+func tokensRevokedAt(ctx context.Context, rdb *redis.Client, userId string) (int64, error) {
+	value, err := rdb.Get(ctx, tokensRevokedAtKey(userId)).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	revokedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token revocation time for user %s: %v", userId, err)
+	}
+	return revokedAt, nil
+}
+
+// DenyToken puts a single access token on the denylist until it expires, e.g. on logout
+func DenyToken(ctx context.Context, rdb *redis.Client, claims *Claims) error {
+	if claims.ID == "" {
+		return errors.New("token has no jti to deny")
+	}
+
+	ttl := config.AppConfig.AccessTokenTTL
+	if claims.ExpiresAt != nil {
+		ttl = time.Until(claims.ExpiresAt.Time)
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	return rdb.Set(ctx, deniedTokenKey(claims.ID), claims.UserId, ttl).Err()
+}
+
+// IsTokenRevoked reports whether an access token was logged out, belongs to a revoked
+// refresh token family or was issued before the user's tokens were revoked
+func IsTokenRevoked(ctx context.Context, rdb *redis.Client, claims *Claims) (bool, error) {
+	if claims.ID != "" {
+		denied, err := rdb.Exists(ctx, deniedTokenKey(claims.ID)).Result()
+		if err != nil {
+			return false, err
+		}
+		if denied > 0 {
+			return true, nil
+		}
+	}
+
+	if claims.FamilyID != "" {
+		active, err := IsTokenFamilyActive(ctx, rdb, claims.FamilyID)
+		if err != nil {
+			return false, err
+		}
+		if !active {
+			return true, nil
+		}
+	}
+
+	revokedAt, err := tokensRevokedAt(ctx, rdb, claims.UserId)
+	if err != nil || revokedAt == 0 {
+		return false, err
+	}
+
+	// Tokens from before issue times were recorded can not prove they are newer
+	if claims.IssuedAt == nil {
+		return true, nil
+	}
+	return claims.IssuedAt.Unix() < revokedAt, nil
+}
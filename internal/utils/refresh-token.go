@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token that was already rotated is used
+	// again. The token was most likely stolen, so its whole family is revoked
+	ErrRefreshTokenReused = errors.New("refresh token was already used, please log in again")
+)
+
+// RefreshTokenRecord is what is stored in Redis for an issued refresh token
+type RefreshTokenRecord struct {
+	UserID   string    `json:"userId"`
+	Email    string    `json:"email"`
+	FamilyID string    `json:"familyId"`
+	IssuedAt time.Time `json:"issuedAt"`
+}
+
+func refreshFamilyKey(familyId string) string {
+	return fmt.Sprintf("refreshFamily:%s", familyId)
+}
+
+// StartTokenFamily starts a new refresh token family for a login. Every refresh token
+// rotated from the login's first one belongs to the same family
+func StartTokenFamily(ctx context.Context, rdb *redis.Client, userId string, ttl time.Duration) (string, error) {
+	familyId := GenerateRandomUUID()
+	if err := rdb.Set(ctx, refreshFamilyKey(familyId), userId, ttl).Err(); err != nil {
+		return "", err
+	}
+	return familyId, nil
+}
+
+// RevokeTokenFamily invalidates every refresh token of the family and the access tokens issued with them
+func RevokeTokenFamily(ctx context.Context, rdb *redis.Client, familyId string) error {
+	return rdb.Del(ctx, refreshFamilyKey(familyId)).Err()
+}
+
+func IsTokenFamilyActive(ctx context.Context, rdb *redis.Client, familyId string) (bool, error) {
+	exists, err := rdb.Exists(ctx, refreshFamilyKey(familyId)).Result()
+	if err != nil {
+		return false, err
+	}
+	return exists > 0, nil
+}
+
+// SaveRefreshToken stores a new refresh token of the record's family and keeps the family
+// alive for as long as its newest token
+func SaveRefreshToken(ctx context.Context, rdb *redis.Client, record RefreshTokenRecord, ttl time.Duration) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := saveToken(ctx, rdb, "refreshToken:", string(data), ttl)
+	if err != nil {
+		return "", err
+	}
+
+	if err := rdb.Expire(ctx, refreshFamilyKey(record.FamilyID), ttl).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken uses up a refresh token and returns its record so a new one can be issued.
+// A token can only be rotated once, using it a second time revokes its whole family
+func RotateRefreshToken(ctx context.Context, rdb *redis.Client, token string) (*RefreshTokenRecord, error) {
+	if token == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	key := "refreshToken:" + token
+	data, err := rdb.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record RefreshTokenRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, fmt.Errorf("failed to decode refresh token: %v", err)
+	}
+
+	active, err := IsTokenFamilyActive(ctx, rdb, record.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	revokedAt, err := tokensRevokedAt(ctx, rdb, record.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if record.IssuedAt.Unix() < revokedAt {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// The token itself stays stored until it expires, so a later reuse is still recognised
+	ttl, err := rdb.TTL(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+	claimed, err := rdb.SetNX(ctx, key+":used", time.Now().Unix(), ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		if err := RevokeTokenFamily(ctx, rdb, record.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %v", err)
+		}
+		fmt.Printf("WARNING: Refresh token of family %s reused, family revoked for user %s\n", record.FamilyID, record.UserID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	return &record, nil
+}
@@ -12,13 +12,6 @@ import (
 )
 
 func SendVerificationEmail(to, verificationLink string) error {
-	smtpHost := "smtp.gmail.com"
-	smtpPort := "587"
-	senderEmail := config.AppConfig.SMTPEmail
-	appPassword := config.AppConfig.SMTPPassword
-
-	// Email content
-	subject := "Verify Your Email Address"
 	body := fmt.Sprintf(`
 	<html>
 		<body>
@@ -29,6 +22,43 @@ func SendVerificationEmail(to, verificationLink string) error {
 		</body>
 	</html>`, verificationLink)
 
+	return sendEmail(to, "Verify Your Email Address", body)
+}
+
+func SendPasswordResetEmail(to, resetLink string) error {
+	body := fmt.Sprintf(`
+	<html>
+		<body>
+			<h2>Reset Your Password</h2>
+			<p>Please click the link below to choose a new password. The link works once and expires in 15 minutes:</p>
+			<a href="%s">Reset Password</a>
+			<p>If you didn't request this, please ignore this email, your password stays the same.</p>
+		</body>
+	</html>`, resetLink)
+
+	return sendEmail(to, "Reset Your Password", body)
+}
+
+func SendEmailChangeEmail(to, confirmLink string) error {
+	body := fmt.Sprintf(`
+	<html>
+		<body>
+			<h2>Confirm Your New Email Address</h2>
+			<p>Please click the link below to use this email address for your account. The link works once and expires in 30 minutes:</p>
+			<a href="%s">Confirm Email</a>
+			<p>If you didn't request this, please ignore this email.</p>
+		</body>
+	</html>`, confirmLink)
+
+	return sendEmail(to, "Confirm Your New Email Address", body)
+}
+
+func sendEmail(to, subject, body string) error {
+	smtpHost := "smtp.gmail.com"
+	smtpPort := "587"
+	senderEmail := config.AppConfig.SMTPEmail
+	appPassword := config.AppConfig.SMTPPassword
+
 	// Email headers
 	headers := map[string]string{
 		"From":         senderEmail,